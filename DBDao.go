@@ -35,6 +35,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/oouxx/zorm/v2/decimal"
@@ -79,6 +80,10 @@ type DataSourceConfig struct {
 	//DSN dataSourceName 连接字符串
 	//DSN DataSourceName Database connection string
 	DSN string
+	//DSNProvider 动态获取连接字符串,优先级高于DSN,低于SQLDB.打开连接池和每次创建新的数据库连接时调用,用于定期轮换的密码等短期凭证
+	//内置zorm.NewFileDSNProvider和zorm.NewEnvDSNProvider
+	//DSNProvider Get the connection string dynamically, which takes precedence over DSN and below SQLDB. Called when the pool is opened and each new connection is created
+	DSNProvider DSNProvider
 	//DriverName 数据库驱动名称:mysql,postgres,oci8,sqlserver,sqlite3,go_ibm_db,clickhouse,dm,kingbase,aci,taosSql|taosRestful 和Dialect对应
 	//DriverName:mysql,dm,postgres,opi8,sqlserver,sqlite3,go_ibm_db,clickhouse,kingbase,aci,taosSql|taosRestful corresponds to Dialect
 	DriverName string
@@ -91,6 +96,20 @@ type DataSourceConfig struct {
 	DBType string
 	//SlowSQLMillis 慢sql的时间阈值,单位毫秒.小于0是禁用SQL语句输出;等于0是只输出SQL语句,不计算执行时间;大于0是计算SQL执行时间,并且>=SlowSQLMillis值
 	SlowSQLMillis int
+	//LogLevel 配置了zorm.DefaultLogger时,这个DBDao的日志级别,默认LogLevelInfo.SQL语句是LogLevelInfo,慢SQL是LogLevelWarn,
+	//SlowSQLMillis大于0时没有超过阈值的SQL语句是LogLevelDebug
+	//LogLevel The log level of this DBDao when zorm.DefaultLogger is configured, default LogLevelInfo
+	LogLevel LogLevel
+	//SlowQueryAnalyzer 慢查询分析器,默认nil不分析.SlowSQLMillis大于0时,超过阈值的SELECT语句在事务之外异步执行EXPLAIN,执行计划发送给SlowQueryAnalyzer.Sink
+	//SlowQueryAnalyzer The slow query analyzer, default nil. When SlowSQLMillis is greater than 0, EXPLAIN is executed asynchronously outside the transaction for slow SELECT statements
+	SlowQueryAnalyzer *SlowQueryAnalyzer
+	//StatementStats 按照SQL指纹聚合统计执行次数,执行时间,错误和行数,默认false.使用DBDao.StatementStats()和zorm.NewStatementStatsHandler获取
+	//StatementStats Aggregate calls, latency, errors and rows per SQL fingerprint, default false. Use DBDao.StatementStats() and zorm.NewStatementStatsHandler
+	StatementStats bool
+	//SQLCommenter 执行前在SQL语句末尾增加sqlcommenter格式的注释,例如 /*application='order',caller='order.go%3A42',route='%2Fapi%2Forder'*/
+	//值来自zorm.BindContextSQLComment绑定的ctx和业务调用的文件:行号,便于DBA从慢查询日志和pg_stat_activity定位接口.默认false
+	//SQLCommenter Append a sqlcommenter style comment to the end of SQL before execution, values come from the ctx bound by zorm.BindContextSQLComment and the caller's file:line. Default false
+	SQLCommenter bool
 	//MaxOpenConns 数据库最大连接数,默认50
 	//MaxOpenConns Maximum number of database connections, Default 50
 	MaxOpenConns int
@@ -105,6 +124,20 @@ type DataSourceConfig struct {
 	//DefaultTxOptions 事务隔离级别的默认配置,默认为nil
 	DefaultTxOptions *sql.TxOptions
 
+	//DefaultQueryTimeout 单条语句的默认超时时间,默认0不限制.可以使用zorm.BindContextQueryTimeout(ctx,timeout)覆盖
+	//postgresql事务内使用 SET LOCAL statement_timeout,mysql的SELECT语句使用 MAX_EXECUTION_TIME hint,让数据库也终止超时的语句
+	//DefaultQueryTimeout The default timeout of a single statement, default 0 no limit. Can be overridden by zorm.BindContextQueryTimeout(ctx,timeout)
+	DefaultQueryTimeout time.Duration
+
+	//DefaultTxTimeout 事务的默认超时时间,默认0不限制,从开启事务开始计算,超时之后事务会被回滚,返回TimeoutError.可以使用zorm.BindContextTxTimeout(ctx,timeout)覆盖
+	//DefaultTxTimeout The default transaction timeout, default 0 no limit. A timed out transaction is rolled back and TimeoutError is returned
+	DefaultTxTimeout time.Duration
+
+	//TxRetryPolicy 事务的重试策略,默认为nil不重试.死锁和序列化失败等错误,使用新的事务重新执行zorm.Transaction的doTransaction
+	//可以使用zorm.BindContextTxRetryPolicy(ctx,policy)覆盖单个事务的重试策略
+	//TxRetryPolicy Transaction retry policy, default nil does not retry. For deadlock and serialization failure, re-run doTransaction with a new transaction
+	TxRetryPolicy *TxRetryPolicy
+
 	//DisableTransaction 禁用事务,默认false,如果设置了DisableTransaction=true,Transaction方法失效,不再要求有事务.为了处理某些数据库不支持事务,比如TDengine
 	//禁用事务应该有驱动伪造事务API,不应该由orm实现
 	DisableTransaction bool
@@ -130,6 +163,12 @@ type DataSourceConfig struct {
 type DBDao struct {
 	config     *DataSourceConfig
 	dataSource *dataSource
+	//mutex 保护config和dataSource,Reconfigure会替换
+	mutex sync.RWMutex
+	//reconfigureMutex 保证Reconfigure串行执行
+	reconfigureMutex sync.Mutex
+	//stats 执行语句和事务的计数器,Reconfigure之后继续累计
+	stats *dbDaoStats
 }
 
 var defaultDao *DBDao = nil
@@ -139,22 +178,29 @@ var defaultDao *DBDao = nil
 // NewDBDao Creates dbDao, a database must be executed only once, and the business is controlled by itself
 // The first database to be executed is defaultDao, and the subsequent zorm.xxx method is defaultDao by default
 func NewDBDao(config *DataSourceConfig) (*DBDao, error) {
-	dataSource, err := newDataSource(config)
-
+	dbDao, err := newDBDao(config)
 	if err != nil {
-		err = fmt.Errorf("->NewDBDao创建dataSource失败:%w", err)
 		FuncLogError(nil, err)
 		return nil, err
 	}
 	dbdao, err := FuncReadWriteStrategy(nil, 1)
 	if dbdao == nil {
-		defaultDao = &DBDao{config, dataSource}
+		defaultDao = dbDao
 		return defaultDao, nil
 	}
 	if err != nil {
 		return dbdao, err
 	}
-	return &DBDao{config, dataSource}, nil
+	return dbDao, nil
+}
+
+// newDBDao 创建dbDao,不设置defaultDao
+func newDBDao(config *DataSourceConfig) (*DBDao, error) {
+	dataSource, err := newDataSource(config)
+	if err != nil {
+		return nil, fmt.Errorf("->NewDBDao创建dataSource失败:%w", err)
+	}
+	return &DBDao{config: config, dataSource: dataSource, stats: &dbDaoStats{}}, nil
 }
 
 /*
@@ -175,12 +221,17 @@ func getDefaultDao(ctx context.Context, rwType int) (*DBDao, error) {
 // If the parameter db Connection is nil, use the default datasource to get db Connection.
 // If it is multi-database, Dao manually calls new DB Connection() to obtain db Connection, and With Value is bound to the sub-context
 func (dbDao *DBDao) newDBConnection() (*dataBaseConnection, error) {
-	if dbDao == nil || dbDao.dataSource == nil {
+	if dbDao == nil {
+		return nil, errors.New("->newDBConnection-->请不要自己创建dbDao,使用NewDBDao方法进行创建")
+	}
+	config, dataSource := dbDao.loadDataSource()
+	if dataSource == nil {
 		return nil, errors.New("->newDBConnection-->请不要自己创建dbDao,使用NewDBDao方法进行创建")
 	}
 	dbConnection := new(dataBaseConnection)
-	dbConnection.db = dbDao.dataSource.DB
-	dbConnection.config = dbDao.config
+	dbConnection.db = dataSource.DB
+	dbConnection.config = config
+	dbConnection.dbDao = dbDao
 	return dbConnection, nil
 }
 
@@ -212,10 +263,27 @@ func (dbDao *DBDao) BindContextTxOptions(parent context.Context, txOptions *sql.
 // CloseDB 关闭所有数据库连接
 // 请谨慎调用这个方法,会关闭所有数据库连接,用于处理特殊场景,正常使用无需手动关闭数据库连接
 func (dbDao *DBDao) CloseDB() error {
-	if dbDao == nil || dbDao.dataSource == nil {
+	if dbDao == nil {
 		return errors.New("->CloseDB-->请不要自己创建dbDao,使用NewDBDao方法进行创建")
 	}
-	return dbDao.dataSource.Close()
+	_, dataSource := dbDao.loadDataSource()
+	if dataSource == nil {
+		return errors.New("->CloseDB-->请不要自己创建dbDao,使用NewDBDao方法进行创建")
+	}
+	return dataSource.Close()
+}
+
+// Dialect 数据库方言,Reconfigure之后返回新的配置
+// Dialect The database dialect, returns the new config after Reconfigure
+func (dbDao *DBDao) Dialect() string {
+	if dbDao == nil {
+		return ""
+	}
+	config, _ := dbDao.loadDataSource()
+	if config == nil {
+		return ""
+	}
+	return config.Dialect
 }
 
 /*
@@ -240,6 +308,8 @@ Transaction 的示例代码
 // bug(springrain)如果有大神修改了匿名函数内的参数名,例如改为ctx2,这样业务代码实际使用的是Transaction的context参数,如果为没有dbConnection,会抛异常,如果有dbConnection,实际就是一个对象.影响有限.也可以把匿名函数抽到外部
 // 如果zorm.DataSourceConfig.DefaultTxOptions配置不满足需求,可以在zorm.Transaction事务方法前设置事务的隔离级别,例如 ctx, _ := dbDao.BindContextTxOptions(ctx, &sql.TxOptions{Isolation: sql.LevelDefault}),如果txOptions为nil,使用zorm.DataSourceConfig.DefaultTxOptions
 // return的error如果不为nil,事务就会回滚
+// 如果ctx已经有事务,并且使用zorm.BindContextNestedTransaction(ctx)启用了嵌套事务,会创建保存点,出现错误只回滚到保存点,不影响外层事务
+// 可以使用zorm.BindContextTxPropagation(ctx, zorm.PropagationRequiresNew)设置事务的传播行为,例如审计日志需要独立提交,不受外层事务回滚的影响
 // 如果使用了分布式事务,需要设置分布式事务函数zorm.DataSourceConfig.FuncGlobalTransaction,实现IGlobalTransaction接口
 // 如果是分布式事务开启方,需要在本地事务前开启分布事务,开启之后获取XID,设值到ctx的XID和TX_XID.XID是seata/hptx MySQL驱动需要,TX_XID是gtxContext.NewRootContext需要
 // 分布式事务需要传递XID,接收方context.WithValue(ctx, "XID", XID)绑定到ctx
@@ -259,24 +329,91 @@ Transaction 的示例代码
 // an exception will be thrown. If there is a db Connection, the actual It is an object
 // The impact is limited. Anonymous functions can also be extracted outside
 // If the return error is not nil, the transaction will be rolled back
+// If ctx already has a transaction and zorm.BindContextNestedTransaction(ctx) is used, a savepoint is created, an error only rolls back to the savepoint
+// Use zorm.BindContextTxPropagation(ctx, zorm.PropagationRequiresNew) to set the propagation, for example audit log commits independently of the outer transaction
 func Transaction(ctx context.Context, doTransaction func(ctx context.Context) (interface{}, error)) (interface{}, error) {
 	return transaction(ctx, doTransaction)
 }
 
 var transaction = func(ctx context.Context, doTransaction func(ctx context.Context) (interface{}, error)) (info interface{}, err error) {
+	//事务的span,doTransaction内SQL语句的span是它的子span
+	//The span of the transaction, spans of statements in doTransaction are its children
+	ctx, span := startSpan(ctx, "zorm.transaction")
+	if span != nil {
+		defer func() {
+			endSpan(span, err)
+		}()
+	}
+	//如果配置了重试策略,死锁和序列化失败等错误,使用新的事务重新执行doTransaction
+	//If the retry policy is configured, re-run doTransaction with a new transaction for errors such as deadlock and serialization failure
+	for attempt := 1; ; attempt++ {
+		retryState := txRetryState{}
+		info, err = execTransaction(ctx, doTransaction, &retryState)
+		retryPolicy := retryState.policy
+		if err == nil || retryPolicy == nil || attempt >= retryPolicy.MaxAttempts || !retryPolicy.retryable(retryState.dialect, err) {
+			return info, err
+		}
+		backoff := retryPolicy.backoff(attempt)
+		FuncLogError(ctx, fmt.Errorf("->Transaction-->第%d次执行失败,%v后重试:%w", attempt, backoff, err))
+		if errWait := waitTxRetryBackoff(ctx, backoff); errWait != nil {
+			return info, err
+		}
+	}
+}
+
+// txRetryState 记录execTransaction的重试信息,只有开启事务的Transaction才有重试策略
+type txRetryState struct {
+	policy  *TxRetryPolicy
+	dialect string
+}
+
+// execTransaction 执行一次事务,retryState记录本次事务是否可以重试
+func execTransaction(ctx context.Context, doTransaction func(ctx context.Context) (interface{}, error), retryState *txRetryState) (info interface{}, err error) {
 	//是否是dbConnection的开启方,如果是开启方,才可以提交事务
 	// Whether it is the opener of db Connection, if it is the opener, the transaction can be submitted
 	localTxOpen := false
 	//是否是分布式事务的开启方.如果ctx中没有xid,认为是开启方
 	globalTxOpen := false
+	//嵌套事务的保存点名称,不为空说明本方法创建了保存点
+	//The savepoint name of nested transaction, not empty means this method created a savepoint
+	savepointName := ""
+	//创建保存点时钩子函数的数量,回滚到保存点时丢弃之后注册的钩子函数
+	var savepointHooksMark txHooksMark
 	//如果dbConnection不存在,则会用默认的datasource开启事务
 	// If db Connection does not exist, the default datasource will be used to start the transaction
 	var dbConnection *dataBaseConnection
-	ctx, dbConnection, err = checkDBConnection(ctx, dbConnection, false, 1)
+	//只读事务使用读库
+	//Read-only transaction uses the read database
+	rwType := 1
+	if getContextBoolValue(ctx, contextReadOnlyTransactionValueKey, false) {
+		rwType = 0
+	}
+	ctx, dbConnection, err = checkDBConnection(ctx, dbConnection, false, rwType)
 	if err != nil {
 		FuncLogError(ctx, err)
 		return nil, err
 	}
+	setSpanAttribute(ctx, TraceAttributeDBSystem, dbConnection.config.Dialect)
+
+	//事务的传播行为,只作用于当前的Transaction,doTransaction内部的Transaction默认是PropagationRequired
+	//The propagation only affects the current Transaction, the Transaction inside doTransaction is PropagationRequired by default
+	propagation := getContextTxPropagation(ctx)
+	if propagation != PropagationRequired {
+		ctx = context.WithValue(ctx, contextTxPropagationValueKey, PropagationRequired)
+	}
+	//如果没有禁用事务,根据传播行为处理dbConnection,有可能挂起外层事务或者以非事务方式执行
+	//If the transaction is not disabled, process dbConnection according to the propagation
+	if !getContextBoolValue(ctx, contextDisableTransactionValueKey, dbConnection.config.DisableTransaction) {
+		var noTx bool
+		ctx, dbConnection, noTx, err = wrapTxPropagation(ctx, dbConnection, propagation)
+		if err != nil {
+			FuncLogError(ctx, err)
+			return nil, err
+		}
+		if noTx {
+			return withoutTransaction(ctx, doTransaction)
+		}
+	}
 
 	//适配全局事务的函数
 	funcGlobalTx := dbConnection.config.FuncGlobalTransaction
@@ -287,10 +424,12 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 	var globalRootContext context.Context
 	//分布式事务的异常
 	var errGlobal error
+	//分布式事务是否提交成功,开启方用于执行分支延迟的钩子函数
+	var globalCommitted bool
 
 	//如果没有事务,并且事务没有被禁用,开启事务
 	//开启本地事务前,需要拿到分布式事务对象
-	if dbConnection.tx == nil && (!getContextBoolValue(ctx, contextDisableTransactionValueKey, dbConnection.config.DisableTransaction)) {
+	if !dbConnection.inTransaction() && (!getContextBoolValue(ctx, contextDisableTransactionValueKey, dbConnection.config.DisableTransaction)) {
 		//if dbConnection.tx == nil {
 		//是否使用分布式事务
 		enableGlobalTransaction := funcGlobalTx != nil
@@ -356,9 +495,43 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 			ctx = context.WithValue(ctx, "XID", globalXID)
 			ctx = context.WithValue(ctx, "TX_XID", globalXID)
 		}
+		if xid, ok := ctx.Value("XID").(string); ok && xid != "" {
+			setSpanAttribute(ctx, TraceAttributeXID, xid)
+		}
+
+		//事务的超时时间,从开启本地事务开始计算.使用带有截止时间的ctx开启事务,超时之后database/sql会回滚事务
+		//The transaction timeout starts from beginning the local transaction, database/sql rolls back the transaction when the ctx is done
+		txTimeout := getContextTimeout(ctx, contextTxTimeoutValueKey, dbConnection.config.DefaultTxTimeout)
+		if txTimeout > 0 {
+			parentCtx := ctx
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, txTimeout)
+			txCtx := ctx
+			defer cancel()
+			//超时导致的错误,包装为TimeoutError
+			defer func() {
+				err = wrapTimeoutError(parentCtx, txCtx, TimeoutOperationTransaction, txTimeout, err)
+			}()
+		}
+
+		//分布式事务的开启方,收集其他分支的提交钩子函数,分布式事务提交或者回滚之后再执行
+		//The opener of the global transaction collects the hooks of other branches, and runs them after the global transaction commits or rolls back
+		if globalTxOpen {
+			globalHooks := &globalTxHooks{}
+			ctx = context.WithValue(ctx, contextGlobalTxHooksValueKey, globalHooks)
+			defer func() {
+				globalHooks.run(globalCommitted)
+			}()
+		}
 
 		//开启本地事务/分支事务
-		errBeginTx := dbConnection.beginTx(ctx)
+		//如果分布式事务实现了IGlobalTransactionBranch,由分布式事务接管分支事务,例如XA
+		var errBeginTx error
+		if globalBranch, ok := globalTransaction.(IGlobalTransactionBranch); ok {
+			errBeginTx = dbConnection.beginGlobalBranch(ctx, globalBranch, globalRootContext)
+		} else {
+			errBeginTx = dbConnection.beginTx(ctx)
+		}
 		if errBeginTx != nil {
 			errBeginTx = fmt.Errorf("->Transaction 事务开启失败:%w ", errBeginTx)
 			FuncLogError(ctx, errBeginTx)
@@ -367,6 +540,22 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 		//本方法开启的事务,由本方法提交
 		//The transaction opened by this method is submitted by this method
 		localTxOpen = true
+		//本地事务的开启方才可以重试,分布式事务的分支不重试,由分布式事务的开启方决定
+		//Only the opener of the local transaction can retry, the branch of global transaction does not retry
+		if globalTransaction == nil || globalTxOpen {
+			retryState.policy = getTxRetryPolicy(ctx, dbConnection.config)
+			retryState.dialect = dbConnection.config.Dialect
+		}
+	} else if dbConnection.inTransaction() && propagation == PropagationNested {
+		//已经有事务,并且是嵌套事务,创建保存点,出现错误只回滚到保存点,不影响外层事务
+		//There is already a transaction and it is nested, create a savepoint, only rollback to the savepoint when an error occurs
+		savepointName, err = dbConnection.savepoint(ctx)
+		if err != nil {
+			err = fmt.Errorf("->Transaction-->savepoint嵌套事务保存点创建失败:%w ", err)
+			FuncLogError(ctx, err)
+			return nil, err
+		}
+		savepointHooksMark = dbConnection.txHooksMark()
 	}
 
 	defer func() {
@@ -392,6 +581,16 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 			if getContextBoolValue(ctx, contextDisableTransactionValueKey, dbConnection.config.DisableTransaction) {
 				return
 			}
+			//嵌套事务只回滚到保存点
+			if savepointName != "" {
+				rberr := dbConnection.rollbackToSavepoint(ctx, savepointName)
+				if rberr != nil {
+					rberr = fmt.Errorf("->Transaction-->recover内回滚到保存点失败:%w", rberr)
+					FuncLogError(ctx, rberr)
+				}
+				dbConnection.rollbackTxHooksToMark(ctx, savepointHooksMark)
+				return
+			}
 			rberr := dbConnection.rollback()
 			if rberr != nil {
 				rberr = fmt.Errorf("->Transaction-->recover内事务回滚失败:%w", rberr)
@@ -405,12 +604,22 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 					FuncLogError(ctx, errGlobal)
 				}
 			}
+			//事务开启方执行回滚后的钩子函数
+			if localTxOpen {
+				dbConnection.runAfterRollbackHooks(ctx)
+			}
 
 		}
 	}()
 
+	//只读标记只作用于当前事务的连接,doTransaction内PropagationRequiresNew/NotSupported获取的新连接不继承
+	//The read-only flag only applies to the connection of this transaction, new connections of PropagationRequiresNew/NotSupported in doTransaction do not inherit it
+	txCtx := ctx
+	if rwType == 0 {
+		txCtx = bindReadOnlyScope(ctx, dbConnection)
+	}
 	//执行业务的事务函数
-	info, err = doTransaction(ctx)
+	info, err = doTransaction(txCtx)
 
 	if err != nil {
 		err = fmt.Errorf("->Transaction-->doTransaction业务执行错误:%w", err)
@@ -421,6 +630,18 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 			return info, err
 		}
 
+		//嵌套事务只回滚到保存点,外层事务继续
+		//Nested transaction only rollback to the savepoint, the outer transaction continues
+		if savepointName != "" {
+			errRollback := dbConnection.rollbackToSavepoint(ctx, savepointName)
+			if errRollback != nil {
+				errRollback = fmt.Errorf("->Transaction-->rollbackToSavepoint回滚到保存点失败:%w", errRollback)
+				FuncLogError(ctx, errRollback)
+			}
+			dbConnection.rollbackTxHooksToMark(ctx, savepointHooksMark)
+			return info, err
+		}
+
 		//不是开启方回滚事务,有可能造成日志记录不准确,但是回滚最重要了,尽早回滚
 		//It is not the start party to roll back the transaction, which may cause inaccurate log records,but rollback is the most important, roll back as soon as possible
 		errRollback := dbConnection.rollback()
@@ -436,19 +657,79 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 				FuncLogError(ctx, errGlobal)
 			}
 		}
+		//事务开启方执行回滚后的钩子函数
+		//The transaction opener runs the hooks after rollback
+		if localTxOpen {
+			dbConnection.runAfterRollbackHooks(ctx)
+		}
 		return info, err
 	}
+	//嵌套事务执行成功,释放保存点
+	//Nested transaction succeeded, release the savepoint
+	if savepointName != "" {
+		errRelease := dbConnection.releaseSavepoint(ctx, savepointName)
+		if errRelease != nil {
+			errRelease = fmt.Errorf("->Transaction-->releaseSavepoint释放保存点失败:%w", errRelease)
+			FuncLogError(ctx, errRelease)
+			return info, errRelease
+		}
+	}
 	//如果是事务开启方,提交事务
 	//If it is the transaction opener, commit the transaction
 	if localTxOpen {
+		//提交前的钩子函数,返回错误就回滚事务
+		//The hooks before commit, rollback the transaction if an error is returned
+		errBeforeCommit := dbConnection.runBeforeCommitHooks(ctx)
+		if errBeforeCommit != nil {
+			FuncLogError(ctx, errBeforeCommit)
+			errRollback := dbConnection.rollback()
+			if errRollback != nil {
+				errRollback = fmt.Errorf("->Transaction-->BeforeCommit之后rollback事务回滚失败:%w", errRollback)
+				FuncLogError(ctx, errRollback)
+			}
+			if globalTransaction != nil {
+				errGlobal = globalTransaction.RollbackGTX(ctx, globalRootContext)
+				if errGlobal != nil {
+					errGlobal = fmt.Errorf("->Transaction-->global:BeforeCommit之后回滚globalTransaction事务失败:%w", errGlobal)
+					FuncLogError(ctx, errGlobal)
+				}
+			}
+			dbConnection.runAfterRollbackHooks(ctx)
+			return info, errBeforeCommit
+		}
+		//分布式事务接管的分支,提交只执行了第一阶段的准备,由开启方决定提交还是回滚
+		globalBranch := dbConnection.branch != nil
+		globalBranchPrepare := globalBranch && !globalTxOpen
+		//只读事务或者没有执行更新语句,提交之后不需要记录写入时间
+		//A read-only transaction or one without update statements does not record the write time after commit
+		txWrote := dbConnection.txWrote && !dbConnection.readOnly
 		errCommit := dbConnection.commit()
 		//本地事务提交成功,如果是全局事务的开启方,提交分布式事务
 		if errCommit == nil && globalTxOpen {
 			errGlobal = globalTransaction.CommitGTX(ctx, globalRootContext)
+			//开启方自己的分支,分布式事务结束之后统计结果
+			if globalBranch {
+				dbConnection.statsTx(errGlobal == nil || IsGlobalCommitIncompleteError(errGlobal))
+			}
 			if errGlobal != nil {
 				errGlobal = fmt.Errorf("->Transaction-->global:Transaction-->commit globalTransaction 事务提交失败:%w", errGlobal)
 				FuncLogError(ctx, errGlobal)
+				//已经决定提交,第二阶段由恢复程序完成,按照提交处理,返回错误
+				//The commit is decided and phase two is finished by recovery, treat it as committed and return the error
+				if IsGlobalCommitIncompleteError(errGlobal) {
+					globalCommitted = true
+					if txWrote {
+						markReadYourWrites(ctx)
+					}
+					dbConnection.runAfterCommitHooks(ctx)
+					return info, errGlobal
+				}
+				//分布式事务没有提交,执行回滚后的钩子函数,返回错误
+				//The global transaction is not committed, run the hooks after rollback and return the error
+				dbConnection.runAfterRollbackHooks(ctx)
+				return info, errGlobal
 			}
+			globalCommitted = true
 		}
 		if errCommit != nil {
 			errCommit = fmt.Errorf("->Transaction-->commit事务提交失败:%w", errCommit)
@@ -461,10 +742,27 @@ var transaction = func(ctx context.Context, doTransaction func(ctx context.Conte
 					FuncLogError(ctx, errGlobal)
 				}
 			}
-
+			//提交失败,事务已经回滚,执行回滚后的钩子函数
+			dbConnection.runAfterRollbackHooks(ctx)
 			return info, errCommit
 		}
-
+		//分支准备成功,钩子函数和写入标记延迟到分布式事务提交或者回滚之后
+		//The branch is prepared, hooks and the write mark are deferred until the global transaction commits or rolls back
+		if globalBranchPrepare {
+			if dbConnection.deferGlobalTxHooks(ctx, txWrote) {
+				return info, err
+			}
+			//ctx中没有开启方,按照提交统计
+			dbConnection.statsTx(true)
+		}
+		//记录写入时间,之后的读操作使用主库
+		//Record the write time, the following reads use the primary
+		if txWrote {
+			markReadYourWrites(ctx)
+		}
+		//事务提交成功,执行提交后的钩子函数
+		//The transaction is committed successfully, run the hooks after commit
+		dbConnection.runAfterCommitHooks(ctx)
 	}
 
 	return info, err
@@ -531,7 +829,7 @@ var queryRow = func(ctx context.Context, finder *Finder, entity interface{}) (ha
 
 	//根据语句和参数查询
 	//Query based on statements and parameters
-	rows, errQueryContext := dbConnection.queryContext(ctx, &sqlstr, finder.values)
+	rows, cancelQuery, errQueryContext := dbConnection.queryContext(ctx, &sqlstr, finder.values)
 	if errQueryContext != nil {
 		errQueryContext = fmt.Errorf("->QueryRow-->queryContext查询数据库错误:%w", errQueryContext)
 		FuncLogError(ctx, errQueryContext)
@@ -541,6 +839,8 @@ var queryRow = func(ctx context.Context, finder *Finder, entity interface{}) (ha
 	defer func() {
 		//先判断error 再关闭
 		rows.Close()
+		//释放语句的超时
+		cancelQuery()
 		//捕获panic,赋值给err,避免程序崩溃
 		if r := recover(); r != nil {
 			has = false
@@ -610,6 +910,9 @@ var queryRow = func(ctx context.Context, finder *Finder, entity interface{}) (ha
 		}
 
 	}
+	if has {
+		dbConnection.statsStatementRows(sqlstr, 1)
+	}
 
 	return has, err
 }
@@ -700,7 +1003,7 @@ var query = func(ctx context.Context, finder *Finder, rowsSlicePtr interface{},
 
 	//根据语句和参数查询
 	//Query based on statements and parameters
-	rows, errQueryContext := dbConnection.queryContext(ctx, &sqlstr, finder.values)
+	rows, cancelQuery, errQueryContext := dbConnection.queryContext(ctx, &sqlstr, finder.values)
 	if errQueryContext != nil {
 		errQueryContext = fmt.Errorf("->Query-->queryContext查询rows错误:%w", errQueryContext)
 		FuncLogError(ctx, errQueryContext)
@@ -710,6 +1013,8 @@ var query = func(ctx context.Context, finder *Finder, rowsSlicePtr interface{},
 	defer func() {
 		//先判断error 再关闭
 		rows.Close()
+		//释放语句的超时
+		cancelQuery()
 		//捕获panic,赋值给err,避免程序崩溃
 		if r := recover(); r != nil {
 			var errOk bool
@@ -747,6 +1052,8 @@ var query = func(ctx context.Context, finder *Finder, rowsSlicePtr interface{},
 	//反射获取 []driver.Value的值,用于处理nil值和自定义类型
 	var driverValue = reflect.Indirect(reflect.ValueOf(rows))
 	driverValue = driverValue.FieldByName("lastcols")
+	//查询之前数组的长度,用于统计返回的行数
+	rowsBefore := sliceValue.Len()
 	//循环遍历结果集
 	//Loop through the result set
 	for rows.Next() {
@@ -772,6 +1079,7 @@ var query = func(ctx context.Context, finder *Finder, rowsSlicePtr interface{},
 		}
 
 	}
+	dbConnection.statsStatementRows(sqlstr, sliceValue.Len()-rowsBefore)
 
 	//查询总条数
 	//Query total number
@@ -878,7 +1186,7 @@ var queryMap = func(ctx context.Context, finder *Finder, page *Page) (resultMapL
 
 	//根据语句和参数查询
 	//Query based on statements and parameters
-	rows, errQueryContext := dbConnection.queryContext(ctx, &sqlstr, finder.values)
+	rows, cancelQuery, errQueryContext := dbConnection.queryContext(ctx, &sqlstr, finder.values)
 	if errQueryContext != nil {
 		errQueryContext = fmt.Errorf("->QueryMap-->queryContext查询rows错误:%w", errQueryContext)
 		FuncLogError(ctx, errQueryContext)
@@ -888,6 +1196,8 @@ var queryMap = func(ctx context.Context, finder *Finder, page *Page) (resultMapL
 	defer func() {
 		//先判断error 再关闭
 		rows.Close()
+		//释放语句的超时
+		cancelQuery()
 		//捕获panic,赋值给err,避免程序崩溃
 		if r := recover(); r != nil {
 			var errOk bool
@@ -1047,6 +1357,7 @@ var queryMap = func(ctx context.Context, finder *Finder, page *Page) (resultMapL
 		resultMapList = append(resultMapList, result)
 
 	}
+	dbConnection.statsStatementRows(sqlstr, len(resultMapList))
 
 	//查询总条数
 	//Query total number
@@ -1116,6 +1427,8 @@ var updateFinder = func(ctx context.Context, finder *Finder) (int, error) {
 		return affected, err
 	}
 
+	//SQL日志中脱敏的参数位置
+	ctx = bindContextSensitiveArgs(ctx, finder.sensitiveArgs)
 	//包装update执行,赋值给影响的函数指针变量,返回*sql.Result
 	_, errexec := wrapExecUpdateValuesAffected(ctx, &affected, &sqlstr, finder.values, nil)
 	if errexec != nil {
@@ -1199,6 +1512,9 @@ var insert = func(ctx context.Context, entity IEntityStruct) (int, error) {
 
 	}
 
+	//SQL日志中脱敏的参数位置
+	//The positions of arguments masked in SQL logs
+	ctx = bindContextSensitiveArgs(ctx, sensitiveArgsFromColumns(columns, len(values), 1))
 	//包装update执行,赋值给影响的函数指针变量,返回*sql.Result
 	res, errexec := wrapExecUpdateValuesAffected(ctx, &affected, &sqlstr, values, lastInsertID)
 	if errexec != nil {
@@ -1302,6 +1618,8 @@ var insertSlice = func(ctx context.Context, entityStructSlice []IEntityStruct) (
 		FuncLogError(ctx, err)
 		return affected, err
 	}
+	//SQL日志中脱敏的参数位置
+	ctx = bindContextSensitiveArgs(ctx, sensitiveArgsFromColumns(columns, len(values), len(entityStructSlice)))
 	//包装update执行,赋值给影响的函数指针变量,返回*sql.Result
 	_, errexec := wrapExecUpdateValuesAffected(ctx, &affected, &sqlstr, values, nil)
 	if errexec != nil {
@@ -1569,7 +1887,7 @@ func IsInTransaction(ctx context.Context) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	if dbConnection != nil && dbConnection.tx != nil {
+	if dbConnection != nil && dbConnection.inTransaction() {
 		return true, err
 	}
 	return false, err
@@ -1628,6 +1946,7 @@ func WrapUpdateStructFinder(ctx context.Context, entity IEntityStruct, onlyUpdat
 	finder.sqlstr = sqlstr
 	finder.sqlBuilder.WriteString(sqlstr)
 	finder.values = values
+	finder.sensitiveArgs = sensitiveArgsFromColumns(columns, len(values), 1)
 
 	/*
 		//包装update执行,赋值给影响的函数指针变量,返回*sql.Result
@@ -1756,12 +2075,26 @@ func checkDBConnection(ctx context.Context, dbConnection *dataBaseConnection, ha
 	//dbConnection为空
 	//dbConnection is nil
 	if dbConnection == nil {
-		dbdao, err := FuncReadWriteStrategy(ctx, rwType)
+		//读己之写,ctx有写入之后读操作使用主库
+		//Read-your-writes, reads use the primary after a write on the ctx
+		if rwType == 0 && readYourWritesPrimary(ctx) {
+			rwType = 1
+		}
+		//ctx绑定了数据库名称,使用注册的DBDao
+		//The ctx binds a database name, use the registered DBDao
+		dbdao, err := getDBDaoFromContext(ctx)
 		if err != nil {
 			return ctx, nil, err
 		}
+		if dbdao == nil {
+			dbdao, err = FuncReadWriteStrategy(ctx, rwType)
+			if err != nil {
+				return ctx, nil, err
+			}
+		}
 		//是否禁用了事务
-		disabletx := getContextBoolValue(ctx, contextDisableTransactionValueKey, dbdao.config.DisableTransaction)
+		dbdaoConfig, _ := dbdao.loadDataSource()
+		disabletx := getContextBoolValue(ctx, contextDisableTransactionValueKey, dbdaoConfig.DisableTransaction)
 		//如果要求有事务,事务需要手动zorm.Transaction显示开启.如果自动开启,就会为了偷懒,每个操作都自动开启,事务就失去意义了
 		if hastx && (!disabletx) {
 			//if hastx {
@@ -1785,7 +2118,7 @@ func checkDBConnection(ctx context.Context, dbConnection *dataBaseConnection, ha
 		if dbConnection.db == nil { //禁止外部构建
 			return ctx, dbConnection, errDBConnection
 		}
-		if dbConnection.tx == nil && hastx && (!getContextBoolValue(ctx, contextDisableTransactionValueKey, dbConnection.config.DisableTransaction)) {
+		if !dbConnection.inTransaction() && hastx && (!getContextBoolValue(ctx, contextDisableTransactionValueKey, dbConnection.config.DisableTransaction)) {
 			//if dbConnection.tx == nil && hastx { //如果要求有事务,事务需要手动zorm.Transaction显示开启.如果自动开启,就会为了偷懒,每个操作都自动开启,事务就失去意义了
 			return ctx, dbConnection, errDBConnection
 		}
@@ -1803,6 +2136,12 @@ func wrapExecUpdateValuesAffected(ctx context.Context, affected *int, sqlstrptr
 	if dbConnectionerr != nil {
 		return nil, dbConnectionerr
 	}
+	//只读事务中不能执行更新语句
+	//Update statements are rejected in a read-only transaction
+	if isReadOnlyTransaction(ctx, dbConnection) {
+		FuncLogError(ctx, ErrReadOnlyTransaction)
+		return nil, ErrReadOnlyTransaction
+	}
 
 	// 数据库语法兼容处理
 	/*
@@ -1817,13 +2156,17 @@ func wrapExecUpdateValuesAffected(ctx context.Context, affected *int, sqlstrptr
 	var res *sql.Result
 	var errexec error
 	if lastInsertID != nil {
-		sqlrow, errrow := dbConnection.queryRowContext(ctx, sqlstrptr, values)
+		sqlrow, cancelQuery, errrow := dbConnection.queryRowContext(ctx, sqlstrptr, values)
 		if errrow != nil {
 			return res, errrow
 		}
 		errexec = sqlrow.Scan(lastInsertID)
+		cancelQuery()
+		dbConnection.statsStatement(true, errexec)
 		if errexec == nil { //如果插入成功,返回
 			*affected = 1
+			dbConnection.txWrote = true
+			markReadYourWrites(ctx)
 			return res, errexec
 		}
 	} else {
@@ -1833,6 +2176,8 @@ func wrapExecUpdateValuesAffected(ctx context.Context, affected *int, sqlstrptr
 	if errexec != nil {
 		return res, errexec
 	}
+	dbConnection.txWrote = true
+	markReadYourWrites(ctx)
 	//影响的行数
 	//Number of rows affected
 
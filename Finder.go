@@ -46,6 +46,18 @@ type Finder struct {
 	//SQL语句
 	//SQL statement
 	sqlstr string
+	//敏感参数的位置,根据struct的tag计算,用于SQL日志脱敏
+	//The positions of sensitive arguments calculated from struct tags, used to mask SQL logs
+	sensitiveArgs []bool
+	//Where生成列名引号使用的方言,为空时使用默认DBDao的方言
+	//The dialect used by Where to quote column names, the dialect of the default DBDao is used when empty
+	dialect string
+	//是否已经使用Where添加了 WHERE,之后的Where添加 AND
+	//Whether WHERE has been added by Where, subsequent Where calls add AND
+	hasWhere bool
+	//Where生成条件的错误,GetSQL时返回
+	//The error of conditions generated by Where, returned by GetSQL
+	err error
 }
 
 //NewFinder  初始化一个Finder,生成一个空的Finder
@@ -172,6 +184,11 @@ func (finder *Finder) GetSQL() (string, error) {
 	if finder == nil || finder.values == nil {
 		return "", errors.New("->finder-->GetSQL()不要自己构建finder,使用NewFinder()方法")
 	}
+	//Where生成条件的错误
+	//The error of conditions generated by Where
+	if finder.err != nil {
+		return "", finder.err
+	}
 	if len(finder.sqlstr) > 0 {
 		return finder.sqlstr, nil
 	}
@@ -18,7 +18,11 @@
 
 package zorm
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
 
 // IGlobalTransaction 分布式事务的包装接口,隔离seata/hptx等的依赖
 // 声明一个struct,实现这个接口,并配置实现 FuncGlobalTransaction 函数
@@ -132,3 +136,58 @@ type IGlobalTransaction interface {
 	//所以DBDao里使用了 globalRootContext变量,区分业务的ctx和分布式事务的RootContext
 	//NewRootContext(ctx context.Context) context.Context
 }
+
+// IGlobalTransactionBranch 可选接口,IGlobalTransaction的实现如果同时实现了这个接口,本地事务的开启,提交和回滚由分布式事务接管
+// 用于XA这类需要在同一个数据库会话上执行两阶段提交的实现.zorm从数据库获取一个独占的连接,分支内的所有语句都在这个连接上执行,不再使用*sql.Tx
+// IGlobalTransactionBranch Optional interface, if the IGlobalTransaction implementation also implements it, the begin, commit and rollback of the local transaction are taken over
+// Used for implementations such as XA that need two-phase commit on the same database session, all statements of the branch are executed on an exclusive connection
+type IGlobalTransactionBranch interface {
+	// BeginBranch 在独占连接上开启分支事务,例如MySQL的 XA START.返回error时zorm会关闭连接
+	// BeginBranch Begin the branch on the exclusive connection, such as XA START of MySQL. zorm closes the connection when error is returned
+	BeginBranch(ctx context.Context, globalRootContext context.Context, branch *GlobalTransactionBranch) error
+
+	// EndBranch 结束分支事务,commit为true执行第一阶段的准备,例如 XA END,XA PREPARE;为false回滚分支
+	// 调用之后zorm不再使用这个连接,由实现负责在第二阶段结束后关闭 branch.Conn
+	// EndBranch End the branch, commit=true executes the prepare of phase one, such as XA END, XA PREPARE; commit=false rolls back the branch
+	// zorm no longer uses the connection after the call, the implementation closes branch.Conn after phase two
+	EndBranch(ctx context.Context, globalRootContext context.Context, branch *GlobalTransactionBranch, commit bool) error
+}
+
+// GlobalTransactionBranch 分布式事务的分支,一个本地事务对应一个分支
+// GlobalTransactionBranch The branch of the global transaction, a local transaction corresponds to a branch
+type GlobalTransactionBranch struct {
+	// Conn 分支独占的数据库连接
+	// Conn The exclusive database connection of the branch
+	Conn *sql.Conn
+	// DBDao 分支所在的数据库
+	// DBDao The database of the branch
+	DBDao *DBDao
+	// Dialect 数据库方言
+	// Dialect Database dialect
+	Dialect string
+}
+
+// GlobalCommitIncompleteError CommitGTX已经决定提交,例如XA已经写入了提交的恢复日志,但是第二阶段没有全部完成,由恢复程序继续提交.
+// CommitGTX返回这个错误时,zorm按照提交处理,执行AfterCommit钩子函数,Transaction返回这个错误
+// GlobalCommitIncompleteError CommitGTX has decided to commit, such as XA has written the commit to the recovery log, but phase two is incomplete and will be finished by recovery.
+// When CommitGTX returns this error, zorm treats the transaction as committed, runs the AfterCommit hooks, and Transaction returns this error
+type GlobalCommitIncompleteError struct {
+	Err error
+}
+
+// Error 实现error接口
+func (globalCommitIncompleteError *GlobalCommitIncompleteError) Error() string {
+	return "->GlobalCommitIncompleteError-->分布式事务已经决定提交,第二阶段没有完成:" + globalCommitIncompleteError.Err.Error()
+}
+
+// Unwrap 返回第二阶段的错误
+func (globalCommitIncompleteError *GlobalCommitIncompleteError) Unwrap() error {
+	return globalCommitIncompleteError.Err
+}
+
+// IsGlobalCommitIncompleteError 判断错误链中是否有GlobalCommitIncompleteError
+// IsGlobalCommitIncompleteError Determine whether there is a GlobalCommitIncompleteError in the error chain
+func IsGlobalCommitIncompleteError(err error) bool {
+	var globalCommitIncompleteError *GlobalCommitIncompleteError
+	return errors.As(err, &globalCommitIncompleteError)
+}
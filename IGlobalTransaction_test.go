@@ -0,0 +1,46 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsGlobalCommitIncompleteError(t *testing.T) {
+	incomplete := &GlobalCommitIncompleteError{Err: errors.New("phase two failed")}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"other error", errors.New("failed"), false},
+		{"incomplete", incomplete, true},
+		{"wrapped incomplete", fmt.Errorf("->Transaction-->%w", incomplete), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsGlobalCommitIncompleteError(tt.err); got != tt.want {
+				t.Errorf("IsGlobalCommitIncompleteError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -47,6 +47,11 @@ var FuncLogPanic func(ctx context.Context, err error) = defaultLogPanic
 var FuncPrintSQL func(ctx context.Context, sqlstr string, args []interface{}, execSQLMillis int64) = defaultPrintSQL
 
 func defaultLogError(ctx context.Context, err error) {
+	//配置了结构化日志
+	if logger := DefaultLogger; logger != nil {
+		logStructuredError(logger, ctx, err)
+		return
+	}
 	log.Output(LogCallDepth, fmt.Sprintln(err))
 }
 func defaultLogPanic(ctx context.Context, err error) {
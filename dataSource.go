@@ -23,6 +23,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -54,7 +55,14 @@ func newDataSource(config *DataSourceConfig) (*dataSource, error) {
 	var db *sql.DB
 	var errSQLOpen error
 
-	if config.SQLDB == nil { //没有已经存在的数据库连接,使用DSN初始化
+	if config.SQLDB == nil && config.DSNProvider != nil { //使用DSNProvider初始化,每次创建新连接时获取DSN
+		db, errSQLOpen = openDSNProvider(config.DriverName, config.DSNProvider)
+		if errSQLOpen != nil {
+			errSQLOpen = fmt.Errorf("->newDataSource-->open数据库打开失败:%w", errSQLOpen)
+			FuncLogError(nil, errSQLOpen)
+			return nil, errSQLOpen
+		}
+	} else if config.SQLDB == nil { //没有已经存在的数据库连接,使用DSN初始化
 		if config.DSN == "" {
 			return nil, errors.New("->newDataSource-->DSN cannot be empty")
 		}
@@ -68,6 +76,21 @@ func newDataSource(config *DataSourceConfig) (*dataSource, error) {
 		db = config.SQLDB
 	}
 
+	applyPoolConfig(db, config)
+
+	//验证连接
+	if pingerr := db.Ping(); pingerr != nil {
+		pingerr = fmt.Errorf("->newDataSource-->ping数据库失败:%w", pingerr)
+		FuncLogError(nil, pingerr)
+		db.Close()
+		return nil, pingerr
+	}
+
+	return &dataSource{db}, nil
+}
+
+// applyPoolConfig 设置连接池的参数,没有配置的参数使用默认值.Reconfigure时直接修改现有的连接池
+func applyPoolConfig(db *sql.DB, config *DataSourceConfig) {
 	if config.MaxOpenConns == 0 {
 		config.MaxOpenConns = 50
 	}
@@ -89,16 +112,6 @@ func newDataSource(config *DataSourceConfig) (*dataSource, error) {
 	//(Connection survival time in seconds) Destroy and rebuild the connection after the default 600 seconds (10 minutes)
 	//Prevent the database from actively disconnecting and causing dead connections. MySQL Default wait_timeout 28800 seconds
 	db.SetConnMaxLifetime(time.Second * time.Duration(config.ConnMaxLifetimeSecond))
-
-	//验证连接
-	if pingerr := db.Ping(); pingerr != nil {
-		pingerr = fmt.Errorf("->newDataSource-->ping数据库失败:%w", pingerr)
-		FuncLogError(nil, pingerr)
-		db.Close()
-		return nil, pingerr
-	}
-
-	return &dataSource{db}, nil
 }
 
 // 事务参照:https://www.jianshu.com/p/2a144332c3db
@@ -118,6 +131,39 @@ type dataBaseConnection struct {
 	tx *sql.Tx
 	// 数据库配置
 	config *DataSourceConfig
+	// 创建连接的dbDao,用于从同一个数据库获取新的连接,例如PropagationRequiresNew
+	// the dbDao that created the connection, used to get a new connection from the same database, such as PropagationRequiresNew
+	dbDao *DBDao
+	// 保存点序号,用于生成嵌套事务的保存点名称
+	// savepoint sequence, used to generate the savepoint name of nested transaction
+	savepointSeq int
+	// 事务的生命周期钩子函数
+	// transaction lifecycle hooks
+	txHooks txHooks
+	// 分布式事务接管的分支事务,分支内的语句在branch.Conn上执行
+	// The branch taken over by the global transaction, statements are executed on branch.Conn
+	branch *GlobalTransactionBranch
+	// 接管分支事务的分布式事务
+	globalBranch IGlobalTransactionBranch
+	// 分布式事务的rootContext,用于结束分支事务
+	globalRootContext context.Context
+	// 开启分支事务的ctx,用于结束分支事务
+	branchContext context.Context
+	// 当前事务中使用 SET LOCAL statement_timeout 设置的语句超时时间,用于postgresql
+	// The statement timeout set by SET LOCAL statement_timeout in the current transaction, for postgresql
+	statementTimeout time.Duration
+	// 事务注册表中的记录,用于排查长事务
+	// The record in the transaction registry, used to troubleshoot long-running transactions
+	txRecord *txRecord
+	// 是否是只读事务,只读事务中不能执行更新语句
+	// Whether it is a read-only transaction, update statements are rejected
+	readOnly bool
+	// 事务范围的N+1查询检测状态
+	// The N+1 query detection state of the transaction scope
+	nPlusOne *nPlusOneState
+	// 事务中是否执行过更新语句,提交之后才需要记录读写分离的写入时间
+	// Whether the transaction executed an update statement, the write time of read-your-writes is recorded after commit only then
+	txWrote bool
 
 	//commitSign   int8    // 提交标记,控制是否提交事务
 	//rollbackSign bool    // 回滚标记,控制是否回滚事务
@@ -137,13 +183,32 @@ func (dbConnection *dataBaseConnection) beginTx(ctx context.Context) error {
 		} else {
 			txOptions = dbConnection.config.DefaultTxOptions
 		}
+		//只读事务
+		readOnly := getContextBoolValue(ctx, contextReadOnlyTransactionValueKey, false)
+		if readOnly {
+			txOptions = readOnlyTxOptions(txOptions)
+		}
 
-		tx, err := dbConnection.db.BeginTx(ctx, txOptions)
+		var tx *sql.Tx
+		err := dbConnection.interceptTxBegin(ctx, func(ctx context.Context) error {
+			var errBegin error
+			tx, errBegin = dbConnection.db.BeginTx(ctx, txOptions)
+			return errBegin
+		})
 		if err != nil {
+			//拦截器在next之后返回错误,回滚已经开启的事务
+			if tx != nil {
+				tx.Rollback()
+			}
 			err = fmt.Errorf("->beginTx事务开启失败:%w", err)
 			return err
 		}
 		dbConnection.tx = tx
+		dbConnection.readOnly = readOnly
+		dbConnection.txWrote = false
+		dbConnection.txHooks = txHooks{}
+		dbConnection.statementTimeout = 0
+		dbConnection.trackTx(ctx)
 		//s.commitSign = beginStatus
 		return nil
 	}
@@ -151,17 +216,116 @@ func (dbConnection *dataBaseConnection) beginTx(ctx context.Context) error {
 	return nil
 }
 
+// beginGlobalBranch 开启由分布式事务接管的分支事务,从数据库获取独占的连接
+// beginGlobalBranch Begin the branch taken over by the global transaction, get an exclusive connection from the database
+func (dbConnection *dataBaseConnection) beginGlobalBranch(ctx context.Context, globalBranch IGlobalTransactionBranch, globalRootContext context.Context) error {
+	if dbConnection.inTransaction() {
+		return nil
+	}
+	conn, err := dbConnection.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("->beginGlobalBranch获取数据库连接失败:%w", err)
+	}
+	branch := &GlobalTransactionBranch{Conn: conn, DBDao: dbConnection.dbDao, Dialect: dbConnection.config.Dialect}
+	err = dbConnection.interceptTxBegin(ctx, func(ctx context.Context) error {
+		return globalBranch.BeginBranch(ctx, globalRootContext, branch)
+	})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("->beginGlobalBranch分支事务开启失败:%w", err)
+	}
+	dbConnection.branch = branch
+	dbConnection.globalBranch = globalBranch
+	dbConnection.globalRootContext = globalRootContext
+	dbConnection.branchContext = ctx
+	dbConnection.readOnly = getContextBoolValue(ctx, contextReadOnlyTransactionValueKey, false)
+	dbConnection.txWrote = false
+	dbConnection.txHooks = txHooks{}
+	dbConnection.statementTimeout = 0
+	dbConnection.trackTx(ctx)
+	return nil
+}
+
+// endGlobalBranch 结束分支事务,commit为true执行第一阶段的准备,为false回滚分支
+// endGlobalBranch End the branch, commit=true executes the prepare of phase one, commit=false rolls back the branch
+func (dbConnection *dataBaseConnection) endGlobalBranch(commit bool) error {
+	branch := dbConnection.branch
+	globalBranch := dbConnection.globalBranch
+	globalRootContext := dbConnection.globalRootContext
+	ctx := dbConnection.branchContext
+	//结束之后分支就不能再使用了
+	dbConnection.branch = nil
+	dbConnection.globalBranch = nil
+	dbConnection.globalRootContext = nil
+	dbConnection.branchContext = nil
+	dbConnection.savepointSeq = 0
+	dbConnection.untrackTx()
+	dbConnection.readOnly = false
+	dbConnection.nPlusOne = nil
+	kind := OperationRollback
+	if commit {
+		kind = OperationCommit
+	}
+	return dbConnection.interceptTxEnd(ctx, kind, func() error {
+		return globalBranch.EndBranch(ctx, globalRootContext, branch, commit)
+	}, func() error {
+		return globalBranch.EndBranch(ctx, globalRootContext, branch, false)
+	})
+}
+
+// inTransaction 是否有事务,包括本地事务和分布式事务接管的分支事务
+// inTransaction Whether there is a transaction, including local transaction and the branch taken over by the global transaction
+func (dbConnection *dataBaseConnection) inTransaction() bool {
+	return dbConnection.tx != nil || dbConnection.branch != nil
+}
+
+// sqlExecutor *sql.DB,*sql.Conn和*sql.Tx共同的执行方法
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// executor 获取执行语句的对象,有事务使用事务,分支事务使用独占连接,否则使用数据库连接池
+// executor Get the object that executes statements, transaction first, then the exclusive connection of the branch, otherwise the database pool
+func (dbConnection *dataBaseConnection) executor() sqlExecutor {
+	if dbConnection.tx != nil {
+		return dbConnection.tx
+	}
+	if dbConnection.branch != nil {
+		return dbConnection.branch.Conn
+	}
+	return dbConnection.db
+}
+
 // rollback 回滚事务
 // rollback Rollback transaction
 func (dbConnection *dataBaseConnection) rollback() error {
+	//分布式事务接管的分支事务
+	if dbConnection.branch != nil {
+		err := dbConnection.endGlobalBranch(false)
+		dbConnection.statsTx(false)
+		if err != nil {
+			err = fmt.Errorf("->rollback分支事务回滚失败:%w", err)
+		}
+		return err
+	}
 	//if s.tx != nil && s.rollbackSign == true {
 	if dbConnection.tx != nil {
-		err := dbConnection.tx.Rollback()
+		tx := dbConnection.tx
+		err := dbConnection.interceptTxEnd(dbConnection.txContext(), OperationRollback, tx.Rollback, tx.Rollback)
+		//回滚之后事务就结束了,即使回滚失败也不能再使用
+		//The transaction is over after rollback, it cannot be used even if the rollback fails
+		dbConnection.tx = nil
+		dbConnection.savepointSeq = 0
+		dbConnection.untrackTx()
+		dbConnection.readOnly = false
+		dbConnection.nPlusOne = nil
+		dbConnection.statsTx(false)
 		if err != nil {
 			err = fmt.Errorf("->rollback事务回滚失败:%w", err)
 			return err
 		}
-		dbConnection.tx = nil
 		return nil
 	}
 	return nil
@@ -171,20 +335,90 @@ func (dbConnection *dataBaseConnection) rollback() error {
 // commit Commit transaction
 func (dbConnection *dataBaseConnection) commit() error {
 	//s.rollbackSign = false
+	//分布式事务接管的分支事务,执行第一阶段的准备
+	//准备成功时结果还没有确定,由execTransaction在分布式事务提交或者回滚之后统计
+	if dbConnection.branch != nil {
+		err := dbConnection.endGlobalBranch(true)
+		if err != nil {
+			dbConnection.statsTx(false)
+			err = fmt.Errorf("->dbConnection.commit()分支事务准备失败:%w", err)
+		}
+		return err
+	}
 	if dbConnection.tx == nil {
 		return errors.New("->dbConnection.commit()事务为空")
 
 	}
-	err := dbConnection.tx.Commit()
+	tx := dbConnection.tx
+	err := dbConnection.interceptTxEnd(dbConnection.txContext(), OperationCommit, tx.Commit, tx.Rollback)
+	//提交之后事务就结束了,即使提交失败也不能再使用
+	//The transaction is over after commit, it cannot be used even if the commit fails
+	dbConnection.tx = nil
+	dbConnection.savepointSeq = 0
+	dbConnection.untrackTx()
+	dbConnection.readOnly = false
+	dbConnection.nPlusOne = nil
+	dbConnection.statsTx(err == nil)
 	if err != nil {
 		err = fmt.Errorf("->dbConnection.commit()事务提交失败:%w", err)
 		return err
 	}
-	dbConnection.tx = nil
 	return nil
 
 }
 
+// savepoint 在当前事务中创建保存点,用于嵌套事务,返回保存点的名称
+// savepoint Create a savepoint in the current transaction for nested transaction, return the name of the savepoint
+func (dbConnection *dataBaseConnection) savepoint(ctx context.Context) (string, error) {
+	if !dbConnection.inTransaction() {
+		return "", errors.New("->savepoint-->事务为空,无法创建保存点")
+	}
+	//序号在事务内单调递增,不复用保存点的名称.ROLLBACK TO之后保存点依然存在,复用名称会覆盖外层的保存点
+	dbConnection.savepointSeq++
+	savepointName := "zorm_sp_" + strconv.Itoa(dbConnection.savepointSeq)
+	err := dbConnection.execSavepointSQL(ctx, savepointBegin, savepointName)
+	if err != nil {
+		dbConnection.savepointSeq--
+		return "", err
+	}
+	return savepointName, nil
+}
+
+// releaseSavepoint 释放保存点,嵌套事务执行成功,保存点内的修改归属到外层事务
+// releaseSavepoint Release the savepoint, the changes of nested transaction belong to the outer transaction
+func (dbConnection *dataBaseConnection) releaseSavepoint(ctx context.Context, savepointName string) error {
+	return dbConnection.execSavepointSQL(ctx, savepointRelease, savepointName)
+}
+
+// rollbackToSavepoint 回滚到保存点,只撤销嵌套事务内的修改,不影响外层事务
+// rollbackToSavepoint Rollback to the savepoint, only the changes of nested transaction are undone
+func (dbConnection *dataBaseConnection) rollbackToSavepoint(ctx context.Context, savepointName string) error {
+	err := dbConnection.execSavepointSQL(ctx, savepointRollback, savepointName)
+	//保存点之后的 SET LOCAL 也被回滚了,下次执行语句时重新设置
+	dbConnection.statementTimeout = -1
+	return err
+}
+
+// execSavepointSQL 执行保存点语句,不打印SQL也不加hint,避免改写保存点的语法
+func (dbConnection *dataBaseConnection) execSavepointSQL(ctx context.Context, savepointType int, savepointName string) error {
+	if !dbConnection.inTransaction() {
+		return errors.New("->execSavepointSQL-->事务为空,无法操作保存点")
+	}
+	sqlstr, err := wrapSavepointSQL(dbConnection.config.Dialect, savepointType, savepointName)
+	if err != nil {
+		return err
+	}
+	//数据库不需要这个操作,例如oracle没有RELEASE SAVEPOINT语法
+	if len(sqlstr) < 1 {
+		return nil
+	}
+	_, err = dbConnection.executor().ExecContext(ctx, sqlstr)
+	if err != nil {
+		err = fmt.Errorf("->execSavepointSQL-->保存点语句%s执行失败:%w", sqlstr, err)
+	}
+	return err
+}
+
 // execContext 执行sql语句,如果已经开启事务,就以事务方式执行,如果没有开启事务,就以非事务方式执行
 // execContext Execute sql statement,If the transaction has been opened,it will be executed in transaction mode, if the transaction is not opened,it will be executed in non-transactional mode
 func (dbConnection *dataBaseConnection) execContext(ctx context.Context, execsql *string, args []interface{}) (*sql.Result, error) {
@@ -204,106 +438,150 @@ func (dbConnection *dataBaseConnection) execContext(ctx context.Context, execsql
 	if err != nil {
 		return nil, err
 	}
-	var start *time.Time
+	//执行前加入sqlcommenter注释
+	dbConnection.wrapSQLComment(ctx, execsql)
+	//开发模式检测N+1查询
+	err = dbConnection.detectNPlusOne(ctx, *execsql)
+	if err != nil {
+		return nil, err
+	}
+	//语句的超时时间
+	queryCtx, cancel, queryTimeout := dbConnection.withQueryTimeout(ctx)
+	defer cancel()
+	err = dbConnection.wrapStatementTimeout(queryCtx, queryTimeout)
+	if err != nil {
+		return nil, wrapTimeoutError(ctx, queryCtx, TimeoutOperationQuery, queryTimeout, err)
+	}
 	var res sql.Result
+	dbConnection.printSQLBeforeExec(ctx, *execsql, args)
+	start := time.Now()
+	dbConnection.countStatement()
+	traceCtx, span := dbConnection.startStatementSpan(queryCtx, OperationExec, *execsql)
+	res, err = dbConnection.interceptExec(traceCtx, execsql, &args)
+	endExecSpan(span, res, err)
+	err = wrapTimeoutError(ctx, queryCtx, TimeoutOperationQuery, queryTimeout, err)
+	dbConnection.statsStatement(true, err)
+	dbConnection.afterSQL(ctx, *execsql, args, time.Since(start), sqlResultRowsAffected(res), err)
+
+	return &res, err
+}
+
+// afterSQL 语句执行之后统计和分析慢SQL,输出日志.rowsAffected小于0表示没有影响的行数
+func (dbConnection *dataBaseConnection) afterSQL(ctx context.Context, sqlstr string, args []interface{}, duration time.Duration, rowsAffected int64, err error) {
 	//小于0是禁用日志输出;等于0是只输出日志,不计算SQ执行时间;大于0是计算执行时间,并且大于指定值
 	slowSQLMillis := dbConnection.config.SlowSQLMillis
-	if slowSQLMillis == 0 {
-		FuncPrintSQL(ctx, *execsql, args, 0)
-	} else if slowSQLMillis > 0 {
-		now := time.Now() // 获取当前时间
-		start = &now
-	}
-	if dbConnection.tx != nil {
-		res, err = dbConnection.tx.ExecContext(ctx, *execsql, args...)
-	} else {
-		res, err = dbConnection.db.ExecContext(ctx, *execsql, args...)
-	}
-	if slowSQLMillis > 0 {
-		slow := time.Since(*start).Milliseconds()
-		if slow-int64(slowSQLMillis) >= 0 {
-			FuncPrintSQL(ctx, *execsql, args, slow)
-		}
+	slow := slowSQLMillis > 0 && duration.Milliseconds() >= int64(slowSQLMillis)
+	if slow {
+		dbConnection.statsSlowSQL()
+		dbConnection.analyzeSlowQuery(ctx, sqlstr, args, duration)
 	}
+	dbConnection.statsStatementFingerprint(sqlstr, duration, rowsAffected, err)
+	dbConnection.logSQL(ctx, sqlstr, args, duration, rowsAffected, slow, err)
+}
 
-	return &res, err
+// sqlResultRowsAffected 获取影响的行数,不支持时返回-1
+func sqlResultRowsAffected(res sql.Result) int64 {
+	if res == nil {
+		return -1
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return rowsAffected
 }
 
 // queryRowContext 如果已经开启事务,就以事务方式执行,如果没有开启事务,就以非事务方式执行
-func (dbConnection *dataBaseConnection) queryRowContext(ctx context.Context, query *string, args []interface{}) (*sql.Row, error) {
+// 返回的context.CancelFunc释放语句的超时,必须在sql.Row.Scan之后调用
+func (dbConnection *dataBaseConnection) queryRowContext(ctx context.Context, query *string, args []interface{}) (*sql.Row, context.CancelFunc, error) {
 	var err error
 	//如果是TDengine,重新处理 字符类型的参数 '?'
 	err = reBindSQL(dbConnection.config.Dialect, query, &args)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	//执行前加入 hint
 	err = wrapSQLHint(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var start *time.Time
-	var row *sql.Row
-	//小于0是禁用日志输出;等于0是只输出日志,不计算SQ执行时间;大于0是计算执行时间,并且大于指定值
-	slowSQLMillis := dbConnection.config.SlowSQLMillis
-	if slowSQLMillis == 0 {
-		FuncPrintSQL(ctx, *query, args, 0)
-	} else if slowSQLMillis > 0 {
-		now := time.Now() // 获取当前时间
-		start = &now
+	//执行前加入sqlcommenter注释
+	dbConnection.wrapSQLComment(ctx, query)
+	//开发模式检测N+1查询
+	err = dbConnection.detectNPlusOne(ctx, *query)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	if dbConnection.tx != nil {
-		row = dbConnection.tx.QueryRowContext(ctx, *query, args...)
-	} else {
-		row = dbConnection.db.QueryRowContext(ctx, *query, args...)
-	}
-	if slowSQLMillis > 0 {
-		slow := time.Since(*start).Milliseconds()
-		if slow-int64(slowSQLMillis) >= 0 {
-			FuncPrintSQL(ctx, *query, args, slow)
-		}
+	//语句的超时时间
+	queryCtx, cancel, queryTimeout := dbConnection.withQueryTimeout(ctx)
+	wrapMaxExecutionTimeHint(dbConnection.config.Dialect, query, queryTimeout)
+	err = dbConnection.wrapStatementTimeout(queryCtx, queryTimeout)
+	if err != nil {
+		cancel()
+		return nil, nil, wrapTimeoutError(ctx, queryCtx, TimeoutOperationQuery, queryTimeout, err)
+	}
+	var row *sql.Row
+	dbConnection.printSQLBeforeExec(ctx, *query, args)
+	start := time.Now()
+	dbConnection.countStatement()
+	traceCtx, span := dbConnection.startStatementSpan(queryCtx, OperationQueryRow, *query)
+	row, err = dbConnection.interceptQueryRow(traceCtx, query, &args)
+	endSpan(span, err)
+	//sql.Row的错误在Scan时才返回,这里只统计拦截器返回的错误
+	dbConnection.statsStatement(false, err)
+	dbConnection.afterSQL(ctx, *query, args, time.Since(start), -1, err)
+	if err != nil {
+		cancel()
+		return nil, nil, err
 	}
-	return row, nil
+	return row, cancel, nil
 }
 
 // queryContext 查询数据,如果已经开启事务,就以事务方式执行,如果没有开启事务,就以非事务方式执行
+// 返回的context.CancelFunc释放语句的超时,必须在sql.Rows.Close之后调用
 // queryRowContext Execute sql  row statement,If the transaction has been opened,it will be executed in transaction mode, if the transaction is not opened,it will be executed in non-transactional mode
-func (dbConnection *dataBaseConnection) queryContext(ctx context.Context, query *string, args []interface{}) (*sql.Rows, error) {
+func (dbConnection *dataBaseConnection) queryContext(ctx context.Context, query *string, args []interface{}) (*sql.Rows, context.CancelFunc, error) {
 	var err error
 	//如果是TDengine,重新处理 字符类型的参数 '?'
 	err = reBindSQL(dbConnection.config.Dialect, query, &args)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	//执行前加入 hint
 	err = wrapSQLHint(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var start *time.Time
-	var rows *sql.Rows
-	//小于0是禁用日志输出;等于0是只输出日志,不计算SQ执行时间;大于0是计算执行时间,并且大于指定值
-	slowSQLMillis := dbConnection.config.SlowSQLMillis
-	if slowSQLMillis == 0 {
-		FuncPrintSQL(ctx, *query, args, 0)
-	} else if slowSQLMillis > 0 {
-		now := time.Now() // 获取当前时间
-		start = &now
+	//执行前加入sqlcommenter注释
+	dbConnection.wrapSQLComment(ctx, query)
+	//开发模式检测N+1查询
+	err = dbConnection.detectNPlusOne(ctx, *query)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	if dbConnection.tx != nil {
-		rows, err = dbConnection.tx.QueryContext(ctx, *query, args...)
-	} else {
-		rows, err = dbConnection.db.QueryContext(ctx, *query, args...)
-	}
-	if slowSQLMillis > 0 {
-		slow := time.Since(*start).Milliseconds()
-		if slow-int64(slowSQLMillis) >= 0 {
-			FuncPrintSQL(ctx, *query, args, slow)
-		}
+	//语句的超时时间
+	queryCtx, cancel, queryTimeout := dbConnection.withQueryTimeout(ctx)
+	wrapMaxExecutionTimeHint(dbConnection.config.Dialect, query, queryTimeout)
+	err = dbConnection.wrapStatementTimeout(queryCtx, queryTimeout)
+	if err != nil {
+		cancel()
+		return nil, nil, wrapTimeoutError(ctx, queryCtx, TimeoutOperationQuery, queryTimeout, err)
+	}
+	var rows *sql.Rows
+	dbConnection.printSQLBeforeExec(ctx, *query, args)
+	start := time.Now()
+	dbConnection.countStatement()
+	//span在查询返回时结束,不包含读取结果集的时间
+	traceCtx, span := dbConnection.startStatementSpan(queryCtx, OperationQuery, *query)
+	rows, err = dbConnection.interceptQuery(traceCtx, query, &args)
+	endSpan(span, err)
+	dbConnection.statsStatement(false, err)
+	dbConnection.afterSQL(ctx, *query, args, time.Since(start), -1, err)
+	if err != nil {
+		cancel()
+		return nil, nil, wrapTimeoutError(ctx, queryCtx, TimeoutOperationQuery, queryTimeout, err)
 	}
-	return rows, err
+	return rows, cancel, nil
 }
 
 /*
@@ -0,0 +1,128 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// dbDaoRegistry 命名的DBDao注册表,key是名称,value是*DBDao.zorm有Delete函数,使用sync.Map避免和内置的delete冲突
+var dbDaoRegistry sync.Map
+
+// dbDaoRegistryMutex 保证注册,替换和关闭的原子性,读取不需要加锁
+var dbDaoRegistryMutex sync.Mutex
+
+// contextDBNameValueKey 数据库名称放到context里使用的key
+const contextDBNameValueKey = wrapContextStringKey("contextDBNameValueKey")
+
+// RegisterDBDao 创建命名的DBDao并注册,名称已经存在返回错误.注册的DBDao不会成为defaultDao
+// 使用zorm.BindContextDBName(ctx,name)选择数据库,不需要在业务代码中传递DBDao
+// RegisterDBDao Create and register a named DBDao, an error is returned if the name already exists. The registered DBDao does not become the defaultDao
+// Use zorm.BindContextDBName(ctx,name) to select the database, no need to pass DBDao in the business code
+func RegisterDBDao(name string, config *DataSourceConfig) (*DBDao, error) {
+	if name == "" {
+		return nil, errors.New("->RegisterDBDao-->name不能为空")
+	}
+	dbDaoRegistryMutex.Lock()
+	defer dbDaoRegistryMutex.Unlock()
+	if _, has := dbDaoRegistry.Load(name); has {
+		return nil, fmt.Errorf("->RegisterDBDao-->名称%s已经注册", name)
+	}
+	dbDao, err := newDBDao(config)
+	if err != nil {
+		err = fmt.Errorf("->RegisterDBDao-->创建%s失败:%w", name, err)
+		FuncLogError(nil, err)
+		return nil, err
+	}
+	dbDaoRegistry.Store(name, dbDao)
+	return dbDao, nil
+}
+
+// GetDBDao 根据名称获取注册的DBDao
+// GetDBDao Get the registered DBDao by name
+func GetDBDao(name string) (*DBDao, error) {
+	value, has := dbDaoRegistry.Load(name)
+	if !has {
+		return nil, fmt.Errorf("->GetDBDao-->名称%s没有注册", name)
+	}
+	return value.(*DBDao), nil
+}
+
+// ReplaceDBDao 使用新的配置替换命名的DBDao.先创建新的DBDao,创建失败时保留原来的DBDao
+// 已经开始的事务和查询会继续使用原来的连接池直到结束,然后关闭原来的连接池,之后的操作使用新的DBDao.只修改配置可以使用DBDao.Reconfigure
+// ReplaceDBDao Replace the named DBDao with a new configuration. The new DBDao is created first, the old one is kept if it fails
+// Started transactions and queries finish on the old pool, which is closed after that, the following operations use the new DBDao
+func ReplaceDBDao(name string, config *DataSourceConfig) (*DBDao, error) {
+	dbDaoRegistryMutex.Lock()
+	defer dbDaoRegistryMutex.Unlock()
+	oldValue, has := dbDaoRegistry.Load(name)
+	if !has {
+		return nil, fmt.Errorf("->ReplaceDBDao-->名称%s没有注册", name)
+	}
+	dbDao, err := newDBDao(config)
+	if err != nil {
+		err = fmt.Errorf("->ReplaceDBDao-->创建%s失败:%w", name, err)
+		FuncLogError(nil, err)
+		return nil, err
+	}
+	dbDaoRegistry.Store(name, dbDao)
+	_, oldDataSource := oldValue.(*DBDao).loadDataSource()
+	go closeDataSourceAfterIdle(oldDataSource)
+	return dbDao, nil
+}
+
+// CloseDBDao 从注册表移除命名的DBDao并关闭数据库连接池,已经开始的事务和查询会继续执行直到结束
+// CloseDBDao Remove the named DBDao from the registry and close the pool, started transactions and queries continue until they finish
+func CloseDBDao(name string) error {
+	dbDaoRegistryMutex.Lock()
+	defer dbDaoRegistryMutex.Unlock()
+	value, has := dbDaoRegistry.Load(name)
+	if !has {
+		return fmt.Errorf("->CloseDBDao-->名称%s没有注册", name)
+	}
+	dbDaoRegistry.Delete(name)
+	return value.(*DBDao).CloseDB()
+}
+
+// BindContextDBName context绑定数据库名称,使用这个ctx的zorm方法都使用zorm.RegisterDBDao注册的同名DBDao,优先级高于FuncReadWriteStrategy
+// 每次操作时才根据名称获取DBDao,所以ReplaceDBDao之后不需要重新绑定.ctx中已经有dbConnection(例如事务中)时,继续使用已有的dbConnection
+// BindContextDBName context binds the database name, zorm methods with this ctx use the DBDao registered by zorm.RegisterDBDao, which takes precedence over FuncReadWriteStrategy
+// The DBDao is resolved for each operation, so there is no need to bind again after ReplaceDBDao
+func BindContextDBName(parent context.Context, name string) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextDBName-->context的parent不能为nil")
+	}
+	if name == "" {
+		return nil, errors.New("->BindContextDBName-->name不能为空")
+	}
+	ctx := context.WithValue(parent, contextDBNameValueKey, name)
+	return ctx, nil
+}
+
+// getDBDaoFromContext 根据ctx中的数据库名称获取DBDao,ctx没有绑定名称时返回nil
+func getDBDaoFromContext(ctx context.Context) (*DBDao, error) {
+	name, ok := ctx.Value(contextDBNameValueKey).(string)
+	if !ok {
+		return nil, nil
+	}
+	return GetDBDao(name)
+}
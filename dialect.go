@@ -1083,17 +1083,89 @@ func wrapAutoIncrementInsertSQL(pkColumnName string, sqlstr *string, dialect str
 	*sqlstr = sqlBuilder.String()
 }
 
+// 保存点的操作类型
+// savepoint operation type
+const (
+	// savepointBegin 创建保存点
+	savepointBegin = iota
+	// savepointRelease 释放保存点
+	savepointRelease
+	// savepointRollback 回滚到保存点
+	savepointRollback
+)
+
+// wrapSavepointSQL 根据数据库方言包装保存点语句,返回空字符串表示数据库不需要执行这个操作
+// wrapSavepointSQL Wrap the savepoint statement according to the dialect, empty string means the database does not need this operation
+func wrapSavepointSQL(dialect string, savepointType int, savepointName string) (string, error) {
+	var sqlBuilder strings.Builder
+	sqlBuilder.Grow(len(savepointName) + 30)
+	switch dialect {
+	case "mysql", "postgresql", "sqlite", "kingbase", "gbase":
+		switch savepointType {
+		case savepointBegin:
+			sqlBuilder.WriteString("SAVEPOINT ")
+		case savepointRelease:
+			sqlBuilder.WriteString("RELEASE SAVEPOINT ")
+		case savepointRollback:
+			sqlBuilder.WriteString("ROLLBACK TO SAVEPOINT ")
+		}
+	case "oracle", "dm", "shentong": //oracle,达梦,神通没有RELEASE SAVEPOINT,事务结束时自动释放
+		switch savepointType {
+		case savepointBegin:
+			sqlBuilder.WriteString("SAVEPOINT ")
+		case savepointRelease:
+			return "", nil
+		case savepointRollback:
+			sqlBuilder.WriteString("ROLLBACK TO SAVEPOINT ")
+		}
+	case "mssql": //sqlserver使用SAVE TRANSACTION,也没有释放保存点的语法
+		switch savepointType {
+		case savepointBegin:
+			sqlBuilder.WriteString("SAVE TRANSACTION ")
+		case savepointRelease:
+			return "", nil
+		case savepointRollback:
+			sqlBuilder.WriteString("ROLLBACK TRANSACTION ")
+		}
+	case "db2":
+		switch savepointType {
+		case savepointBegin:
+			sqlBuilder.WriteString("SAVEPOINT ")
+			sqlBuilder.WriteString(savepointName)
+			sqlBuilder.WriteString(" ON ROLLBACK RETAIN CURSORS")
+			return sqlBuilder.String(), nil
+		case savepointRelease:
+			sqlBuilder.WriteString("RELEASE SAVEPOINT ")
+		case savepointRollback:
+			sqlBuilder.WriteString("ROLLBACK TO SAVEPOINT ")
+		}
+	default:
+		return "", errors.New("->wrapSavepointSQL-->不支持保存点的数据库类型:" + dialect)
+	}
+	sqlBuilder.WriteString(savepointName)
+	return sqlBuilder.String(), nil
+}
+
 // getDialectFromConnection 从dbConnection中获取数据库方言,如果没有,从FuncReadWriteStrategy获取dbDao,获取dbdao.config.Dialect
 func getDialectFromConnection(ctx context.Context, dbConnection *dataBaseConnection, rwType int) (string, error) {
 	var dialect string
 	//dbConnection为nil,使用defaultDao
 	//dbConnection is nil, use default Dao
 	if dbConnection == nil {
-		dbdao, err := FuncReadWriteStrategy(ctx, rwType)
+		//ctx绑定了数据库名称,使用注册的DBDao
+		//The ctx binds a database name, use the registered DBDao
+		dbdao, err := getDBDaoFromContext(ctx)
 		if err != nil {
 			return dialect, err
 		}
-		dialect = dbdao.config.Dialect
+		if dbdao == nil {
+			dbdao, err = FuncReadWriteStrategy(ctx, rwType)
+			if err != nil {
+				return dialect, err
+			}
+		}
+		dbdaoConfig, _ := dbdao.loadDataSource()
+		dialect = dbdaoConfig.Dialect
 	} else {
 		dialect = dbConnection.config.Dialect
 	}
@@ -0,0 +1,55 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import "testing"
+
+func TestWrapSavepointSQL(t *testing.T) {
+	tests := []struct {
+		name          string
+		dialect       string
+		savepointType int
+		want          string
+		wantErr       bool
+	}{
+		{"mysql begin", "mysql", savepointBegin, "SAVEPOINT sp1", false},
+		{"mysql release", "mysql", savepointRelease, "RELEASE SAVEPOINT sp1", false},
+		{"mysql rollback", "mysql", savepointRollback, "ROLLBACK TO SAVEPOINT sp1", false},
+		{"postgresql rollback", "postgresql", savepointRollback, "ROLLBACK TO SAVEPOINT sp1", false},
+		{"oracle begin", "oracle", savepointBegin, "SAVEPOINT sp1", false},
+		{"oracle release is skipped", "oracle", savepointRelease, "", false},
+		{"mssql begin", "mssql", savepointBegin, "SAVE TRANSACTION sp1", false},
+		{"mssql release is skipped", "mssql", savepointRelease, "", false},
+		{"mssql rollback", "mssql", savepointRollback, "ROLLBACK TRANSACTION sp1", false},
+		{"db2 begin", "db2", savepointBegin, "SAVEPOINT sp1 ON ROLLBACK RETAIN CURSORS", false},
+		{"db2 release", "db2", savepointRelease, "RELEASE SAVEPOINT sp1", false},
+		{"unsupported dialect", "clickhouse", savepointBegin, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wrapSavepointSQL(tt.dialect, tt.savepointType, "sp1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wrapSavepointSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("wrapSavepointSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// DSNProvider 动态获取数据库连接字符串,配置到DataSourceConfig.DSNProvider
+// 打开连接池和每次创建新的数据库连接时调用,已经建立的连接不受影响,ConnMaxLifetimeSecond到期后使用新的DSN重建
+// DSNProvider Get the database connection string dynamically, configured to DataSourceConfig.DSNProvider
+// Called when the pool is opened and each new connection is created, established connections are rebuilt with the new DSN after ConnMaxLifetimeSecond
+type DSNProvider interface {
+	DSN(ctx context.Context) (string, error)
+}
+
+// FuncDSNProvider 函数类型的DSNProvider
+// FuncDSNProvider DSNProvider of function type
+type FuncDSNProvider func(ctx context.Context) (string, error)
+
+// DSN 实现DSNProvider接口
+func (funcDSNProvider FuncDSNProvider) DSN(ctx context.Context) (string, error) {
+	return funcDSNProvider(ctx)
+}
+
+// FileDSNProvider 从文件读取DSN或者密码,文件修改之后重新读取,适用于vault等sidecar挂载的短期凭证
+// FileDSNProvider Read DSN or password from a file and reload it after the file changes, for short-lived credentials mounted by vault-style sidecars
+type FileDSNProvider struct {
+	path    string
+	funcDSN func(content string) (string, error)
+	//mutex 保护下面的缓存
+	mutex sync.Mutex
+	//hash 文件内容的sha256,内容没有变化时使用缓存的DSN
+	hash [sha256.Size]byte
+	dsn  string
+}
+
+// NewFileDSNProvider 创建FileDSNProvider,path是文件路径,文件内容去掉首尾空白之后传给funcDSN生成DSN,funcDSN为nil时文件内容就是DSN
+// 文件保存密码时,使用funcDSN拼接DSN,例如 func(password string) (string, error) { return "root:" + password + "@tcp(127.0.0.1:3306)/zorm", nil }
+// NewFileDSNProvider Create a FileDSNProvider, the trimmed file content is passed to funcDSN to build the DSN, the content is the DSN if funcDSN is nil
+func NewFileDSNProvider(path string, funcDSN func(content string) (string, error)) (*FileDSNProvider, error) {
+	if path == "" {
+		return nil, errors.New("->NewFileDSNProvider-->path不能为空")
+	}
+	provider := &FileDSNProvider{path: path, funcDSN: funcDSN}
+	//验证文件可以读取
+	_, err := provider.DSN(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// DSN 实现DSNProvider接口,每次都读取文件,内容变化时重新生成DSN.sidecar轮换凭证时可能保留文件的修改时间和大小,所以比较内容的hash
+func (provider *FileDSNProvider) DSN(ctx context.Context) (string, error) {
+	content, err := ioutil.ReadFile(provider.path)
+	if err != nil {
+		return "", fmt.Errorf("->FileDSNProvider-->读取文件%s失败:%w", provider.path, err)
+	}
+	hash := sha256.Sum256(content)
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	if provider.dsn != "" && hash == provider.hash {
+		return provider.dsn, nil
+	}
+	dsn, err := wrapDSN(strings.TrimSpace(string(content)), provider.funcDSN)
+	if err != nil {
+		return "", fmt.Errorf("->FileDSNProvider-->文件%s:%w", provider.path, err)
+	}
+	provider.hash = hash
+	provider.dsn = dsn
+	return dsn, nil
+}
+
+// NewEnvDSNProvider 创建从环境变量读取DSN或者密码的DSNProvider,每次调用都读取环境变量.环境变量的值传给funcDSN生成DSN,funcDSN为nil时值就是DSN
+// NewEnvDSNProvider Create a DSNProvider that reads DSN or password from the environment variable on each call, the value is passed to funcDSN to build the DSN
+func NewEnvDSNProvider(key string, funcDSN func(value string) (string, error)) (DSNProvider, error) {
+	if key == "" {
+		return nil, errors.New("->NewEnvDSNProvider-->key不能为空")
+	}
+	provider := FuncDSNProvider(func(ctx context.Context) (string, error) {
+		dsn, err := wrapDSN(os.Getenv(key), funcDSN)
+		if err != nil {
+			return "", fmt.Errorf("->EnvDSNProvider-->环境变量%s:%w", key, err)
+		}
+		return dsn, nil
+	})
+	_, err := provider.DSN(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// wrapDSN 使用funcDSN把内容转换为DSN
+func wrapDSN(content string, funcDSN func(content string) (string, error)) (string, error) {
+	if content == "" {
+		return "", errors.New("内容为空")
+	}
+	if funcDSN == nil {
+		return content, nil
+	}
+	dsn, err := funcDSN(content)
+	if err != nil {
+		return "", err
+	}
+	if dsn == "" {
+		return "", errors.New("funcDSN返回的DSN为空")
+	}
+	return dsn, nil
+}
+
+// dsnConnector 每次创建新连接时从DSNProvider获取DSN,DSN没有变化时复用驱动的Connector
+type dsnConnector struct {
+	driver   driver.Driver
+	provider DSNProvider
+	//mutex 保护下面的缓存
+	mutex     sync.Mutex
+	dsn       string
+	connector driver.Connector
+}
+
+// openDSNProvider 使用DSNProvider打开连接池.先用当前的DSN打开一次,获取驱动
+func openDSNProvider(driverName string, provider DSNProvider) (*sql.DB, error) {
+	dsn, err := provider.DSN(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector := &dsnConnector{driver: db.Driver(), provider: provider}
+	db.Close()
+	return sql.OpenDB(connector), nil
+}
+
+// Connect 实现driver.Connector接口
+func (connector *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := connector.provider.DSN(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("->dsnConnector-->DSNProvider获取DSN失败:%w", err)
+	}
+	driverContext, ok := connector.driver.(driver.DriverContext)
+	if !ok {
+		return connector.driver.Open(dsn)
+	}
+	connector.mutex.Lock()
+	if connector.connector == nil || connector.dsn != dsn {
+		driverConnector, err := driverContext.OpenConnector(dsn)
+		if err != nil {
+			connector.mutex.Unlock()
+			return nil, err
+		}
+		connector.dsn = dsn
+		connector.connector = driverConnector
+	}
+	driverConnector := connector.connector
+	connector.mutex.Unlock()
+	return driverConnector.Connect(ctx)
+}
+
+// Driver 实现driver.Connector接口
+func (connector *dsnConnector) Driver() driver.Driver {
+	return connector.driver
+}
+
+// sameDSNProvider 是否是同一个DSNProvider.函数类型不能比较,认为是不同的
+func sameDSNProvider(a DSNProvider, b DSNProvider) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,484 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Condition Finder的查询条件,使用zorm.Eq,zorm.In,zorm.Or等函数创建,通过finder.Where添加
+// Condition The query condition of Finder, created by zorm.Eq, zorm.In, zorm.Or and so on, added by finder.Where
+type Condition interface {
+	// build 生成条件的SQL和参数,返回false表示条件被跳过
+	build(builder *conditionBuilder) (bool, error)
+}
+
+// conditionBuilder 生成条件的SQL和参数
+type conditionBuilder struct {
+	sqlBuilder strings.Builder
+	values     []interface{}
+	//skipEmpty 是否跳过值为nil或者空的条件
+	skipEmpty bool
+	//dialect 列名引号使用的方言
+	dialect string
+}
+
+// Where 添加查询条件,多个条件使用AND连接.第一次调用添加 WHERE,之后的调用或者Append已经添加了 WHERE 时添加 AND.条件的值使用 ? 占位符,slice类型的值在GetSQL时展开
+// 列名会校验并使用方言的引号包裹,mysql,clickhouse和TDengine使用反引号,mssql使用[],其他数据库使用双引号.方言使用finder.SetDialect设置,没有设置时使用默认DBDao的方言
+// 例如: finder.Where(zorm.Eq("status", 1), zorm.Or(zorm.Like("name", "%abc%"), zorm.In("id", ids)))
+// Where Add query conditions, multiple conditions are joined with AND. The first call adds WHERE, subsequent calls or a WHERE added by Append add AND. Values use the ? placeholder, slice values are expanded in GetSQL
+// Column names are validated and quoted by dialect, backticks for mysql, clickhouse and TDengine, [] for mssql, double quotes for others. The dialect is set by finder.SetDialect, the dialect of the default DBDao is used if not set
+func (finder *Finder) Where(conditions ...Condition) *Finder {
+	return finder.where(false, conditions)
+}
+
+// WhereNotEmpty 和Where一样,但是跳过值为nil,nil指针,空字符串,空slice或者map的条件,用于动态的查询页面.数字0不是空值
+// 所有的条件都被跳过时,不添加 WHERE
+// WhereNotEmpty The same as Where, but skips conditions whose value is nil, a nil pointer, an empty string, an empty slice or map, used for dynamic search screens. The number 0 is not empty
+// If all conditions are skipped, WHERE is not added
+func (finder *Finder) WhereNotEmpty(conditions ...Condition) *Finder {
+	return finder.where(true, conditions)
+}
+
+// where 添加查询条件
+func (finder *Finder) where(skipEmpty bool, conditions []Condition) *Finder {
+	//不要自己构建finder,使用NewFinder()方法
+	//Don't build finder by yourself, use NewFinder() method
+	if finder == nil || finder.values == nil {
+		return nil
+	}
+	if finder.err != nil {
+		return finder
+	}
+	builder := &conditionBuilder{skipEmpty: skipEmpty, dialect: finder.conditionDialect()}
+	has, err := builder.join(" AND ", conditions, false)
+	if err != nil {
+		finder.err = err
+		return finder
+	}
+	if !has {
+		return finder
+	}
+	//Append已经添加了 WHERE,继续添加 AND
+	//WHERE is already added by Append, continue with AND
+	if !finder.hasWhere && hasTopLevelWhere(finder.sqlBuilder.String()) {
+		finder.hasWhere = true
+	}
+	if finder.hasWhere {
+		finder.Append("AND "+builder.sqlBuilder.String(), builder.values...)
+	} else {
+		finder.Append("WHERE "+builder.sqlBuilder.String(), builder.values...)
+		finder.hasWhere = true
+	}
+	return finder
+}
+
+// SetDialect 设置Where生成列名引号使用的方言,需要在Where之前调用.Finder用于非默认的DBDao时使用
+// SetDialect Set the dialect used by Where to quote column names, call it before Where. Used when the Finder is executed by a non-default DBDao
+func (finder *Finder) SetDialect(dialect string) *Finder {
+	if finder != nil {
+		finder.dialect = dialect
+	}
+	return finder
+}
+
+// conditionDialect Where使用的方言,没有设置时使用默认DBDao的方言
+func (finder *Finder) conditionDialect() string {
+	if finder.dialect != "" {
+		return finder.dialect
+	}
+	dbDao, err := FuncReadWriteStrategy(context.Background(), 1)
+	if err != nil || dbDao == nil {
+		return ""
+	}
+	return dbDao.Dialect()
+}
+
+// hasTopLevelWhere SQL语句是否已经有最外层的 WHERE,忽略括号里的子查询和引号里的内容
+func hasTopLevelWhere(sqlstr string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(sqlstr); i++ {
+		c := sqlstr[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case 'w', 'W':
+			if depth != 0 || i+5 > len(sqlstr) || !strings.EqualFold(sqlstr[i:i+5], "where") {
+				continue
+			}
+			if (i > 0 && isConditionIdentifierByte(sqlstr[i-1])) || (i+5 < len(sqlstr) && isConditionIdentifierByte(sqlstr[i+5])) {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// isConditionIdentifierByte 是否是标识符的字符,用于判断关键字的边界
+func isConditionIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// join 使用separator连接多个条件,跳过的条件不输出.group为true且有多个条件时使用括号包裹
+func (builder *conditionBuilder) join(separator string, conditions []Condition, group bool) (bool, error) {
+	child := &conditionBuilder{skipEmpty: builder.skipEmpty, dialect: builder.dialect}
+	count := 0
+	for _, condition := range conditions {
+		if condition == nil {
+			continue
+		}
+		//先生成到临时的builder,跳过的条件不影响分隔符
+		item := &conditionBuilder{skipEmpty: builder.skipEmpty, dialect: builder.dialect}
+		has, err := condition.build(item)
+		if err != nil {
+			return false, err
+		}
+		if !has {
+			continue
+		}
+		if count > 0 {
+			child.sqlBuilder.WriteString(separator)
+		}
+		child.sqlBuilder.WriteString(item.sqlBuilder.String())
+		child.values = append(child.values, item.values...)
+		count++
+	}
+	if count < 1 {
+		return false, nil
+	}
+	if group && count > 1 {
+		builder.sqlBuilder.WriteString("(")
+		builder.sqlBuilder.WriteString(child.sqlBuilder.String())
+		builder.sqlBuilder.WriteString(")")
+	} else {
+		builder.sqlBuilder.WriteString(child.sqlBuilder.String())
+	}
+	builder.values = append(builder.values, child.values...)
+	return true, nil
+}
+
+// compareCondition 比较条件,例如 "name"=?
+type compareCondition struct {
+	column   string
+	operator string
+	value    interface{}
+}
+
+// build 实现Condition接口
+func (condition *compareCondition) build(builder *conditionBuilder) (bool, error) {
+	//先校验列名,跳过的条件也不允许不合法的列名
+	column, err := wrapConditionColumn(builder.dialect, condition.column)
+	if err != nil {
+		return false, err
+	}
+	if builder.skipEmpty && isEmptyConditionValue(condition.value) {
+		return false, nil
+	}
+	builder.sqlBuilder.WriteString(column)
+	builder.sqlBuilder.WriteString(condition.operator)
+	builder.sqlBuilder.WriteString("?")
+	builder.values = append(builder.values, condition.value)
+	return true, nil
+}
+
+// Eq 等于, "column"=?
+func Eq(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: "=", value: value}
+}
+
+// Ne 不等于, "column"<>?
+func Ne(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: "<>", value: value}
+}
+
+// Gt 大于, "column">?
+func Gt(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: ">", value: value}
+}
+
+// Ge 大于等于, "column">=?
+func Ge(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: ">=", value: value}
+}
+
+// Lt 小于, "column"<?
+func Lt(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: "<", value: value}
+}
+
+// Le 小于等于, "column"<=?
+func Le(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: "<=", value: value}
+}
+
+// Like 模糊查询, "column" LIKE ?,通配符由调用方放到value里,例如 "%abc%"
+func Like(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: " LIKE ", value: value}
+}
+
+// NotLike 模糊查询取反, "column" NOT LIKE ?
+func NotLike(column string, value interface{}) Condition {
+	return &compareCondition{column: column, operator: " NOT LIKE ", value: value}
+}
+
+// inCondition IN条件, "column" IN (?),slice类型的值在GetSQL时展开
+type inCondition struct {
+	column string
+	not    bool
+	values interface{}
+}
+
+// build 实现Condition接口.空的slice在没有跳过时, IN 输出永远为假的 1=0, NOT IN 输出永远为真的 1=1
+func (condition *inCondition) build(builder *conditionBuilder) (bool, error) {
+	column, err := wrapConditionColumn(builder.dialect, condition.column)
+	if err != nil {
+		return false, err
+	}
+	if isEmptyConditionValue(condition.values) {
+		if builder.skipEmpty {
+			return false, nil
+		}
+		if condition.not {
+			builder.sqlBuilder.WriteString("1=1")
+		} else {
+			builder.sqlBuilder.WriteString("1=0")
+		}
+		return true, nil
+	}
+	builder.sqlBuilder.WriteString(column)
+	if condition.not {
+		builder.sqlBuilder.WriteString(" NOT IN (?)")
+	} else {
+		builder.sqlBuilder.WriteString(" IN (?)")
+	}
+	builder.values = append(builder.values, condition.values)
+	return true, nil
+}
+
+// In 在列表中, "column" IN (?,?,?),values是slice或者数组
+func In(column string, values interface{}) Condition {
+	return &inCondition{column: column, values: values}
+}
+
+// NotIn 不在列表中, "column" NOT IN (?,?,?),values是slice或者数组
+func NotIn(column string, values interface{}) Condition {
+	return &inCondition{column: column, not: true, values: values}
+}
+
+// nullCondition IS NULL 条件,没有值,不会被跳过
+type nullCondition struct {
+	column string
+	not    bool
+}
+
+// build 实现Condition接口
+func (condition *nullCondition) build(builder *conditionBuilder) (bool, error) {
+	column, err := wrapConditionColumn(builder.dialect, condition.column)
+	if err != nil {
+		return false, err
+	}
+	builder.sqlBuilder.WriteString(column)
+	if condition.not {
+		builder.sqlBuilder.WriteString(" IS NOT NULL")
+	} else {
+		builder.sqlBuilder.WriteString(" IS NULL")
+	}
+	return true, nil
+}
+
+// IsNull 为空, "column" IS NULL
+func IsNull(column string) Condition {
+	return &nullCondition{column: column}
+}
+
+// IsNotNull 不为空, "column" IS NOT NULL
+func IsNotNull(column string) Condition {
+	return &nullCondition{column: column, not: true}
+}
+
+// betweenCondition BETWEEN条件,跳过空值时,只有一个值使用 >= 或者 <=
+type betweenCondition struct {
+	column string
+	start  interface{}
+	end    interface{}
+}
+
+// build 实现Condition接口
+func (condition *betweenCondition) build(builder *conditionBuilder) (bool, error) {
+	column, err := wrapConditionColumn(builder.dialect, condition.column)
+	if err != nil {
+		return false, err
+	}
+	if builder.skipEmpty {
+		emptyStart := isEmptyConditionValue(condition.start)
+		emptyEnd := isEmptyConditionValue(condition.end)
+		if emptyStart && emptyEnd {
+			return false, nil
+		}
+		if emptyStart {
+			return Le(condition.column, condition.end).build(builder)
+		}
+		if emptyEnd {
+			return Ge(condition.column, condition.start).build(builder)
+		}
+	}
+	builder.sqlBuilder.WriteString(column)
+	builder.sqlBuilder.WriteString(" BETWEEN ? AND ?")
+	builder.values = append(builder.values, condition.start, condition.end)
+	return true, nil
+}
+
+// Between 在范围内, "column" BETWEEN ? AND ?.WhereNotEmpty时,只有start使用 >=,只有end使用 <=
+func Between(column string, start interface{}, end interface{}) Condition {
+	return &betweenCondition{column: column, start: start, end: end}
+}
+
+// groupCondition 多个条件的组合
+type groupCondition struct {
+	separator  string
+	conditions []Condition
+}
+
+// build 实现Condition接口,跳过的条件不输出,多个条件使用括号包裹
+func (condition *groupCondition) build(builder *conditionBuilder) (bool, error) {
+	return builder.join(condition.separator, condition.conditions, true)
+}
+
+// And 多个条件使用AND连接,并使用括号包裹
+func And(conditions ...Condition) Condition {
+	return &groupCondition{separator: " AND ", conditions: conditions}
+}
+
+// Or 多个条件使用OR连接,并使用括号包裹,例如 ("name" LIKE ? OR "id" IN (?))
+func Or(conditions ...Condition) Condition {
+	return &groupCondition{separator: " OR ", conditions: conditions}
+}
+
+// exprCondition 自定义的SQL片段
+type exprCondition struct {
+	sqlstr string
+	values []interface{}
+}
+
+// build 实现Condition接口,SQL片段不会被跳过
+func (condition *exprCondition) build(builder *conditionBuilder) (bool, error) {
+	if strings.TrimSpace(condition.sqlstr) == "" {
+		return false, nil
+	}
+	builder.sqlBuilder.WriteString(condition.sqlstr)
+	builder.values = append(builder.values, condition.values...)
+	return true, nil
+}
+
+// Expr 自定义的SQL片段和参数,用于其他函数不支持的条件,例如 zorm.Expr("create_time>now()-interval '1 day'").SQL片段原样输出,不要拼接用户的输入
+// Expr Custom SQL fragment and values, for conditions not supported by other functions. The fragment is output as is, do not concatenate user input
+func Expr(sqlstr string, values ...interface{}) Condition {
+	return &exprCondition{sqlstr: sqlstr, values: values}
+}
+
+// errConditionColumn 列名不合法
+func errConditionColumn(column string) error {
+	return fmt.Errorf("->Finder.Where-->列名%q不合法,只能包含字母,数字,下划线和$,可以使用 . 分隔表别名,例如 u.user_name", column)
+}
+
+// conditionQuote 方言的列名引号,mysql,clickhouse和TDengine使用反引号,mssql使用[],其他数据库使用标准SQL的双引号
+func conditionQuote(dialect string) (string, string) {
+	switch dialect {
+	case "mysql", "clickhouse", "tdengine":
+		return "`", "`"
+	case "mssql":
+		return "[", "]"
+	}
+	return `"`, `"`
+}
+
+// wrapConditionColumn 校验列名,使用方言的引号包裹.表别名和列名分别包裹,例如 u.name 在mysql输出 `u`.`name`
+func wrapConditionColumn(dialect string, column string) (string, error) {
+	if column == "" {
+		return "", errConditionColumn(column)
+	}
+	quoteOpen, quoteClose := conditionQuote(dialect)
+	parts := strings.Split(column, ".")
+	var builder strings.Builder
+	builder.Grow(len(column) + 2*len(parts))
+	for i, part := range parts {
+		if !isConditionIdentifier(part) {
+			return "", errConditionColumn(column)
+		}
+		if i > 0 {
+			builder.WriteString(".")
+		}
+		builder.WriteString(quoteOpen)
+		builder.WriteString(part)
+		builder.WriteString(quoteClose)
+	}
+	return builder.String(), nil
+}
+
+// isConditionIdentifier 是否是合法的标识符,字母或者下划线开头,包含字母,数字,下划线和$
+func isConditionIdentifier(identifier string) bool {
+	if identifier == "" {
+		return false
+	}
+	for i, r := range identifier {
+		if r == '_' || unicode.IsLetter(r) {
+			continue
+		}
+		if i > 0 && (r == '$' || unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// isEmptyConditionValue 值是否为空:nil,nil指针,空字符串,长度为0的slice,数组或者map.数字0和false不是空值
+func isEmptyConditionValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	valueOf := reflect.ValueOf(value)
+	for valueOf.Kind() == reflect.Ptr || valueOf.Kind() == reflect.Interface {
+		if valueOf.IsNil() {
+			return true
+		}
+		valueOf = valueOf.Elem()
+	}
+	switch valueOf.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return valueOf.Len() == 0
+	}
+	return false
+}
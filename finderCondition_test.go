@@ -0,0 +1,207 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWrapConditionColumn(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		column  string
+		want    string
+		wantErr bool
+	}{
+		{"mysql", "mysql", "user_name", "`user_name`", false},
+		{"mysql table alias", "mysql", "u.user_name", "`u`.`user_name`", false},
+		{"clickhouse", "clickhouse", "id", "`id`", false},
+		{"tdengine", "tdengine", "ts", "`ts`", false},
+		{"mssql", "mssql", "u.id", "[u].[id]", false},
+		{"postgresql", "postgresql", "u.id", `"u"."id"`, false},
+		{"unknown dialect", "", "id", `"id"`, false},
+		{"dollar and digits", "oracle", "col$1", `"col$1"`, false},
+		{"unicode letters", "mysql", "名称", "`名称`", false},
+		{"empty", "mysql", "", "", true},
+		{"leading digit", "mysql", "1id", "", true},
+		{"empty part", "mysql", "u..id", "", true},
+		{"trailing dot", "mysql", "u.", "", true},
+		{"space", "mysql", "id desc", "", true},
+		{"injection", "mysql", "id`=1 OR `1", "", true},
+		{"expression", "mysql", "count(*)", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wrapConditionColumn(tt.dialect, tt.column)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wrapConditionColumn(%q) error = %v, wantErr %v", tt.column, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("wrapConditionColumn(%q) = %q, want %q", tt.column, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionBuilderJoin(t *testing.T) {
+	tests := []struct {
+		name       string
+		skipEmpty  bool
+		conditions []Condition
+		wantHas    bool
+		wantSQL    string
+		wantValues []interface{}
+		wantErr    bool
+	}{
+		{"empty", false, nil, false, "", nil, false},
+		{"nil condition", false, []Condition{nil}, false, "", nil, false},
+		{"compare", false, []Condition{Eq("a", 1), Ne("b", 2), Gt("c", 3), Ge("d", 4), Lt("e", 5), Le("f", 6)}, true, "`a`=? AND `b`<>? AND `c`>? AND `d`>=? AND `e`<? AND `f`<=?", []interface{}{1, 2, 3, 4, 5, 6}, false},
+		{"like", false, []Condition{Like("a", "%x%"), NotLike("b", "y%")}, true, "`a` LIKE ? AND `b` NOT LIKE ?", []interface{}{"%x%", "y%"}, false},
+		{"in", false, []Condition{In("id", []int{1, 2}), NotIn("id", []int{3})}, true, "`id` IN (?) AND `id` NOT IN (?)", []interface{}{[]int{1, 2}, []int{3}}, false},
+		{"empty in", false, []Condition{In("id", []int{}), NotIn("id", nil)}, true, "1=0 AND 1=1", nil, false},
+		{"null", false, []Condition{IsNull("a"), IsNotNull("b")}, true, "`a` IS NULL AND `b` IS NOT NULL", nil, false},
+		{"between", false, []Condition{Between("age", 18, 60)}, true, "`age` BETWEEN ? AND ?", []interface{}{18, 60}, false},
+		{"or group", false, []Condition{Eq("a", 1), Or(Eq("b", 2), Eq("c", 3))}, true, "`a`=? AND (`b`=? OR `c`=?)", []interface{}{1, 2, 3}, false},
+		{"single condition group", false, []Condition{Or(Eq("b", 2))}, true, "`b`=?", []interface{}{2}, false},
+		{"nested group", false, []Condition{Or(Eq("a", 1), And(Eq("b", 2), Eq("c", 3)))}, true, "(`a`=? OR (`b`=? AND `c`=?))", []interface{}{1, 2, 3}, false},
+		{"expr", false, []Condition{Expr("create_time>?", 1), Expr(" ")}, true, "create_time>?", []interface{}{1}, false},
+		{"skip empty values", true, []Condition{Eq("a", ""), Eq("b", 0), In("c", []int{}), Like("d", nil)}, true, "`b`=?", []interface{}{0}, false},
+		{"skip empty group", true, []Condition{Or(Eq("a", ""), Eq("b", nil)), Eq("c", 1)}, true, "`c`=?", []interface{}{1}, false},
+		{"all skipped", true, []Condition{Eq("a", ""), In("b", nil)}, false, "", nil, false},
+		{"between start only", true, []Condition{Between("age", 18, nil)}, true, "`age`>=?", []interface{}{18}, false},
+		{"between end only", true, []Condition{Between("age", "", 60)}, true, "`age`<=?", []interface{}{60}, false},
+		{"invalid column", false, []Condition{Eq("a", 1), Eq("b;DROP", 2)}, false, "", nil, true},
+		{"invalid column in skipped condition", true, []Condition{Eq("b;DROP", nil)}, false, "", nil, true},
+		{"invalid column in skipped between", true, []Condition{Between("b;DROP", nil, "")}, false, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &conditionBuilder{skipEmpty: tt.skipEmpty, dialect: "mysql"}
+			has, err := builder.join(" AND ", tt.conditions, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("join() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if has != tt.wantHas {
+				t.Errorf("join() = %v, want %v", has, tt.wantHas)
+			}
+			if got := builder.sqlBuilder.String(); got != tt.wantSQL {
+				t.Errorf("join() sql = %q, want %q", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(builder.values, tt.wantValues) {
+				t.Errorf("join() values = %v, want %v", builder.values, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestHasTopLevelWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		sqlstr string
+		want   bool
+	}{
+		{"no where", "SELECT * FROM t", false},
+		{"where", "SELECT * FROM t WHERE a=?", true},
+		{"lower case", "select * from t where a=?", true},
+		{"where in subquery", "SELECT * FROM (SELECT * FROM t WHERE a=1) x", false},
+		{"where after subquery", "SELECT * FROM (SELECT * FROM t) x WHERE a=1", true},
+		{"where in string", "SELECT 'where' FROM t", false},
+		{"where in quoted identifier", "SELECT `where`,\"where\" FROM t", false},
+		{"identifier containing where", "SELECT nowhere,where_id,t.where FROM somewhere", false},
+		{"where at the end", "SELECT * FROM t WHERE", true},
+		{"where followed by newline", "SELECT * FROM t\nWHERE\na=1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasTopLevelWhere(tt.sqlstr); got != tt.want {
+				t.Errorf("hasTopLevelWhere(%q) = %v, want %v", tt.sqlstr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFinderWhere(t *testing.T) {
+	tests := []struct {
+		name       string
+		finder     func() *Finder
+		wantSQL    string
+		wantValues []interface{}
+		wantErr    bool
+	}{
+		{
+			name: "where then and",
+			finder: func() *Finder {
+				return NewSelectFinder("t").SetDialect("postgresql").Where(Eq("a", 1)).Where(In("b", []int{2, 3}))
+			},
+			wantSQL:    `SELECT * FROM t WHERE "a"=? AND "b" IN (?,?)`,
+			wantValues: []interface{}{1, 2, 3},
+		},
+		{
+			name: "where added by append",
+			finder: func() *Finder {
+				return NewSelectFinder("t").SetDialect("mysql").Append("WHERE a=?", 1).Where(Eq("b", 2))
+			},
+			wantSQL:    "SELECT * FROM t WHERE a=? AND `b`=?",
+			wantValues: []interface{}{1, 2},
+		},
+		{
+			name: "where only in subquery",
+			finder: func() *Finder {
+				return NewSelectFinder("(SELECT * FROM t WHERE a=1) x").SetDialect("mssql").Where(Eq("b", 2))
+			},
+			wantSQL:    "SELECT * FROM (SELECT * FROM t WHERE a=1) x WHERE [b]=?",
+			wantValues: []interface{}{2},
+		},
+		{
+			name: "all conditions skipped",
+			finder: func() *Finder {
+				return NewSelectFinder("t").SetDialect("mysql").WhereNotEmpty(Eq("a", ""))
+			},
+			wantSQL:    "SELECT * FROM t",
+			wantValues: []interface{}{},
+		},
+		{
+			name: "invalid column",
+			finder: func() *Finder {
+				return NewSelectFinder("t").SetDialect("mysql").Where(Eq("a=1 OR 1", 1))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder := tt.finder()
+			got, err := finder.GetSQL()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.wantSQL {
+				t.Errorf("GetSQL() = %q, want %q", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(finder.values, tt.wantValues) {
+				t.Errorf("GetSQL() values = %v, want %v", finder.values, tt.wantValues)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// OperationKind 数据库操作的类型
+// OperationKind The kind of database operation
+type OperationKind string
+
+const (
+	// OperationExec 执行更新语句
+	OperationExec OperationKind = "exec"
+	// OperationQuery 执行查询语句
+	OperationQuery OperationKind = "query"
+	// OperationQueryRow 执行单行查询语句,目前用于获取自增主键的INSERT ... RETURNING
+	OperationQueryRow OperationKind = "queryRow"
+	// OperationBegin 开启事务
+	OperationBegin OperationKind = "begin"
+	// OperationCommit 提交事务
+	OperationCommit OperationKind = "commit"
+	// OperationRollback 回滚事务
+	OperationRollback OperationKind = "rollback"
+)
+
+// Operation 拦截器处理的数据库操作
+// Operation The database operation handled by interceptors
+type Operation struct {
+	//Ctx 操作的ctx,拦截器可以替换,例如放入追踪信息
+	//Ctx The ctx of the operation, interceptors can replace it, for example to put tracing information
+	Ctx context.Context
+	//Kind 操作的类型
+	//Kind The kind of the operation
+	Kind OperationKind
+	//SQL 执行的SQL语句,已经处理过方言和hint.拦截器可以修改,例如增加租户条件.事务操作为空
+	//SQL The SQL statement, dialect and hint are already processed. Interceptors can modify it, for example to add tenant conditions. Empty for transaction operations
+	SQL string
+	//Args SQL的参数,拦截器可以修改
+	//Args The SQL arguments, interceptors can modify them
+	Args []interface{}
+	//Dialect 数据库方言
+	//Dialect Database dialect
+	Dialect string
+	//Table 从SQL中解析的表名,尽力而为,解析不到为空
+	//Table The table name parsed from SQL, best effort, empty if not parsed
+	Table string
+	//DBDao 执行操作的DBDao
+	//DBDao The DBDao that performs the operation
+	DBDao *DBDao
+}
+
+// OperationResult 数据库操作的结果,根据Kind只有一个字段有值
+// OperationResult The result of the database operation, only one field has a value according to Kind
+type OperationResult struct {
+	//Result OperationExec的结果
+	Result sql.Result
+	//Rows OperationQuery的结果
+	Rows *sql.Rows
+	//Row OperationQueryRow的结果
+	Row *sql.Row
+}
+
+// InterceptorNext 调用下一个拦截器,最后一个拦截器的next执行真正的数据库操作
+// InterceptorNext Call the next interceptor, the next of the last interceptor performs the real database operation
+type InterceptorNext func(op *Operation) (OperationResult, error)
+
+// Interceptor 数据库操作的拦截器,调用next继续执行,不调用next就是短路.
+// OperationExec可以返回自己的Result短路,其他操作短路时只能返回错误,事务操作没有调用next时zorm会回滚事务
+// Interceptor The interceptor of database operations, call next to continue, not calling next short-circuits.
+// OperationExec can short-circuit with its own Result, other operations can only short-circuit with an error, zorm rolls back the transaction if a transaction operation does not call next
+type Interceptor func(op *Operation, next InterceptorNext) (OperationResult, error)
+
+// interceptorChain 拦截器链,写时复制,值是[]Interceptor
+var interceptorChain atomic.Value
+
+// interceptorMutex 保证Use串行执行
+var interceptorMutex sync.Mutex
+
+// errInterceptorShortCircuit 拦截器没有调用next,也没有返回错误
+var errInterceptorShortCircuit = errors.New("->Interceptor-->拦截器没有调用next,只能返回错误短路")
+
+// Use 注册拦截器,按照注册的顺序组成拦截器链,先注册的在外层.拦截所有的execContext,queryContext,queryRowContext和事务的开启,提交,回滚
+// 一般在init里调用,替代OverrideFunc,多个拦截器可以共存,例如追踪,监控和租户过滤
+// Use Register interceptors, which compose in the order of registration, the first registered is the outermost. Intercepts all execContext, queryContext, queryRowContext and transaction begin, commit, rollback
+// Usually called in init, replaces OverrideFunc, multiple interceptors can coexist, such as tracing, metrics and tenant filtering
+func Use(interceptors ...Interceptor) {
+	interceptorMutex.Lock()
+	defer interceptorMutex.Unlock()
+	oldChain := getInterceptorChain()
+	chain := make([]Interceptor, 0, len(oldChain)+len(interceptors))
+	chain = append(chain, oldChain...)
+	for _, interceptor := range interceptors {
+		if interceptor != nil {
+			chain = append(chain, interceptor)
+		}
+	}
+	interceptorChain.Store(chain)
+}
+
+// getInterceptorChain 获取拦截器链
+func getInterceptorChain() []Interceptor {
+	chain, _ := interceptorChain.Load().([]Interceptor)
+	return chain
+}
+
+// invokeInterceptors 从index开始执行拦截器链,最后执行last
+func invokeInterceptors(chain []Interceptor, index int, op *Operation, last InterceptorNext) (OperationResult, error) {
+	if index >= len(chain) {
+		return last(op)
+	}
+	return chain[index](op, func(op *Operation) (OperationResult, error) {
+		return invokeInterceptors(chain, index+1, op, last)
+	})
+}
+
+// newOperation 创建Operation
+func (dbConnection *dataBaseConnection) newOperation(ctx context.Context, kind OperationKind, sqlstr string, args []interface{}) *Operation {
+	return &Operation{
+		Ctx:     ctx,
+		Kind:    kind,
+		SQL:     sqlstr,
+		Args:    args,
+		Dialect: dbConnection.config.Dialect,
+		Table:   parseSQLTable(sqlstr),
+		DBDao:   dbConnection.dbDao,
+	}
+}
+
+// interceptExec 经过拦截器链执行更新语句,sqlstr和args更新为拦截器修改之后实际执行的语句和参数
+func (dbConnection *dataBaseConnection) interceptExec(ctx context.Context, sqlstr *string, args *[]interface{}) (sql.Result, error) {
+	chain := getInterceptorChain()
+	if len(chain) == 0 {
+		return dbConnection.executor().ExecContext(ctx, *sqlstr, *args...)
+	}
+	op := dbConnection.newOperation(ctx, OperationExec, *sqlstr, *args)
+	result, err := invokeInterceptors(chain, 0, op, func(op *Operation) (OperationResult, error) {
+		*sqlstr, *args = op.SQL, op.Args
+		res, err := dbConnection.executor().ExecContext(op.Ctx, op.SQL, op.Args...)
+		return OperationResult{Result: res}, err
+	})
+	if err == nil && result.Result == nil {
+		return nil, errInterceptorShortCircuit
+	}
+	return result.Result, err
+}
+
+// interceptQuery 经过拦截器链执行查询语句,sqlstr和args更新为拦截器修改之后实际执行的语句和参数
+func (dbConnection *dataBaseConnection) interceptQuery(ctx context.Context, sqlstr *string, args *[]interface{}) (*sql.Rows, error) {
+	chain := getInterceptorChain()
+	if len(chain) == 0 {
+		return dbConnection.executor().QueryContext(ctx, *sqlstr, *args...)
+	}
+	op := dbConnection.newOperation(ctx, OperationQuery, *sqlstr, *args)
+	result, err := invokeInterceptors(chain, 0, op, func(op *Operation) (OperationResult, error) {
+		*sqlstr, *args = op.SQL, op.Args
+		rows, err := dbConnection.executor().QueryContext(op.Ctx, op.SQL, op.Args...)
+		return OperationResult{Rows: rows}, err
+	})
+	if err == nil && result.Rows == nil {
+		return nil, errInterceptorShortCircuit
+	}
+	return result.Rows, err
+}
+
+// interceptQueryRow 经过拦截器链执行单行查询语句,sqlstr和args更新为拦截器修改之后实际执行的语句和参数
+func (dbConnection *dataBaseConnection) interceptQueryRow(ctx context.Context, sqlstr *string, args *[]interface{}) (*sql.Row, error) {
+	chain := getInterceptorChain()
+	if len(chain) == 0 {
+		return dbConnection.executor().QueryRowContext(ctx, *sqlstr, *args...), nil
+	}
+	op := dbConnection.newOperation(ctx, OperationQueryRow, *sqlstr, *args)
+	result, err := invokeInterceptors(chain, 0, op, func(op *Operation) (OperationResult, error) {
+		*sqlstr, *args = op.SQL, op.Args
+		row := dbConnection.executor().QueryRowContext(op.Ctx, op.SQL, op.Args...)
+		return OperationResult{Row: row}, nil
+	})
+	if err == nil && result.Row == nil {
+		return nil, errInterceptorShortCircuit
+	}
+	return result.Row, err
+}
+
+// interceptTxBegin 经过拦截器链开启事务,begin执行真正的开启
+func (dbConnection *dataBaseConnection) interceptTxBegin(ctx context.Context, begin func(ctx context.Context) error) error {
+	chain := getInterceptorChain()
+	if len(chain) == 0 {
+		return begin(ctx)
+	}
+	called := false
+	_, err := invokeInterceptors(chain, 0, dbConnection.newOperation(ctx, OperationBegin, "", nil), func(op *Operation) (OperationResult, error) {
+		called = true
+		return OperationResult{}, begin(op.Ctx)
+	})
+	if err == nil && !called {
+		return errInterceptorShortCircuit
+	}
+	return err
+}
+
+// interceptTxEnd 经过拦截器链提交或者回滚事务,end执行真正的提交或者回滚.拦截器没有调用next时执行abort回滚事务,避免连接泄露
+// 拦截器调用next提交成功之后再返回错误,事务已经提交,不会被撤销,只是Transaction返回这个错误
+func (dbConnection *dataBaseConnection) interceptTxEnd(ctx context.Context, kind OperationKind, end func() error, abort func() error) error {
+	chain := getInterceptorChain()
+	if len(chain) == 0 {
+		return end()
+	}
+	called := false
+	_, err := invokeInterceptors(chain, 0, dbConnection.newOperation(ctx, kind, "", nil), func(op *Operation) (OperationResult, error) {
+		called = true
+		return OperationResult{}, end()
+	})
+	if called {
+		return err
+	}
+	errAbort := abort()
+	if err == nil {
+		err = errInterceptorShortCircuit
+	}
+	if errAbort != nil {
+		FuncLogError(ctx, errAbort)
+	}
+	return err
+}
+
+// txContext 开启事务的ctx,用于提交和回滚的拦截器
+func (dbConnection *dataBaseConnection) txContext() context.Context {
+	if dbConnection.txRecord != nil && dbConnection.txRecord.ctx != nil {
+		return dbConnection.txRecord.ctx
+	}
+	return context.Background()
+}
+
+// sqlIdentifierQuoteReplacer 去掉标识符的引号
+var sqlIdentifierQuoteReplacer = strings.NewReplacer("`", "", `"`, "", "[", "", "]", "")
+
+// parseSQLTable 从SQL中解析表名,取FROM,INTO或者UPDATE之后的第一个标识符,去掉引号.尽力而为,复杂的SQL可能解析不准确
+func parseSQLTable(sqlstr string) string {
+	if sqlstr == "" {
+		return ""
+	}
+	words := strings.Fields(sqlstr)
+	for i := 0; i < len(words)-1; i++ {
+		keyword := strings.ToUpper(words[i])
+		if keyword != "FROM" && keyword != "INTO" && keyword != "UPDATE" {
+			continue
+		}
+		table := words[i+1]
+		//子查询或者函数参数,例如 EXTRACT(EPOCH FROM now())
+		if strings.HasPrefix(table, "(") {
+			continue
+		}
+		if index := strings.IndexAny(table, "(,;)"); index >= 0 {
+			//FROM之后的函数调用,例如 FROM now() 或者 FROM generate_series(1,10)
+			if keyword == "FROM" && table[index] == '(' {
+				continue
+			}
+			table = table[:index]
+		}
+		table = sqlIdentifierQuoteReplacer.Replace(table)
+		if table != "" {
+			return table
+		}
+	}
+	return ""
+}
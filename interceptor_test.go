@@ -0,0 +1,51 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"testing"
+)
+
+func TestParseSQLTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		sqlstr string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"select", "SELECT * FROM t_user WHERE id=?", "t_user"},
+		{"lower case", "select id from t_user", "t_user"},
+		{"insert with column list", "INSERT INTO `t_user`(id,name) VALUES (?,?)", "t_user"},
+		{"update with schema", `UPDATE "public"."t_user" SET name=?`, "public.t_user"},
+		{"delete mssql", "DELETE FROM [dbo].[t_user] WHERE id=@p1", "dbo.t_user"},
+		{"table followed by comma", "SELECT * FROM t_user,t_org", "t_user"},
+		{"subquery", "SELECT * FROM (SELECT * FROM t_user) t", "t_user"},
+		{"function argument", "SELECT EXTRACT(EPOCH FROM now())", ""},
+		{"table function", "SELECT * FROM generate_series(1,10)", ""},
+		{"no table", "SELECT 1", ""},
+		{"keyword at the end", "SELECT * FROM", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSQLTable(tt.sqlstr); got != tt.want {
+				t.Errorf("parseSQLTable(%q) = %q, want %q", tt.sqlstr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// NPlusOneDetector N+1查询检测,用于开发和测试环境.同一个请求或者事务中,相同指纹的SELECT语句执行超过Threshold次时报告,一般是循环里调用zorm.QueryRow
+// 请求的范围使用zorm.BindContextNPlusOneDetector在http中间件里绑定,没有绑定时使用事务的范围,都没有时不检测
+// NPlusOneDetector N+1 query detection for development and test environments. Reported when SELECT statements with the same fingerprint run more than Threshold times in one request or transaction, typically zorm.QueryRow in a loop
+// The request scope is bound by zorm.BindContextNPlusOneDetector in http middleware, the transaction scope is used if not bound, no detection if neither
+type NPlusOneDetector struct {
+	//Threshold 相同指纹的语句允许执行的次数,超过之后报告,默认5
+	//Threshold The number of times statements with the same fingerprint are allowed to run, reported when exceeded, default 5
+	Threshold int
+	//Fail 测试模式,检测到N+1查询时语句返回*NPlusOneError,让请求失败.默认false只输出日志
+	//Fail Test mode, the statement returns *NPlusOneError when N+1 query is detected so the request fails. Default false only logs
+	Fail bool
+}
+
+// DefaultNPlusOneDetector zorm使用的N+1查询检测,默认nil不检测,生产环境不要开启.需要在init里设置
+// DefaultNPlusOneDetector The N+1 query detection used by zorm, default nil no detection, do not enable it in production. Set it in init
+var DefaultNPlusOneDetector *NPlusOneDetector = nil
+
+// NPlusOneError 检测到的N+1查询
+// NPlusOneError The detected N+1 query
+type NPlusOneError struct {
+	//SQL 重复执行的SQL语句
+	//SQL The repeated SQL statement
+	SQL string
+	//Fingerprint SQL语句的指纹
+	//Fingerprint The fingerprint of the SQL statement
+	Fingerprint string
+	//Caller 业务调用zorm的文件:行号
+	//Caller The caller's file:line
+	Caller string
+	//Count 执行的次数
+	//Count The number of executions
+	Count int
+}
+
+// Error 实现error接口
+func (nPlusOneError *NPlusOneError) Error() string {
+	return fmt.Sprintf("->NPlusOneDetector-->%s 相同的SQL语句执行了%d次,可能是N+1查询,请使用IN批量查询:%s", nPlusOneError.Caller, nPlusOneError.Count, nPlusOneError.SQL)
+}
+
+// IsNPlusOneError 判断错误链中是否有NPlusOneError
+// IsNPlusOneError Determine whether there is a NPlusOneError in the error chain
+func IsNPlusOneError(err error) bool {
+	var nPlusOneError *NPlusOneError
+	return errors.As(err, &nPlusOneError)
+}
+
+// nPlusOneState 一个请求或者事务中,每个指纹执行的次数
+type nPlusOneState struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// contextNPlusOneValueKey 把请求范围的N+1检测状态放到context里使用的key
+const contextNPlusOneValueKey = wrapContextStringKey("contextNPlusOneValueKey")
+
+// BindContextNPlusOneDetector context绑定N+1查询检测的请求范围,一般在http中间件里调用,使用这个ctx的语句一起计数.需要设置zorm.DefaultNPlusOneDetector
+// BindContextNPlusOneDetector Bind the request scope of N+1 query detection to the context, usually called in http middleware, statements using this ctx are counted together. zorm.DefaultNPlusOneDetector is required
+func BindContextNPlusOneDetector(parent context.Context) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextNPlusOneDetector-->context的parent不能为nil")
+	}
+	ctx := context.WithValue(parent, contextNPlusOneValueKey, &nPlusOneState{})
+	return ctx, nil
+}
+
+// detectNPlusOne 统计SELECT语句的执行次数,超过阈值时报告一次.测试模式返回*NPlusOneError
+func (dbConnection *dataBaseConnection) detectNPlusOne(ctx context.Context, sqlstr string) error {
+	detector := DefaultNPlusOneDetector
+	if detector == nil || !isSelectSQL(sqlstr) {
+		return nil
+	}
+	state, _ := ctx.Value(contextNPlusOneValueKey).(*nPlusOneState)
+	if state == nil && dbConnection.inTransaction() {
+		if dbConnection.nPlusOne == nil {
+			dbConnection.nPlusOne = &nPlusOneState{}
+		}
+		state = dbConnection.nPlusOne
+	}
+	if state == nil {
+		return nil
+	}
+	threshold := detector.Threshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+	fingerprint := fingerprintSQL(sqlstr)
+	state.mutex.Lock()
+	if state.counts == nil {
+		state.counts = make(map[string]int)
+	}
+	state.counts[fingerprint]++
+	count := state.counts[fingerprint]
+	state.mutex.Unlock()
+	//只在第一次超过时报告日志,测试模式每次都返回错误
+	if count <= threshold || (!detector.Fail && count > threshold+1) {
+		return nil
+	}
+	nPlusOneError := &NPlusOneError{SQL: sqlstr, Fingerprint: fingerprint, Caller: businessCaller(), Count: count}
+	if detector.Fail {
+		return nPlusOneError
+	}
+	if logger := DefaultLogger; logger != nil {
+		logger.Log(ctx, LogLevelWarn, "n+1 query",
+			LogField{LogFieldSQL, sqlstr},
+			LogField{LogFieldCaller, nPlusOneError.Caller},
+			LogField{LogFieldDialect, dbConnection.config.Dialect},
+			LogField{"count", count},
+		)
+		return nil
+	}
+	FuncLogError(ctx, nPlusOneError)
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package outbox 基于zorm.Transaction的事务发件箱.业务在本地事务内把事件写入发件箱表,和业务数据一起提交或者回滚
+// Relay轮询发件箱表,把事件交给Publisher发送,发送成功后标记为已发送,保证数据库和消息的最终一致,不需要seata/hptx的TC服务
+// 发件箱表的MySQL建表语句,其他数据库调整对应的类型即可:
+//
+//	CREATE TABLE zorm_outbox (
+//	  id VARCHAR(50) NOT NULL PRIMARY KEY,
+//	  topic VARCHAR(255) NOT NULL,
+//	  msg_key VARCHAR(255) NULL,
+//	  payload TEXT NULL,
+//	  status INT NOT NULL DEFAULT 0,
+//	  retry_count INT NOT NULL DEFAULT 0,
+//	  last_error VARCHAR(1000) NULL,
+//	  created_at DATETIME NOT NULL,
+//	  next_retry_at DATETIME NOT NULL,
+//	  sent_at DATETIME NULL,
+//	  INDEX idx_zorm_outbox_status (status, next_retry_at)
+//	);
+//
+// Package outbox Transactional outbox based on zorm.Transaction. Events are written into the outbox table in the local transaction,
+// committed or rolled back together with the business data. Relay polls the outbox table, hands events to the Publisher and marks them sent
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/oouxx/zorm/v2"
+)
+
+// DefaultTableName 发件箱表的默认名称
+// DefaultTableName The default name of the outbox table
+const DefaultTableName = "zorm_outbox"
+
+// 事件的发送状态
+// The send status of the event
+const (
+	// StatusPending 待发送
+	StatusPending = 0
+	// StatusSent 已发送
+	StatusSent = 1
+	// StatusFailed 超过最大重试次数,不再发送,需要人工处理
+	StatusFailed = 2
+)
+
+// Message 发件箱中的事件
+// Message The event in the outbox
+type Message struct {
+	//ID 主键,为空时使用zorm.FuncGenerateStringID生成
+	//ID Primary key, generated by zorm.FuncGenerateStringID when empty
+	ID string `column:"id"`
+	//Topic 事件的主题,例如MQ的topic
+	//Topic The topic of the event, such as the topic of MQ
+	Topic string `column:"topic"`
+	//Key 事件的业务key,例如MQ的分区key
+	//Key The business key of the event, such as the partition key of MQ
+	Key string `column:"msg_key"`
+	//Payload 事件的内容,一般是JSON
+	//Payload The content of the event, usually JSON
+	Payload string `column:"payload"`
+	//Status 发送状态
+	//Status Send status
+	Status int `column:"status"`
+	//RetryCount 发送失败的次数
+	//RetryCount The number of send failures
+	RetryCount int `column:"retry_count"`
+	//CreatedAt 创建时间,为零值时使用当前时间
+	//CreatedAt Creation time, use the current time when zero
+	CreatedAt time.Time `column:"created_at"`
+	//NextRetryAt 下次发送的时间,写入时等于CreatedAt,发送失败后按照指数退避延后
+	//NextRetryAt The time of the next send, equal to CreatedAt when written, delayed by exponential backoff after a failure
+	NextRetryAt time.Time `column:"next_retry_at"`
+}
+
+// Outbox 事务发件箱
+// Outbox Transactional outbox
+type Outbox struct {
+	tableName string
+}
+
+// New 创建发件箱,tableName为空使用DefaultTableName
+// New Create an outbox, use DefaultTableName when tableName is empty
+func New(tableName string) *Outbox {
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+	return &Outbox{tableName: tableName}
+}
+
+// TableName 发件箱表的名称
+// TableName The name of the outbox table
+func (outbox *Outbox) TableName() string {
+	return outbox.tableName
+}
+
+var errEnqueueNoTransaction = errors.New("->outbox.Enqueue-->ctx没有事务,必须在zorm.Transaction的doTransaction内调用,和业务数据在同一个本地事务")
+
+// Enqueue 把事件写入发件箱表,必须在zorm.Transaction的doTransaction内调用,和业务数据在同一个本地事务提交或者回滚
+// Enqueue Write the event into the outbox table, must be called in doTransaction of zorm.Transaction, in the same local transaction as the business data
+func (outbox *Outbox) Enqueue(ctx context.Context, message *Message) error {
+	if message == nil {
+		return errors.New("->outbox.Enqueue-->message不能为nil")
+	}
+	if message.Topic == "" {
+		return errors.New("->outbox.Enqueue-->message.Topic不能为空")
+	}
+	inTransaction, err := zorm.IsInTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	if !inTransaction {
+		return errEnqueueNoTransaction
+	}
+	if message.ID == "" {
+		message.ID = zorm.FuncGenerateStringID(ctx)
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	message.Status = StatusPending
+	message.RetryCount = 0
+	message.NextRetryAt = message.CreatedAt
+
+	entityMap := zorm.NewEntityMap(outbox.tableName)
+	entityMap.Set("id", message.ID)
+	entityMap.Set("topic", message.Topic)
+	entityMap.Set("msg_key", message.Key)
+	entityMap.Set("payload", message.Payload)
+	entityMap.Set("status", message.Status)
+	entityMap.Set("retry_count", message.RetryCount)
+	entityMap.Set("created_at", message.CreatedAt)
+	entityMap.Set("next_retry_at", message.NextRetryAt)
+	_, err = zorm.InsertEntityMap(ctx, entityMap)
+	return err
+}
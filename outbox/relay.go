@@ -0,0 +1,257 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oouxx/zorm/v2"
+)
+
+// Publisher 发送事件的接口,由业务实现,例如发送到kafka,rocketmq.返回nil表示发送成功
+// 发送成功但是标记已发送失败时,事件会再次发送,Publisher需要保证幂等,或者消费方根据Message.ID去重
+// Publisher The interface to publish events, implemented by the business, such as kafka, rocketmq. Return nil means success
+// Events may be published more than once, the Publisher or the consumer should deduplicate by Message.ID
+type Publisher interface {
+	Publish(ctx context.Context, message *Message) error
+}
+
+// RelayConfig Relay的配置
+// RelayConfig Relay configuration
+type RelayConfig struct {
+	//DBDao 发件箱表所在的数据库,为nil时使用zorm默认的数据库
+	//DBDao The database of the outbox table, use the default database of zorm when nil
+	DBDao *zorm.DBDao
+	//BatchSize 每次轮询处理的事件数量,默认100
+	//BatchSize The number of events processed per poll, default 100
+	BatchSize int
+	//PollInterval 轮询间隔,默认1秒.上一次轮询处理满BatchSize时立即再次轮询
+	//PollInterval Poll interval, default 1 second. Poll again immediately when the last poll was full
+	PollInterval time.Duration
+	//MaxRetries 最大发送失败次数,超过之后标记为StatusFailed不再发送,默认10
+	//MaxRetries The maximum number of send failures, marked as StatusFailed after that, default 10
+	MaxRetries int
+	//RetryBackoff 第一次发送失败之后的等待时间,之后每次失败翻倍,默认1秒
+	//RetryBackoff The wait after the first send failure, doubled after each failure, default 1 second
+	RetryBackoff time.Duration
+	//MaxRetryBackoff 发送失败之后最长的等待时间,默认10分钟
+	//MaxRetryBackoff The maximum wait after a send failure, default 10 minutes
+	MaxRetryBackoff time.Duration
+}
+
+// Relay 轮询发件箱表,发送待发送的事件.多个实例可以同时运行,使用 FOR UPDATE SKIP LOCKED 或者数据库对应的语法避免重复处理
+// Relay Poll the outbox table and publish pending events. Multiple instances can run at the same time
+type Relay struct {
+	outbox    *Outbox
+	config    RelayConfig
+	publisher Publisher
+	lockSQL   string
+}
+
+// NewRelay 创建发件箱的Relay
+// NewRelay Create the Relay of the outbox
+func (outbox *Outbox) NewRelay(config *RelayConfig, publisher Publisher) (*Relay, error) {
+	if config == nil {
+		return nil, errors.New("->outbox.NewRelay-->config不能为nil")
+	}
+	if publisher == nil {
+		return nil, errors.New("->outbox.NewRelay-->publisher不能为nil")
+	}
+	relayConfig := *config
+	if relayConfig.BatchSize <= 0 {
+		relayConfig.BatchSize = 100
+	}
+	if relayConfig.PollInterval <= 0 {
+		relayConfig.PollInterval = time.Second
+	}
+	if relayConfig.MaxRetries <= 0 {
+		relayConfig.MaxRetries = 10
+	}
+	if relayConfig.RetryBackoff <= 0 {
+		relayConfig.RetryBackoff = time.Second
+	}
+	if relayConfig.MaxRetryBackoff <= 0 {
+		relayConfig.MaxRetryBackoff = 10 * time.Minute
+	}
+	//使用发件箱表所在数据库的方言
+	var err error
+	dbDao := relayConfig.DBDao
+	if dbDao == nil {
+		dbDao, err = zorm.FuncReadWriteStrategy(context.Background(), 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	lockSQL, err := wrapLockPendingSQL(dbDao.Dialect(), outbox.tableName)
+	if err != nil {
+		return nil, err
+	}
+	relay := &Relay{outbox: outbox, config: relayConfig, publisher: publisher, lockSQL: lockSQL}
+	return relay, nil
+}
+
+// Run 持续轮询发件箱表,直到ctx取消.轮询的错误记录日志后继续轮询
+// Run Poll the outbox table continuously until ctx is canceled. Errors are logged and polling continues
+func (relay *Relay) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		count, err := relay.PollOnce(ctx)
+		if err != nil {
+			zorm.FuncLogError(ctx, err)
+		}
+		//处理满了一批,可能还有待发送的事件,立即再次轮询
+		if err == nil && count >= relay.config.BatchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(relay.config.PollInterval)
+		}
+	}
+}
+
+// PollOnce 查询一批待发送的事件,每个事件在独立的事务内锁定,发送并更新状态,返回处理的事件数量
+// 发送时只持有这个事件的行锁,其他Relay实例会跳过.一个事件的错误不会导致之前已经发送的事件再次发送
+// PollOnce Query a batch of pending events, each event is locked, published and updated in its own transaction, return the number of events processed
+func (relay *Relay) PollOnce(ctx context.Context) (int, error) {
+	var err error
+	if relay.config.DBDao != nil {
+		ctx, err = relay.config.DBDao.BindContextDBConnection(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	//不加锁查询到了发送时间的待发送事件ID,发送前再逐个锁定
+	now := time.Now()
+	finder := zorm.NewSelectFinder(relay.outbox.tableName, "id").Append("WHERE status=? AND next_retry_at<=? ORDER BY next_retry_at", StatusPending, now)
+	finder.SelectTotalCount = false
+	page := zorm.NewPage()
+	page.PageSize = relay.config.BatchSize
+	ids := make([]string, 0, relay.config.BatchSize)
+	err = zorm.Query(ctx, finder, &ids, page)
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range ids {
+		err = relay.publishOne(ctx, id, now)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// publishOne 在独立的事务内锁定一个待发送的事件,发送并更新状态.事件已经被其他Relay实例锁定,已经处理或者还没有到发送时间时跳过
+func (relay *Relay) publishOne(ctx context.Context, id string, now time.Time) error {
+	_, err := zorm.Transaction(ctx, func(ctx context.Context) (interface{}, error) {
+		finder := zorm.NewFinder().Append(relay.lockSQL, id, StatusPending, now)
+		message := Message{}
+		has, errQuery := zorm.QueryRow(ctx, finder, &message)
+		if errQuery != nil || !has {
+			return nil, errQuery
+		}
+		return nil, relay.publish(ctx, &message)
+	})
+	return err
+}
+
+// publish 发送一个事件,并更新发送状态
+func (relay *Relay) publish(ctx context.Context, message *Message) error {
+	errPublish := relay.safePublish(ctx, message)
+	finder := zorm.NewUpdateFinder(relay.outbox.tableName)
+	if errPublish == nil {
+		finder.Append("status=?,sent_at=? WHERE id=?", StatusSent, time.Now(), message.ID)
+	} else {
+		zorm.FuncLogError(ctx, fmt.Errorf("->outbox.Relay-->事件%s发送失败:%w", message.ID, errPublish))
+		status := StatusPending
+		if message.RetryCount+1 >= relay.config.MaxRetries {
+			status = StatusFailed
+		}
+		//last_error的长度是1000,按照字符截取,避免截断多字节字符
+		lastError := []rune(errPublish.Error())
+		if len(lastError) > 1000 {
+			lastError = lastError[:1000]
+		}
+		nextRetryAt := time.Now().Add(relay.retryBackoff(message.RetryCount))
+		finder.Append("status=?,retry_count=?,last_error=?,next_retry_at=? WHERE id=?", status, message.RetryCount+1, string(lastError), nextRetryAt, message.ID)
+	}
+	_, err := zorm.UpdateFinder(ctx, finder)
+	return err
+}
+
+// retryBackoff 第retryCount+1次发送失败之后的等待时间,RetryBackoff每次翻倍,不超过MaxRetryBackoff
+func (relay *Relay) retryBackoff(retryCount int) time.Duration {
+	backoff := relay.config.RetryBackoff
+	for i := 0; i < retryCount && backoff < relay.config.MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > relay.config.MaxRetryBackoff {
+		backoff = relay.config.MaxRetryBackoff
+	}
+	return backoff
+}
+
+// safePublish 调用Publisher发送事件,panic转换为error,避免影响其他事件的状态更新
+func (relay *Relay) safePublish(ctx context.Context, message *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("->outbox.Relay-->Publish-->recover异常:%v", r)
+			zorm.FuncLogPanic(ctx, err)
+		}
+	}()
+	return relay.publisher.Publish(ctx, message)
+}
+
+// selectColumns 查询待发送事件的列
+const selectColumns = "id,topic,msg_key,payload,status,retry_count,created_at,next_retry_at"
+
+// wrapLockPendingSQL 根据数据库方言生成锁定一个待发送事件的语句,参数是id,status和当前时间.已经被锁定或者还没有到发送时间的事件跳过,不等待
+// wrapLockPendingSQL Generate the statement that locks a pending event according to the dialect, the parameters are id, status and the current time
+func wrapLockPendingSQL(dialect string, tableName string) (string, error) {
+	var sqlBuilder strings.Builder
+	sqlBuilder.Grow(150)
+	switch dialect {
+	case "mysql", "postgresql", "kingbase", "oracle", "dm", "shentong":
+		sqlBuilder.WriteString("SELECT " + selectColumns + " FROM " + tableName)
+		sqlBuilder.WriteString(" WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE SKIP LOCKED")
+	case "mssql":
+		sqlBuilder.WriteString("SELECT " + selectColumns + " FROM " + tableName)
+		sqlBuilder.WriteString(" WITH (UPDLOCK, READPAST, ROWLOCK) WHERE id=? AND status=? AND next_retry_at<=?")
+	case "db2":
+		sqlBuilder.WriteString("SELECT " + selectColumns + " FROM " + tableName)
+		sqlBuilder.WriteString(" WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE SKIP LOCKED DATA")
+	case "sqlite": //sqlite的写事务是数据库级别的锁,不需要行锁
+		sqlBuilder.WriteString("SELECT " + selectColumns + " FROM " + tableName)
+		sqlBuilder.WriteString(" WHERE id=? AND status=? AND next_retry_at<=?")
+	case "gbase":
+		sqlBuilder.WriteString("SELECT " + selectColumns + " FROM " + tableName)
+		sqlBuilder.WriteString(" WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE")
+	default:
+		return "", errors.New("->outbox.wrapLockPendingSQL-->不支持的数据库类型:" + dialect)
+	}
+	return sqlBuilder.String(), nil
+}
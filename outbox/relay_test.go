@@ -0,0 +1,75 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWrapLockPendingSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		want    string
+		wantErr bool
+	}{
+		{"mysql", "mysql", "SELECT " + selectColumns + " FROM zorm_outbox WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE SKIP LOCKED", false},
+		{"postgresql", "postgresql", "SELECT " + selectColumns + " FROM zorm_outbox WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE SKIP LOCKED", false},
+		{"mssql", "mssql", "SELECT " + selectColumns + " FROM zorm_outbox WITH (UPDLOCK, READPAST, ROWLOCK) WHERE id=? AND status=? AND next_retry_at<=?", false},
+		{"db2", "db2", "SELECT " + selectColumns + " FROM zorm_outbox WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE SKIP LOCKED DATA", false},
+		{"sqlite", "sqlite", "SELECT " + selectColumns + " FROM zorm_outbox WHERE id=? AND status=? AND next_retry_at<=?", false},
+		{"gbase", "gbase", "SELECT " + selectColumns + " FROM zorm_outbox WHERE id=? AND status=? AND next_retry_at<=? FOR UPDATE", false},
+		{"unsupported dialect", "clickhouse", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wrapLockPendingSQL(tt.dialect, DefaultTableName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wrapLockPendingSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("wrapLockPendingSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayRetryBackoff(t *testing.T) {
+	relay := &Relay{config: RelayConfig{RetryBackoff: time.Second, MaxRetryBackoff: 10 * time.Second}}
+	tests := []struct {
+		name       string
+		retryCount int
+		want       time.Duration
+	}{
+		{"first failure", 0, time.Second},
+		{"second failure", 1, 2 * time.Second},
+		{"third failure", 2, 4 * time.Second},
+		{"fourth failure", 3, 8 * time.Second},
+		{"capped", 4, 10 * time.Second},
+		{"large retry count stays capped", 100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relay.retryBackoff(tt.retryCount); got != tt.want {
+				t.Errorf("retryBackoff(%d) = %v, want %v", tt.retryCount, got, tt.want)
+			}
+		})
+	}
+}
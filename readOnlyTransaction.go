@@ -0,0 +1,88 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrReadOnlyTransaction 只读事务中执行了更新语句
+// ErrReadOnlyTransaction An update statement was executed in a read-only transaction
+var ErrReadOnlyTransaction = errors.New("->zorm-->只读事务中不能执行Insert/Update/Delete/UpdateFinder等更新操作,请使用zorm.Transaction")
+
+// contextReadOnlyTransactionValueKey 是否只读事务放到context里使用的key
+const contextReadOnlyTransactionValueKey = wrapContextStringKey("contextReadOnlyTransactionValueKey")
+
+// BindContextReadOnlyTransaction context绑定只读事务,必须放到zorm.Transaction方法前调用
+// 开启事务时使用FuncReadWriteStrategy(ctx,0)获取读库,事务选项设置ReadOnly:true,多个查询读取一致的快照
+// doTransaction内的更新操作返回ErrReadOnlyTransaction,包括加入外层读写事务的情况
+// BindContextReadOnlyTransaction context binds read-only transaction, must be called before zorm.Transaction
+// The transaction is opened on the read database from FuncReadWriteStrategy(ctx,0) with ReadOnly:true, queries read a consistent snapshot
+// Update operations in doTransaction return ErrReadOnlyTransaction
+func BindContextReadOnlyTransaction(parent context.Context) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextReadOnlyTransaction-->context的parent不能为nil")
+	}
+	ctx := context.WithValue(parent, contextReadOnlyTransactionValueKey, true)
+	return ctx, nil
+}
+
+// ReadOnlyTransaction 只读事务,等同于BindContextReadOnlyTransaction之后调用zorm.Transaction.用于报表等需要多个查询读取一致快照的场景
+// 驱动需要支持sql.TxOptions的ReadOnly,例如mysql,postgresql
+// ReadOnlyTransaction Read-only transaction, same as zorm.Transaction after BindContextReadOnlyTransaction
+// Used for reports that need a consistent snapshot across several queries, the driver must support ReadOnly of sql.TxOptions
+func ReadOnlyTransaction(ctx context.Context, doTransaction func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	ctx, err := BindContextReadOnlyTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Transaction(ctx, doTransaction)
+}
+
+// contextReadOnlyScopeValueKey 只读事务使用的dbConnection放到context里使用的key
+const contextReadOnlyScopeValueKey = wrapContextStringKey("contextReadOnlyScopeValueKey")
+
+// bindReadOnlyScope 只读的Transaction开始执行doTransaction时,把只读标记限定到当前的dbConnection.
+// 清除ctx的只读标记,doTransaction内开启新事务的Transaction(例如PropagationRequiresNew)不再是只读事务
+func bindReadOnlyScope(ctx context.Context, dbConnection *dataBaseConnection) context.Context {
+	ctx = context.WithValue(ctx, contextReadOnlyTransactionValueKey, false)
+	return context.WithValue(ctx, contextReadOnlyScopeValueKey, dbConnection)
+}
+
+// isReadOnlyTransaction 是否是只读事务,ctx绑定了只读事务,dbConnection开启的是只读事务,或者dbConnection被只读的Transaction加入
+func isReadOnlyTransaction(ctx context.Context, dbConnection *dataBaseConnection) bool {
+	if dbConnection != nil && dbConnection.readOnly {
+		return true
+	}
+	if scope, ok := ctx.Value(contextReadOnlyScopeValueKey).(*dataBaseConnection); ok && scope == dbConnection {
+		return true
+	}
+	return getContextBoolValue(ctx, contextReadOnlyTransactionValueKey, false)
+}
+
+// readOnlyTxOptions 复制事务选项,设置ReadOnly:true,不修改DataSourceConfig.DefaultTxOptions
+func readOnlyTxOptions(txOptions *sql.TxOptions) *sql.TxOptions {
+	readOnlyOptions := &sql.TxOptions{ReadOnly: true}
+	if txOptions != nil {
+		readOnlyOptions.Isolation = txOptions.Isolation
+	}
+	return readOnlyOptions
+}
@@ -0,0 +1,77 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestIsReadOnlyTransaction(t *testing.T) {
+	readOnlyCtx, err := BindContextReadOnlyTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := &dataBaseConnection{}
+	inner := &dataBaseConnection{}
+	scopeCtx := bindReadOnlyScope(readOnlyCtx, outer)
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		dbConnection *dataBaseConnection
+		want         bool
+	}{
+		{"plain context", context.Background(), nil, false},
+		{"bound context without connection", readOnlyCtx, nil, true},
+		{"read-only connection", context.Background(), &dataBaseConnection{readOnly: true}, true},
+		{"scope on the same connection", scopeCtx, outer, true},
+		{"scope on a new connection", scopeCtx, inner, false},
+		{"scope without connection", scopeCtx, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isReadOnlyTransaction(tt.ctx, tt.dbConnection); got != tt.want {
+				t.Errorf("isReadOnlyTransaction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOnlyTxOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		txOptions *sql.TxOptions
+		want      sql.TxOptions
+	}{
+		{"nil options", nil, sql.TxOptions{ReadOnly: true}},
+		{"keep isolation", &sql.TxOptions{Isolation: sql.LevelRepeatableRead}, sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readOnlyTxOptions(tt.txOptions)
+			if *got != tt.want {
+				t.Errorf("readOnlyTxOptions() = %+v, want %+v", *got, tt.want)
+			}
+			if tt.txOptions != nil && tt.txOptions.ReadOnly {
+				t.Errorf("readOnlyTxOptions() modified the input options")
+			}
+		})
+	}
+}
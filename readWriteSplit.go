@@ -0,0 +1,353 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadBalance 从库的负载均衡算法
+// LoadBalance The load balancing algorithm of replicas
+type LoadBalance int
+
+const (
+	// LoadBalanceRoundRobin 轮询,默认值
+	// LoadBalanceRoundRobin Round robin, default
+	LoadBalanceRoundRobin LoadBalance = iota
+	// LoadBalanceWeighted 按照ReplicaConfig.Weight平滑加权轮询
+	// LoadBalanceWeighted Smooth weighted round robin by ReplicaConfig.Weight
+	LoadBalanceWeighted
+	// LoadBalanceLeastInFlight 选择正在使用的连接最少的从库
+	// LoadBalanceLeastInFlight Choose the replica with the fewest connections in use
+	LoadBalanceLeastInFlight
+)
+
+// ReplicaConfig 从库的配置
+// ReplicaConfig Replica configuration
+type ReplicaConfig struct {
+	//DataSourceConfig 从库的数据库配置
+	//DataSourceConfig The database configuration of the replica
+	DataSourceConfig *DataSourceConfig
+	//Weight LoadBalanceWeighted的权重,默认1
+	//Weight The weight of LoadBalanceWeighted, default 1
+	Weight int
+}
+
+// ReadWriteSplitConfig 读写分离的配置
+// ReadWriteSplitConfig Read write splitting configuration
+type ReadWriteSplitConfig struct {
+	//Primary 主库的数据库配置,写操作和事务使用主库
+	//Primary The database configuration of the primary, writes and transactions use the primary
+	Primary *DataSourceConfig
+	//Replicas 从库的配置,读操作在健康的从库之间负载均衡,没有健康的从库时使用主库
+	//Replicas Replica configurations, reads are balanced across healthy replicas, fall back to the primary when none is healthy
+	Replicas []ReplicaConfig
+	//LoadBalance 负载均衡算法,默认LoadBalanceRoundRobin
+	//LoadBalance Load balancing algorithm, default LoadBalanceRoundRobin
+	LoadBalance LoadBalance
+	//HealthCheckInterval 后台检查从库健康的间隔,默认5秒
+	//HealthCheckInterval The interval of background health checks, default 5 seconds
+	HealthCheckInterval time.Duration
+	//HealthCheckTimeout ping和复制延迟查询的超时时间,默认1秒
+	//HealthCheckTimeout The timeout of ping and replication lag query, default 1 second
+	HealthCheckTimeout time.Duration
+	//MaxReplicaLag 最大复制延迟,超过之后从库被剔除,默认0不检查复制延迟
+	//MaxReplicaLag The maximum replication lag, the replica is ejected after that, default 0 does not check lag
+	MaxReplicaLag time.Duration
+	//FuncReplicaLag 获取从库的复制延迟,为nil时使用内置的实现,支持mysql和postgresql
+	//FuncReplicaLag Get the replication lag of the replica, use the built-in implementation when nil, supports mysql and postgresql
+	FuncReplicaLag func(ctx context.Context, replica *DBDao) (time.Duration, error)
+}
+
+// replica 从库的状态
+type replica struct {
+	//index 在ReadWriteSplitConfig.Replicas中的位置,日志中不输出DSN,避免泄露密码
+	index  int
+	dbDao  *DBDao
+	weight int
+	//currentWeight 平滑加权轮询的当前权重
+	currentWeight int
+	//healthy 1健康,0不健康
+	healthy int32
+}
+
+// ReadWriteSplitStrategy 内置的读写分离策略,一个主库和多个从库.配置 zorm.FuncReadWriteStrategy = strategy.FuncReadWriteStrategy
+// 后台定时ping从库,剔除不健康或者复制延迟过大的从库,恢复之后重新加入.没有健康的从库时,读操作使用主库
+// ReadWriteSplitStrategy Built-in read write splitting strategy with one primary and multiple replicas. Configure zorm.FuncReadWriteStrategy = strategy.FuncReadWriteStrategy
+// Replicas are pinged in the background, unhealthy or lagging replicas are ejected and added back after recovery
+type ReadWriteSplitStrategy struct {
+	config   ReadWriteSplitConfig
+	primary  *DBDao
+	replicas []*replica
+	//counter 轮询的计数器
+	counter uint64
+	//weightMutex 平滑加权轮询需要修改currentWeight
+	weightMutex sync.Mutex
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewReadWriteSplitStrategy 创建读写分离策略,使用NewDBDao创建主库和从库,并启动后台健康检查
+// 如果之前没有创建过DBDao,主库就是zorm的defaultDao
+// NewReadWriteSplitStrategy Create the read write splitting strategy, create the primary and replicas with NewDBDao, and start background health checks
+func NewReadWriteSplitStrategy(config *ReadWriteSplitConfig) (*ReadWriteSplitStrategy, error) {
+	if config == nil {
+		return nil, errors.New("->NewReadWriteSplitStrategy-->config不能为nil")
+	}
+	if config.Primary == nil {
+		return nil, errors.New("->NewReadWriteSplitStrategy-->config.Primary不能为nil")
+	}
+	strategyConfig := *config
+	if strategyConfig.LoadBalance < LoadBalanceRoundRobin || strategyConfig.LoadBalance > LoadBalanceLeastInFlight {
+		return nil, fmt.Errorf("->NewReadWriteSplitStrategy-->不支持的负载均衡算法:%d", strategyConfig.LoadBalance)
+	}
+	if strategyConfig.HealthCheckInterval <= 0 {
+		strategyConfig.HealthCheckInterval = 5 * time.Second
+	}
+	if strategyConfig.HealthCheckTimeout <= 0 {
+		strategyConfig.HealthCheckTimeout = time.Second
+	}
+	primary, err := NewDBDao(strategyConfig.Primary)
+	if err != nil {
+		return nil, fmt.Errorf("->NewReadWriteSplitStrategy-->创建主库失败:%w", err)
+	}
+	strategy := &ReadWriteSplitStrategy{config: strategyConfig, primary: primary, stop: make(chan struct{})}
+	for i, replicaConfig := range strategyConfig.Replicas {
+		if replicaConfig.DataSourceConfig == nil {
+			strategy.closeDB()
+			return nil, fmt.Errorf("->NewReadWriteSplitStrategy-->第%d个从库的DataSourceConfig不能为nil", i)
+		}
+		replicaDBDao, err := NewDBDao(replicaConfig.DataSourceConfig)
+		if err != nil {
+			strategy.closeDB()
+			return nil, fmt.Errorf("->NewReadWriteSplitStrategy-->创建第%d个从库失败:%w", i, err)
+		}
+		weight := replicaConfig.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		strategy.replicas = append(strategy.replicas, &replica{index: i, dbDao: replicaDBDao, weight: weight, healthy: 1})
+	}
+	if len(strategy.replicas) > 0 {
+		//启动时先同步检查一次,不健康的从库不会在第一个检查周期内被使用
+		strategy.checkReplicas()
+		go strategy.healthCheckLoop()
+	}
+	return strategy, nil
+}
+
+// FuncReadWriteStrategy 读写分离的策略函数,配置 zorm.FuncReadWriteStrategy = strategy.FuncReadWriteStrategy
+// rwType=1 返回主库;rwType=0 在健康的从库之间负载均衡,没有健康的从库返回主库
+// FuncReadWriteStrategy The strategy function, rwType=1 returns the primary, rwType=0 balances across healthy replicas, falls back to the primary
+func (strategy *ReadWriteSplitStrategy) FuncReadWriteStrategy(ctx context.Context, rwType int) (*DBDao, error) {
+	if rwType != 0 {
+		return strategy.primary, nil
+	}
+	healthyReplicas := make([]*replica, 0, len(strategy.replicas))
+	for _, r := range strategy.replicas {
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			healthyReplicas = append(healthyReplicas, r)
+		}
+	}
+	if len(healthyReplicas) == 0 {
+		return strategy.primary, nil
+	}
+	switch strategy.config.LoadBalance {
+	case LoadBalanceWeighted:
+		return strategy.nextWeighted(healthyReplicas).dbDao, nil
+	case LoadBalanceLeastInFlight:
+		return leastInFlight(healthyReplicas).dbDao, nil
+	default:
+		index := atomic.AddUint64(&strategy.counter, 1) % uint64(len(healthyReplicas))
+		return healthyReplicas[index].dbDao, nil
+	}
+}
+
+// Primary 获取主库
+// Primary Get the primary
+func (strategy *ReadWriteSplitStrategy) Primary() *DBDao {
+	return strategy.primary
+}
+
+// Close 停止后台健康检查,关闭主库和从库的数据库连接
+// Close Stop background health checks, close the database connections of the primary and replicas
+func (strategy *ReadWriteSplitStrategy) Close() error {
+	strategy.stopOnce.Do(func() {
+		close(strategy.stop)
+	})
+	return strategy.closeDB()
+}
+
+// closeDB 关闭主库和从库的数据库连接,返回第一个错误
+func (strategy *ReadWriteSplitStrategy) closeDB() error {
+	err := strategy.primary.CloseDB()
+	for _, r := range strategy.replicas {
+		errClose := r.dbDao.CloseDB()
+		if errClose != nil && err == nil {
+			err = errClose
+		}
+	}
+	return err
+}
+
+// nextWeighted 平滑加权轮询,和nginx的算法一致,权重高的从库被选中的次数多,并且分布均匀
+func (strategy *ReadWriteSplitStrategy) nextWeighted(healthyReplicas []*replica) *replica {
+	strategy.weightMutex.Lock()
+	defer strategy.weightMutex.Unlock()
+	totalWeight := 0
+	var best *replica
+	for _, r := range healthyReplicas {
+		r.currentWeight += r.weight
+		totalWeight += r.weight
+		if best == nil || r.currentWeight > best.currentWeight {
+			best = r
+		}
+	}
+	best.currentWeight -= totalWeight
+	return best
+}
+
+// leastInFlight 选择正在使用的连接最少的从库
+func leastInFlight(healthyReplicas []*replica) *replica {
+	best := healthyReplicas[0]
+	bestInUse := best.inUse()
+	for _, r := range healthyReplicas[1:] {
+		inUse := r.inUse()
+		if inUse < bestInUse {
+			best = r
+			bestInUse = inUse
+		}
+	}
+	return best
+}
+
+// inUse 从库正在使用的连接数量
+func (r *replica) inUse() int {
+	_, dataSource := r.dbDao.loadDataSource()
+	return dataSource.Stats().InUse
+}
+
+// healthCheckLoop 后台定时检查从库的健康
+func (strategy *ReadWriteSplitStrategy) healthCheckLoop() {
+	ticker := time.NewTicker(strategy.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-strategy.stop:
+			return
+		case <-ticker.C:
+			strategy.checkReplicas()
+		}
+	}
+}
+
+// checkReplicas 检查所有的从库
+func (strategy *ReadWriteSplitStrategy) checkReplicas() {
+	for _, r := range strategy.replicas {
+		strategy.checkReplica(r)
+	}
+}
+
+// checkReplica 检查一个从库,ping失败或者复制延迟过大就剔除,恢复之后重新加入
+func (strategy *ReadWriteSplitStrategy) checkReplica(r *replica) {
+	ctx, cancel := context.WithTimeout(context.Background(), strategy.config.HealthCheckTimeout)
+	defer cancel()
+	_, dataSource := r.dbDao.loadDataSource()
+	err := dataSource.PingContext(ctx)
+	if err == nil && strategy.config.MaxReplicaLag > 0 {
+		var lag time.Duration
+		lag, err = strategy.replicaLag(ctx, r.dbDao)
+		if err == nil && lag > strategy.config.MaxReplicaLag {
+			err = fmt.Errorf("复制延迟%s超过%s", lag, strategy.config.MaxReplicaLag)
+		}
+	}
+	if err != nil {
+		if atomic.SwapInt32(&r.healthy, 0) == 1 {
+			FuncLogError(ctx, fmt.Errorf("->ReadWriteSplitStrategy-->第%d个从库被剔除:%w", r.index, err))
+		}
+		return
+	}
+	atomic.StoreInt32(&r.healthy, 1)
+}
+
+// replicaLag 获取从库的复制延迟
+func (strategy *ReadWriteSplitStrategy) replicaLag(ctx context.Context, replicaDBDao *DBDao) (time.Duration, error) {
+	if strategy.config.FuncReplicaLag != nil {
+		return strategy.config.FuncReplicaLag(ctx, replicaDBDao)
+	}
+	ctx, err := replicaDBDao.BindContextDBConnection(ctx)
+	if err != nil {
+		return 0, err
+	}
+	replicaConfig, _ := replicaDBDao.loadDataSource()
+	switch replicaConfig.Dialect {
+	case "mysql":
+		return mysqlReplicaLag(ctx)
+	case "postgresql":
+		//从库没有新的事务回放时,延迟也会增长,主库写入不频繁时需要设置较大的MaxReplicaLag,或者自定义FuncReplicaLag
+		finder := NewFinder().Append("SELECT COALESCE(EXTRACT(EPOCH FROM (now() - pg_last_xact_replay_timestamp())),0)")
+		var seconds float64
+		_, err = QueryRow(ctx, finder, &seconds)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return 0, errors.New("->ReadWriteSplitStrategy-->不支持获取复制延迟的数据库类型,请配置FuncReplicaLag:" + replicaConfig.Dialect)
+}
+
+// mysqlReplicaLag 获取mysql从库的复制延迟,兼容 SHOW REPLICA STATUS 和旧版本的 SHOW SLAVE STATUS
+func mysqlReplicaLag(ctx context.Context) (time.Duration, error) {
+	status, err := QueryRowMap(ctx, NewFinder().Append("SHOW REPLICA STATUS"))
+	if err != nil {
+		status, err = QueryRowMap(ctx, NewFinder().Append("SHOW SLAVE STATUS"))
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(status) == 0 {
+		return 0, errors.New("->ReadWriteSplitStrategy-->数据库不是从库")
+	}
+	for _, column := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+		value, has := status[column]
+		if !has {
+			continue
+		}
+		if value == nil {
+			//复制线程没有运行
+			return 0, errors.New("->ReadWriteSplitStrategy-->复制线程没有运行")
+		}
+		//驱动可能返回[]byte,string或者int64
+		if bytes, ok := value.([]byte); ok {
+			value = string(bytes)
+		}
+		seconds, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("->ReadWriteSplitStrategy-->复制延迟转换失败:%w", err)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("->ReadWriteSplitStrategy-->没有获取到复制延迟")
+}
@@ -0,0 +1,75 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ReadYourWritesWindow 读写分离时,写入之后读操作使用主库的时间窗口,超过之后恢复使用从库.默认0表示ctx的整个生命周期都使用主库
+// 一般设置为从库的最大复制延迟,例如ReadWriteSplitConfig.MaxReplicaLag
+// ReadYourWritesWindow The window in which reads use the primary after a write, reads go back to replicas after that. Default 0 means the whole lifetime of the ctx
+var ReadYourWritesWindow time.Duration = 0
+
+// contextReadYourWritesValueKey 读己之写状态放到context里使用的key
+const contextReadYourWritesValueKey = wrapContextStringKey("contextReadYourWritesValueKey")
+
+// readYourWritesState 读己之写的状态,ctx是不可变的,使用指针在ctx和派生的ctx之间共享
+type readYourWritesState struct {
+	//lastWrite 最后一次写入的时间,UnixNano,0表示还没有写入
+	lastWrite int64
+}
+
+// BindContextReadYourWrites context开启读己之写,一般在请求入口调用.之后这个ctx有写入或者zorm.Transaction提交成功,
+// 在ReadYourWritesWindow内Query/QueryRow/QueryMap等读操作即使rwType=0也使用主库,避免从库复制延迟导致读不到刚写入的数据
+// BindContextReadYourWrites context enables read-your-writes, usually called at the request entry. After a write or a committed zorm.Transaction on this ctx,
+// reads use the primary within ReadYourWritesWindow even if rwType=0, to avoid missing just-written data due to replica lag
+func BindContextReadYourWrites(parent context.Context) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextReadYourWrites-->context的parent不能为nil")
+	}
+	ctx := context.WithValue(parent, contextReadYourWritesValueKey, &readYourWritesState{})
+	return ctx, nil
+}
+
+// markReadYourWrites 记录ctx的写入时间,ctx没有开启读己之写时不处理
+func markReadYourWrites(ctx context.Context) {
+	state, ok := ctx.Value(contextReadYourWritesValueKey).(*readYourWritesState)
+	if !ok {
+		return
+	}
+	atomic.StoreInt64(&state.lastWrite, time.Now().UnixNano())
+}
+
+// readYourWritesPrimary ctx在时间窗口内有写入,读操作需要使用主库
+func readYourWritesPrimary(ctx context.Context) bool {
+	state, ok := ctx.Value(contextReadYourWritesValueKey).(*readYourWritesState)
+	if !ok {
+		return false
+	}
+	lastWrite := atomic.LoadInt64(&state.lastWrite)
+	if lastWrite == 0 {
+		return false
+	}
+	window := ReadYourWritesWindow
+	return window <= 0 || time.Since(time.Unix(0, lastWrite)) < window
+}
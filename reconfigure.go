@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// dataSourceDrainInterval 等待旧连接池空闲的检查间隔
+const dataSourceDrainInterval = 100 * time.Millisecond
+
+// dataSourceDrainTimeout 等待旧连接池空闲的最长时间,超过之后记录日志并强制关闭,避免泄漏的连接导致旧连接池永远不关闭
+const dataSourceDrainTimeout = 10 * time.Minute
+
+// Reconfigure 热加载数据库配置,不需要重启进程.DriverName,DSN,DSNProvider和SQLDB没有变化时,直接修改现有连接池的MaxOpenConns,MaxIdleConns和ConnMaxLifetimeSecond
+// 变化时创建新的连接池并替换,创建失败保留原来的连接池.原来的连接池上进行中的事务和查询继续执行,全部结束之后关闭原来的连接池
+// Dialect不能修改.newConfig会被设置默认值,和NewDBDao一样,调用之后不要再修改newConfig
+// Reconfigure Hot-reload the database configuration without restarting the process. If DriverName, DSN, DSNProvider and SQLDB are unchanged, the pool limits are adjusted in place
+// Otherwise a new pool is created and swapped in, the old pool is kept if it fails. In-flight transactions and queries finish on the old pool, which is closed after that
+// Dialect cannot be changed. Defaults are applied to newConfig like NewDBDao, do not modify newConfig after the call
+func (dbDao *DBDao) Reconfigure(newConfig *DataSourceConfig) error {
+	if dbDao == nil {
+		return errors.New("->Reconfigure-->请不要自己创建dbDao,使用NewDBDao方法进行创建")
+	}
+	if newConfig == nil {
+		return errors.New("->Reconfigure-->newConfig不能为nil")
+	}
+	//兼容处理,DBType即将废弃,请使用Dialect属性
+	if len(newConfig.DBType) > 0 && len(newConfig.Dialect) == 0 {
+		newConfig.Dialect = newConfig.DBType
+	}
+	dbDao.reconfigureMutex.Lock()
+	defer dbDao.reconfigureMutex.Unlock()
+	oldConfig, oldDataSource := dbDao.loadDataSource()
+	if oldDataSource == nil {
+		return errors.New("->Reconfigure-->请不要自己创建dbDao,使用NewDBDao方法进行创建")
+	}
+	if newConfig.Dialect != oldConfig.Dialect {
+		return fmt.Errorf("->Reconfigure-->不能修改Dialect,原来是%s,新的是%s", oldConfig.Dialect, newConfig.Dialect)
+	}
+	//连接没有变化,直接修改连接池的参数
+	//The connection is unchanged, adjust the pool in place
+	if newConfig.DriverName == oldConfig.DriverName && newConfig.DSN == oldConfig.DSN && newConfig.SQLDB == oldConfig.SQLDB && sameDSNProvider(newConfig.DSNProvider, oldConfig.DSNProvider) {
+		applyPoolConfig(oldDataSource.DB, newConfig)
+		dbDao.storeDataSource(newConfig, oldDataSource)
+		return nil
+	}
+	newSource, err := newDataSource(newConfig)
+	if err != nil {
+		err = fmt.Errorf("->Reconfigure-->创建dataSource失败:%w", err)
+		FuncLogError(nil, err)
+		return err
+	}
+	dbDao.storeDataSource(newConfig, newSource)
+	go closeDataSourceAfterIdle(oldDataSource)
+	return nil
+}
+
+// loadDataSource 获取当前的配置和连接池,Reconfigure会替换
+func (dbDao *DBDao) loadDataSource() (*DataSourceConfig, *dataSource) {
+	dbDao.mutex.RLock()
+	defer dbDao.mutex.RUnlock()
+	return dbDao.config, dbDao.dataSource
+}
+
+// storeDataSource 替换配置和连接池
+func (dbDao *DBDao) storeDataSource(config *DataSourceConfig, dataSource *dataSource) {
+	dbDao.mutex.Lock()
+	dbDao.config = config
+	dbDao.dataSource = dataSource
+	dbDao.mutex.Unlock()
+}
+
+// closeDataSourceAfterIdle 等待连接池上进行中的事务和查询结束,没有正在使用的连接之后关闭连接池
+// 替换之后新的dbConnection使用新的连接池,旧连接池的连接只会减少.替换之前已经绑定到ctx但是还没有执行的dbConnection,关闭之后会返回错误
+// 超过dataSourceDrainTimeout还有正在使用的连接,例如没有关闭的Rows,记录日志后强制关闭
+func closeDataSourceAfterIdle(dataSource *dataSource) {
+	ticker := time.NewTicker(dataSourceDrainInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(dataSourceDrainTimeout)
+	for inUse := dataSource.Stats().InUse; inUse > 0; inUse = dataSource.Stats().InUse {
+		if time.Now().After(deadline) {
+			FuncLogError(nil, fmt.Errorf("->closeDataSourceAfterIdle-->等待%s之后原来的连接池还有%d个正在使用的连接,强制关闭", dataSourceDrainTimeout, inUse))
+			break
+		}
+		<-ticker.C
+	}
+	err := dataSource.Close()
+	if err != nil {
+		FuncLogError(nil, fmt.Errorf("->closeDataSourceAfterIdle-->关闭原来的连接池失败:%w", err))
+	}
+}
@@ -0,0 +1,295 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// tagZormName zorm的tag名称,例如 `column:"id_card" zorm:"sensitive"`
+const tagZormName = "zorm"
+
+// tagSensitiveValue 敏感字段的tag值,日志中的参数会被脱敏
+const tagSensitiveValue = "sensitive"
+
+// SensitiveArgMask 脱敏之后日志中参数的值
+// SensitiveArgMask The value of masked arguments in logs
+var SensitiveArgMask = "******"
+
+// SensitiveColumnPatterns 敏感字段名的通配符,不区分大小写,语法同path.Match,例如 *password*.匹配字段的参数在SQL日志中脱敏.需要在init里设置
+// struct字段也可以使用 zorm:"sensitive" 的tag声明为敏感字段
+// SensitiveColumnPatterns The wildcard patterns of sensitive column names, case-insensitive, syntax is the same as path.Match, for example *password*. Arguments of matched columns are masked in SQL logs. Set it in init
+// Struct fields can also be declared sensitive with the zorm:"sensitive" tag
+var SensitiveColumnPatterns = []string{"*password*", "*passwd*", "*secret*", "*token*"}
+
+// contextSensitiveArgsValueKey 根据struct的tag计算的敏感参数位置,放到context里使用的key
+const contextSensitiveArgsValueKey = wrapContextStringKey("contextSensitiveArgsValueKey")
+
+// sensitiveColumnTagMap 缓存struct信息或者RegisterSensitiveEntity时,有 zorm:"sensitive" tag的字段名,key是小写的字段名.
+// Finder和EntityMap的SQL日志也使用,所以不区分表,其他表的同名字段也会脱敏
+var sensitiveColumnTagMap sync.Map
+
+// hasSensitiveTag struct字段是否有 zorm:"sensitive" 的tag
+func hasSensitiveTag(field *reflect.StructField) bool {
+	for _, value := range strings.Split(field.Tag.Get(tagZormName), ",") {
+		if strings.TrimSpace(value) == tagSensitiveValue {
+			return true
+		}
+	}
+	return false
+}
+
+// registerSensitiveColumn 缓存struct信息时调用,记录有 zorm:"sensitive" tag的字段名
+func registerSensitiveColumn(field *reflect.StructField) {
+	column := field.Tag.Get(tagColumnName)
+	if column == "" || !hasSensitiveTag(field) {
+		return
+	}
+	sensitiveColumnTagMap.Store(strings.ToLower(column), true)
+}
+
+// RegisterSensitiveEntity 解析struct并记录有 zorm:"sensitive" tag的字段名,entity是*struct类型.需要在init里调用.
+// struct第一次被zorm使用时也会记录,在这之前Finder,UpdateFinder和EntityMap的SQL日志需要先注册,才能按照字段名脱敏
+// RegisterSensitiveEntity Parse the struct and record the column names with the zorm:"sensitive" tag, entity is a *struct. Call it in init.
+// The struct is also recorded when zorm uses it for the first time, register it before that so the SQL logs of Finder, UpdateFinder and EntityMap are masked by column name
+func RegisterSensitiveEntity(entities ...interface{}) error {
+	for _, entity := range entities {
+		typeOf, err := checkEntityKind(entity)
+		if err != nil {
+			return fmt.Errorf("->RegisterSensitiveEntity-->checkEntityKind获取类型错误:%w", err)
+		}
+		if typeOf.Kind() != reflect.Struct {
+			return errors.New("->RegisterSensitiveEntity-->entity必须是*struct类型")
+		}
+		err = structFieldInfo(&typeOf)
+		if err != nil {
+			return fmt.Errorf("->RegisterSensitiveEntity-->structFieldInfo解析struct错误:%w", err)
+		}
+	}
+	return nil
+}
+
+// isSensitiveField struct字段是否有 zorm:"sensitive" 的tag,或者字段名匹配SensitiveColumnPatterns
+func isSensitiveField(field *reflect.StructField) bool {
+	if hasSensitiveTag(field) {
+		return true
+	}
+	return isSensitiveColumn(field.Tag.Get(tagColumnName))
+}
+
+// isSensitiveColumn 字段名是否有 zorm:"sensitive" 的tag,或者匹配SensitiveColumnPatterns
+func isSensitiveColumn(column string) bool {
+	if column == "" {
+		return false
+	}
+	column = strings.ToLower(column)
+	if _, has := sensitiveColumnTagMap.Load(column); has {
+		return true
+	}
+	for _, pattern := range SensitiveColumnPatterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), column); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveArgsFromColumns 根据columnAndValue的字段计算敏感参数的位置,没有敏感字段返回nil
+// rows是批量保存的对象数量,参数按照字段循环rows次,多出的参数(例如更新的主键,oracle的RETURNING)由SQL解析处理
+func sensitiveArgsFromColumns(columns []reflect.StructField, valuesLen int, rows int) []bool {
+	columnLen := len(columns)
+	if columnLen < 1 {
+		return nil
+	}
+	var sensitiveColumns []bool
+	for i := range columns {
+		if isSensitiveField(&columns[i]) {
+			if sensitiveColumns == nil {
+				sensitiveColumns = make([]bool, columnLen)
+			}
+			sensitiveColumns[i] = true
+		}
+	}
+	if sensitiveColumns == nil {
+		return nil
+	}
+	sensitiveArgs := make([]bool, valuesLen)
+	for i := 0; i < valuesLen && i < columnLen*rows; i++ {
+		sensitiveArgs[i] = sensitiveColumns[i%columnLen]
+	}
+	return sensitiveArgs
+}
+
+// bindContextSensitiveArgs 把敏感参数的位置放到ctx,用于SQL日志脱敏
+func bindContextSensitiveArgs(ctx context.Context, sensitiveArgs []bool) context.Context {
+	if ctx == nil || sensitiveArgs == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextSensitiveArgsValueKey, sensitiveArgs)
+}
+
+// redactArgs 返回脱敏之后的参数,没有需要脱敏的参数时返回原参数.
+// 先使用ctx中struct的tag计算的位置,再解析SQL获取参数对应的字段名,匹配SensitiveColumnPatterns
+func redactArgs(ctx context.Context, sqlstr string, args []interface{}) []interface{} {
+	if len(args) < 1 {
+		return args
+	}
+	var sensitiveArgs []bool
+	if ctx != nil {
+		sensitiveArgs, _ = ctx.Value(contextSensitiveArgsValueKey).([]bool)
+	}
+	var columns []string
+	if len(SensitiveColumnPatterns) > 0 {
+		columns = parseSQLArgColumns(sqlstr, len(args))
+	}
+	var redacted []interface{}
+	for i := range args {
+		sensitive := i < len(sensitiveArgs) && sensitiveArgs[i]
+		if !sensitive && i < len(columns) {
+			sensitive = isSensitiveColumn(columns[i])
+		}
+		if !sensitive {
+			continue
+		}
+		if redacted == nil {
+			redacted = make([]interface{}, len(args))
+			copy(redacted, args)
+		}
+		redacted[i] = SensitiveArgMask
+	}
+	if redacted == nil {
+		return args
+	}
+	return redacted
+}
+
+// sqlInsertColumnsRegexp INSERT语句的字段列表和VALUES的位置
+var sqlInsertColumnsRegexp = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+\S+\s*\(([^()]*)\)\s*VALUES\s*`)
+
+// sqlArgColumnRegexp 参数前面的 字段 操作符,例如 name=, age >=, name LIKE, id IN (?,, birthday BETWEEN ? AND
+// 字段和参数可以使用函数包装,例如 lower(name)=lower(?), password=md5(concat(?,
+var sqlArgColumnRegexp = regexp.MustCompile(`(?is)([\w.` + "`" + `"\[\]]+)\s*\)?\s*(?:=|<>|!=|>=|<=|>|<|\bNOT\s+LIKE|\bLIKE|\bNOT\s+IN\s*\([^()]*|\bIN\s*\([^()]*|\bBETWEEN\s+\S+\s+AND|\bBETWEEN)\s*(?:\w+\s*\(\s*(?:[^()]*,\s*)?)*$`)
+
+// sqlArgColumnLookBehind 查找参数对应字段时,向前查找的最大长度.IN的参数列表可能很长
+const sqlArgColumnLookBehind = 256
+
+// parseSQLArgColumns 轻量解析SQL,获取每个参数对应的字段名,解析不到为空字符串.
+// 支持reBindSQL之后的 ?, $1, :1, @p1 占位符,INSERT的字段列表,以及SET和WHERE中的 字段 操作符 参数.尽力而为
+func parseSQLArgColumns(sqlstr string, argsLen int) []string {
+	columns := make([]string, argsLen)
+	var insertColumns []string
+	valuesIndex := -1
+	if loc := sqlInsertColumnsRegexp.FindStringSubmatchIndex(sqlstr); loc != nil {
+		insertColumns = strings.Split(sqlstr[loc[2]:loc[3]], ",")
+		valuesIndex = loc[1]
+	}
+	//参数的序号
+	argIndex := 0
+	//INSERT语句VALUES中参数的序号
+	valueIndex := 0
+	inQuote := false
+	for i := 0; i < len(sqlstr); i++ {
+		c := sqlstr[i]
+		if c == '\'' {
+			inQuote = !inQuote
+			continue
+		}
+		if inQuote {
+			continue
+		}
+		index := -1
+		end := i
+		switch {
+		case c == '?':
+			index = argIndex
+		case (c == '$' || c == ':' || c == '@') && i+1 < len(sqlstr):
+			start := i + 1
+			//sqlserver的 @p1
+			if c == '@' && (sqlstr[start] == 'p' || sqlstr[start] == 'P') {
+				start++
+			}
+			end = start
+			for end < len(sqlstr) && sqlstr[end] >= '0' && sqlstr[end] <= '9' {
+				end++
+			}
+			if end == start {
+				continue
+			}
+			//postgresql的 ::类型转换
+			if c == ':' && i > 0 && sqlstr[i-1] == ':' {
+				continue
+			}
+			number, err := strconv.Atoi(sqlstr[start:end])
+			if err != nil {
+				continue
+			}
+			//oracle的 :1 按照出现的顺序绑定
+			if c == ':' {
+				index = argIndex
+			} else {
+				index = number - 1
+			}
+			end--
+		default:
+			continue
+		}
+		argIndex++
+		if index < 0 || index >= argsLen {
+			i = end
+			continue
+		}
+		if valuesIndex >= 0 && i >= valuesIndex && len(insertColumns) > 0 {
+			columns[index] = normalizeSQLColumn(insertColumns[valueIndex%len(insertColumns)])
+			valueIndex++
+		} else {
+			columns[index] = lookBehindSQLColumn(sqlstr[:i])
+		}
+		i = end
+	}
+	return columns
+}
+
+// lookBehindSQLColumn 查找参数前面的字段名
+func lookBehindSQLColumn(before string) string {
+	if len(before) > sqlArgColumnLookBehind {
+		before = before[len(before)-sqlArgColumnLookBehind:]
+	}
+	match := sqlArgColumnRegexp.FindStringSubmatch(before)
+	if match == nil {
+		return ""
+	}
+	return normalizeSQLColumn(match[1])
+}
+
+// normalizeSQLColumn 去掉字段名的引号和表别名
+func normalizeSQLColumn(column string) string {
+	column = sqlIdentifierQuoteReplacer.Replace(strings.TrimSpace(column))
+	if index := strings.LastIndexByte(column, '.'); index >= 0 {
+		column = column[index+1:]
+	}
+	return column
+}
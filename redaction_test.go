@@ -0,0 +1,125 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseSQLArgColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		sqlstr  string
+		argsLen int
+		want    []string
+	}{
+		{"insert", "INSERT INTO t_user (id,`name`,password) VALUES (?,?,?)", 3, []string{"id", "name", "password"}},
+		{"batch insert", "INSERT INTO t_user (id,password) VALUES (?,?),(?,?)", 4, []string{"id", "password", "id", "password"}},
+		{"update set and where", "UPDATE t_user SET name=?,password = ? WHERE id=?", 3, []string{"name", "password", "id"}},
+		{"table alias and quotes", `SELECT * FROM t_user u WHERE u."password"=? AND u.age>=?`, 2, []string{"password", "age"}},
+		{"like and not in", "SELECT * FROM t WHERE name LIKE ? AND id NOT IN (?,?)", 3, []string{"name", "id", "id"}},
+		{"between", "SELECT * FROM t WHERE age BETWEEN ? AND ?", 2, []string{"age", "age"}},
+		{"function wrapped", "SELECT * FROM t WHERE lower(email)=lower(?) AND password=md5(concat(?,salt))", 2, []string{"email", "password"}},
+		{"postgresql placeholders", "UPDATE t SET token=$2 WHERE id=$1", 2, []string{"id", "token"}},
+		{"postgresql type cast", "SELECT * FROM t WHERE id=$1::int AND token=$2", 2, []string{"id", "token"}},
+		{"oracle placeholders", "UPDATE t SET secret=:1 WHERE id=:2", 2, []string{"secret", "id"}},
+		{"sqlserver placeholders", "UPDATE t SET passwd=@p1 WHERE id=@p2", 2, []string{"passwd", "id"}},
+		{"placeholder in string literal", "SELECT * FROM t WHERE note='?' AND id=?", 1, []string{"id"}},
+		{"unknown column", "SELECT ? FROM t", 1, []string{""}},
+		{"more placeholders than args", "SELECT * FROM t WHERE a=? AND b=?", 1, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSQLArgColumns(tt.sqlstr, tt.argsLen); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSQLArgColumns(%q) = %q, want %q", tt.sqlstr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		name          string
+		sqlstr        string
+		sensitiveArgs []bool
+		args          []interface{}
+		want          []interface{}
+	}{
+		{"no sensitive column", "UPDATE t SET name=? WHERE id=?", nil, []interface{}{"zorm", 1}, []interface{}{"zorm", 1}},
+		{"column pattern", "UPDATE t SET password=? WHERE id=?", nil, []interface{}{"123456", 1}, []interface{}{SensitiveArgMask, 1}},
+		{"struct tag position", "UPDATE t SET id_card=? WHERE id=?", []bool{true, false}, []interface{}{"110", 1}, []interface{}{SensitiveArgMask, 1}},
+		{"no args", "SELECT 1", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := bindContextSensitiveArgs(context.Background(), tt.sensitiveArgs)
+			args := append([]interface{}(nil), tt.args...)
+			got := redactArgs(ctx, tt.sqlstr, args)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactArgs() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("redactArgs() modified the args: %v", args)
+			}
+		})
+	}
+}
+
+// testSensitiveEntity RegisterSensitiveEntity测试使用的struct
+type testSensitiveEntity struct {
+	EntityStruct
+	ID          string `column:"id"`
+	BankAccount string `column:"test_bank_account" zorm:"sensitive"`
+}
+
+func (entity *testSensitiveEntity) GetTableName() string {
+	return "t_test_sensitive"
+}
+
+func (entity *testSensitiveEntity) GetPKColumnName() string {
+	return "id"
+}
+
+func TestRegisterSensitiveEntity(t *testing.T) {
+	tests := []struct {
+		name    string
+		entity  interface{}
+		wantErr bool
+	}{
+		{"nil", nil, true},
+		{"not pointer", testSensitiveEntity{}, true},
+		{"pointer to base type", new(string), true},
+		{"pointer to struct", &testSensitiveEntity{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterSensitiveEntity(tt.entity); (err != nil) != tt.wantErr {
+				t.Errorf("RegisterSensitiveEntity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if !isSensitiveColumn("TEST_BANK_ACCOUNT") {
+		t.Errorf("isSensitiveColumn(%q) = false after RegisterSensitiveEntity, want true", "TEST_BANK_ACCOUNT")
+	}
+	if isSensitiveColumn("id") {
+		t.Errorf("isSensitiveColumn(%q) = true, want false", "id")
+	}
+}
@@ -0,0 +1,446 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package saga 进程内的TCC/Saga补偿事务,实现zorm.IGlobalTransaction,不需要seata/hptx的TC服务
+// 业务执行每一步(例如调用HTTP接口,写本地数据库)之后,使用AddStep注册这一步的确认和取消动作
+// 分布式事务提交时按照顺序执行确认动作,回滚时按照相反的顺序执行取消动作.分布式事务和步骤的状态保存在数据库表中,重启之后调用Resume继续执行
+// 确认和取消动作必须幂等,取消动作需要允许对应的操作没有执行成功(空回滚)
+// 提交的决定(StatusConfirming)在开启方的本地事务提交前,使用同一个事务保存,所以状态表需要和开启方的业务数据在同一个数据库
+// 状态表的MySQL建表语句,其他数据库调整对应的类型即可:
+//
+//	CREATE TABLE zorm_saga (
+//	  xid VARCHAR(64) NOT NULL PRIMARY KEY,
+//	  status INT NOT NULL DEFAULT 0,
+//	  created_at DATETIME NOT NULL,
+//	  updated_at DATETIME NOT NULL,
+//	  INDEX idx_zorm_saga_status (status, updated_at)
+//	);
+//	CREATE TABLE zorm_saga_step (
+//	  id VARCHAR(50) NOT NULL PRIMARY KEY,
+//	  xid VARCHAR(64) NOT NULL,
+//	  step_no INT NOT NULL,
+//	  action VARCHAR(255) NOT NULL,
+//	  payload TEXT NULL,
+//	  status INT NOT NULL DEFAULT 0,
+//	  updated_at DATETIME NOT NULL,
+//	  INDEX idx_zorm_saga_step_xid (xid, step_no)
+//	);
+//
+// 使用示例:
+//
+//	coordinator, err := saga.NewCoordinator(&saga.Config{})
+//	//注册动作,重启之后根据名称找到动作继续执行
+//	coordinator.RegisterAction("createPayment", saga.Action{Confirm: confirmPayment, Cancel: cancelPayment})
+//	//配置 DataSourceConfig.FuncGlobalTransaction = coordinator.FuncGlobalTransaction
+//	//启动时继续执行上次没有完成的分布式事务
+//	err = coordinator.Resume(context.Background())
+//
+//	ctx, _ = zorm.BindContextEnableGlobalTransaction(ctx)
+//	_, err = zorm.Transaction(ctx, func(ctx context.Context) (interface{}, error) {
+//		paymentID, err := callPaymentService(ctx)
+//		if err != nil {
+//			return nil, err
+//		}
+//		err = coordinator.AddStep(ctx, "createPayment", paymentID)
+//		if err != nil {
+//			return nil, err
+//		}
+//		//本地数据库的写操作,返回error会回滚本地事务,并执行cancelPayment
+//		return zorm.Insert(ctx, &order)
+//	})
+//
+// Package saga In-process TCC/Saga compensating transaction, implements zorm.IGlobalTransaction without the TC server of seata/hptx
+// After each step (such as an HTTP call or a local DB write), register its confirm and cancel actions with AddStep.
+// On commit the confirm actions run in order, on rollback the cancel actions run in reverse order. The state is persisted in tables,
+// call Resume after a restart to continue. Actions must be idempotent, and cancel must tolerate a step that never took effect
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/oouxx/zorm/v2"
+)
+
+// DefaultTableName 分布式事务状态表的默认名称
+// DefaultTableName The default name of the global transaction table
+const DefaultTableName = "zorm_saga"
+
+// DefaultStepTableName 步骤状态表的默认名称
+// DefaultStepTableName The default name of the step table
+const DefaultStepTableName = "zorm_saga_step"
+
+// DefaultXIDPrefix XID的默认前缀
+// DefaultXIDPrefix The default prefix of XID
+const DefaultXIDPrefix = "zormsaga"
+
+// 分布式事务的状态
+// The status of the global transaction
+const (
+	// StatusActive 进行中
+	StatusActive = 0
+	// StatusConfirming 正在执行确认动作
+	StatusConfirming = 1
+	// StatusCommitted 已经提交,所有确认动作执行成功
+	StatusCommitted = 2
+	// StatusCancelling 正在执行取消动作
+	StatusCancelling = 3
+	// StatusRolledBack 已经回滚,所有取消动作执行成功
+	StatusRolledBack = 4
+)
+
+// 步骤的状态
+// The status of the step
+const (
+	// StepTried 业务已经执行,等待确认或者取消
+	StepTried = 0
+	// StepConfirmed 确认动作执行成功
+	StepConfirmed = 1
+	// StepCancelled 取消动作执行成功
+	StepCancelled = 2
+)
+
+// Action 步骤的确认和取消动作,payload是AddStep时传入的业务数据,例如业务单号.
+// Confirm为nil表示提交时不需要确认(Saga),Cancel为nil表示回滚时不需要补偿
+// Action The confirm and cancel actions of a step, payload is the business data passed to AddStep.
+// nil Confirm means nothing to confirm on commit (Saga), nil Cancel means nothing to compensate on rollback
+type Action struct {
+	Confirm func(ctx context.Context, xid string, payload string) error
+	Cancel  func(ctx context.Context, xid string, payload string) error
+}
+
+// Config Coordinator的配置
+// Config Coordinator configuration
+type Config struct {
+	//DBDao 状态表所在的数据库,为nil时使用zorm默认的数据库.需要和开启分布式事务的业务数据是同一个数据库
+	//DBDao The database of the state tables, use the default database of zorm when nil. It must be the database of the business data that opens the global transaction
+	DBDao *zorm.DBDao
+	//TableName 分布式事务状态表,默认zorm_saga
+	//TableName The global transaction table, default zorm_saga
+	TableName string
+	//StepTableName 步骤状态表,默认zorm_saga_step
+	//StepTableName The step table, default zorm_saga_step
+	StepTableName string
+	//XIDPrefix XID的前缀,默认zormsaga
+	//XIDPrefix The prefix of XID, default zormsaga
+	XIDPrefix string
+	//ResumeAfter Resume只处理超过这个时间没有更新的分布式事务,避免处理其他实例进行中的事务,默认1分钟
+	//ResumeAfter Resume only handles global transactions not updated for this duration, default 1 minute
+	ResumeAfter time.Duration
+}
+
+// Coordinator TCC/Saga分布式事务的协调者,一个进程创建一个
+// Coordinator The coordinator of TCC/Saga global transactions, create one per process
+type Coordinator struct {
+	config       Config
+	mutex        sync.RWMutex
+	actions      map[string]Action
+	transactions map[string]*globalTransaction
+}
+
+// NewCoordinator 创建TCC/Saga分布式事务的协调者
+// NewCoordinator Create the coordinator of TCC/Saga global transactions
+func NewCoordinator(config *Config) (*Coordinator, error) {
+	if config == nil {
+		return nil, errors.New("->saga.NewCoordinator-->config不能为nil")
+	}
+	coordinatorConfig := *config
+	if coordinatorConfig.TableName == "" {
+		coordinatorConfig.TableName = DefaultTableName
+	}
+	if coordinatorConfig.StepTableName == "" {
+		coordinatorConfig.StepTableName = DefaultStepTableName
+	}
+	if coordinatorConfig.XIDPrefix == "" {
+		coordinatorConfig.XIDPrefix = DefaultXIDPrefix
+	}
+	if coordinatorConfig.ResumeAfter <= 0 {
+		coordinatorConfig.ResumeAfter = time.Minute
+	}
+	coordinator := &Coordinator{
+		config:       coordinatorConfig,
+		actions:      make(map[string]Action),
+		transactions: make(map[string]*globalTransaction),
+	}
+	return coordinator, nil
+}
+
+// RegisterAction 注册动作,AddStep使用名称引用动作,重启之后Resume根据名称找到动作继续执行.应用启动时注册所有的动作
+// RegisterAction Register an action, AddStep references it by name, Resume finds it by name after a restart
+func (coordinator *Coordinator) RegisterAction(name string, action Action) error {
+	if name == "" {
+		return errors.New("->saga.RegisterAction-->name不能为空")
+	}
+	if action.Confirm == nil && action.Cancel == nil {
+		return errors.New("->saga.RegisterAction-->Confirm和Cancel不能都为nil")
+	}
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+	if _, has := coordinator.actions[name]; has {
+		return errors.New("->saga.RegisterAction-->重复注册的动作:" + name)
+	}
+	coordinator.actions[name] = action
+	return nil
+}
+
+// getAction 获取注册的动作
+func (coordinator *Coordinator) getAction(name string) (Action, bool) {
+	coordinator.mutex.RLock()
+	defer coordinator.mutex.RUnlock()
+	action, has := coordinator.actions[name]
+	return action, has
+}
+
+// FuncGlobalTransaction 配置到zorm.DataSourceConfig.FuncGlobalTransaction.ctx中有XID时,返回进行中的分布式事务,没有就创建新的分布式事务
+// FuncGlobalTransaction Configure to zorm.DataSourceConfig.FuncGlobalTransaction. Return the global transaction in progress when ctx has XID, otherwise create a new one
+func (coordinator *Coordinator) FuncGlobalTransaction(ctx context.Context) (zorm.IGlobalTransaction, context.Context, error) {
+	xid, _ := ctx.Value("TX_XID").(string)
+	if xid == "" {
+		return &globalTransaction{coordinator: coordinator}, ctx, nil
+	}
+	gtx := coordinator.getTransaction(xid)
+	if gtx == nil {
+		return nil, ctx, errors.New("->saga.FuncGlobalTransaction-->XID不存在或者已经结束:" + xid)
+	}
+	return gtx, ctx, nil
+}
+
+// AddStep 注册分布式事务的一个步骤,必须在开启了分布式事务的zorm.Transaction的doTransaction内调用.
+// 业务操作执行之后再注册,步骤的状态立即保存到数据库,不受本地事务回滚的影响
+// AddStep Register a step of the global transaction, must be called in doTransaction of zorm.Transaction with global transaction enabled.
+// The step is persisted immediately and is not affected by the rollback of the local transaction
+func (coordinator *Coordinator) AddStep(ctx context.Context, actionName string, payload string) error {
+	if _, has := coordinator.getAction(actionName); !has {
+		return errors.New("->saga.AddStep-->动作没有注册:" + actionName)
+	}
+	xid, _ := ctx.Value("XID").(string)
+	if xid == "" {
+		return errors.New("->saga.AddStep-->ctx中没有XID,请在开启了分布式事务的zorm.Transaction内调用")
+	}
+	gtx := coordinator.getTransaction(xid)
+	if gtx == nil {
+		return errors.New("->saga.AddStep-->XID不存在或者已经结束:" + xid)
+	}
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	if gtx.status != StatusActive {
+		return errors.New("->saga.AddStep-->分布式事务已经结束:" + xid)
+	}
+	s := &step{
+		ID:      zorm.FuncGenerateStringID(ctx),
+		XID:     xid,
+		StepNo:  len(gtx.steps) + 1,
+		Action:  actionName,
+		Payload: payload,
+		Status:  StepTried,
+	}
+	err := coordinator.insertStep(s)
+	if err != nil {
+		return err
+	}
+	gtx.steps = append(gtx.steps, s)
+	//第一个步骤注册之后,在开启方的本地事务提交前保存StatusConfirming,提交的决定和业务数据一起提交
+	//After the first step, persist StatusConfirming before the local transaction of the opener commits, so the decision commits with the business data
+	if !gtx.confirmHook && gtx.ctx != nil {
+		gtx.confirmHook = zorm.BeforeCommit(gtx.ctx, gtx.persistConfirming) == nil
+	}
+	return nil
+}
+
+// getTransaction 获取进行中的分布式事务
+func (coordinator *Coordinator) getTransaction(xid string) *globalTransaction {
+	coordinator.mutex.RLock()
+	defer coordinator.mutex.RUnlock()
+	return coordinator.transactions[xid]
+}
+
+// removeTransaction 分布式事务结束,从进行中的事务中移除
+func (coordinator *Coordinator) removeTransaction(xid string) {
+	coordinator.mutex.Lock()
+	delete(coordinator.transactions, xid)
+	coordinator.mutex.Unlock()
+}
+
+// globalTransaction TCC/Saga分布式事务,实现zorm.IGlobalTransaction
+type globalTransaction struct {
+	coordinator *Coordinator
+	mutex       sync.Mutex
+	xid         string
+	status      int
+	steps       []*step
+	//ctx 开启方的ctx,用于在开启方的本地事务中注册BeforeCommit
+	ctx context.Context
+	//confirmHook 是否已经注册了保存StatusConfirming的BeforeCommit
+	confirmHook bool
+	//confirmInTx StatusConfirming已经在开启方的本地事务中保存,本地事务提交之后就是已经决定提交
+	confirmInTx bool
+}
+
+// BeginGTX 开启分布式事务,生成XID并保存到数据库
+func (gtx *globalTransaction) BeginGTX(ctx context.Context, globalRootContext context.Context) error {
+	coordinator := gtx.coordinator
+	xid := coordinator.config.XIDPrefix + zorm.FuncGenerateStringID(ctx)
+	err := coordinator.insertTransaction(xid)
+	if err != nil {
+		return err
+	}
+	gtx.xid = xid
+	gtx.status = StatusActive
+	gtx.ctx = ctx
+	coordinator.mutex.Lock()
+	coordinator.transactions[xid] = gtx
+	coordinator.mutex.Unlock()
+	return nil
+}
+
+// persistConfirming 开启方本地事务的BeforeCommit,在同一个事务中把状态从StatusActive更新为StatusConfirming.
+// 状态已经被其他实例修改时返回错误,业务的本地事务回滚.状态表需要和开启方的业务数据在同一个数据库
+func (gtx *globalTransaction) persistConfirming(ctx context.Context) error {
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	if gtx.status != StatusActive {
+		return errors.New("->saga.persistConfirming-->分布式事务已经结束:" + gtx.xid)
+	}
+	finder := zorm.NewUpdateFinder(gtx.coordinator.config.TableName).Append("status=?,updated_at=? WHERE xid=? AND status=?", StatusConfirming, time.Now(), gtx.xid, StatusActive)
+	affected, err := zorm.UpdateFinder(ctx, finder)
+	if err != nil {
+		return err
+	}
+	if affected < 1 {
+		return fmt.Errorf("->saga.persistConfirming-->分布式事务%s的状态已经不是%d,可能已经被其他实例处理", gtx.xid, StatusActive)
+	}
+	gtx.confirmInTx = true
+	return nil
+}
+
+// CommitGTX 提交分布式事务,按照顺序执行确认动作.执行失败返回zorm.GlobalCommitIncompleteError,状态保持StatusConfirming,由Resume继续确认
+func (gtx *globalTransaction) CommitGTX(ctx context.Context, globalRootContext context.Context) error {
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	switch gtx.status {
+	case StatusCommitted, StatusConfirming:
+		return nil
+	case StatusCancelling, StatusRolledBack:
+		return errors.New("->saga.CommitGTX-->分布式事务已经回滚,无法提交:" + gtx.xid)
+	}
+	//StatusConfirming没有和业务数据一起提交,例如没有步骤,单独更新状态
+	if !gtx.confirmInTx {
+		err := gtx.coordinator.updateTransactionStatus(gtx.xid, StatusActive, StatusConfirming)
+		if err != nil {
+			return err
+		}
+	}
+	gtx.status = StatusConfirming
+	defer gtx.coordinator.removeTransaction(gtx.xid)
+	err := gtx.coordinator.complete(gtx.xid, StatusConfirming, gtx.steps, true)
+	if err != nil {
+		//已经决定提交,由Resume继续确认
+		return &zorm.GlobalCommitIncompleteError{Err: err}
+	}
+	return nil
+}
+
+// RollbackGTX 回滚分布式事务,按照相反的顺序执行取消动作,可以重复调用.执行失败返回错误,状态保持StatusCancelling,由Resume继续取消
+func (gtx *globalTransaction) RollbackGTX(ctx context.Context, globalRootContext context.Context) error {
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	switch gtx.status {
+	case StatusRolledBack, StatusCancelling:
+		return nil
+	case StatusCommitted, StatusConfirming:
+		return errors.New("->saga.RollbackGTX-->分布式事务已经提交,无法回滚:" + gtx.xid)
+	}
+	gtx.status = StatusCancelling
+	//本地事务已经回滚,BeforeCommit保存的StatusConfirming也随之回滚
+	gtx.confirmInTx = false
+	defer gtx.coordinator.removeTransaction(gtx.xid)
+	return gtx.coordinator.complete(gtx.xid, StatusActive, gtx.steps, false)
+}
+
+// GetGTXID 获取分布式事务的XID
+func (gtx *globalTransaction) GetGTXID(ctx context.Context, globalRootContext context.Context) (string, error) {
+	if gtx.xid == "" {
+		return "", errors.New("->saga.GetGTXID-->分布式事务没有开启")
+	}
+	return gtx.xid, nil
+}
+
+// complete 结束分布式事务.confirm为true按照顺序执行确认动作,为false按照相反的顺序执行取消动作
+// 动作执行成功就更新步骤的状态,全部成功之后更新分布式事务的状态.遇到错误就停止,保留状态,由Resume继续执行
+// 动作使用新的context执行,不包含业务的事务和XID,XID通过参数传递
+// fromStatus是数据库中当前的状态,状态使用条件更新,其他实例已经修改了状态时,不执行任何动作,返回错误
+func (coordinator *Coordinator) complete(xid string, fromStatus int, steps []*step, confirm bool) error {
+	status, finalStatus, stepStatus := StatusConfirming, StatusCommitted, StepConfirmed
+	if !confirm {
+		status, finalStatus, stepStatus = StatusCancelling, StatusRolledBack, StepCancelled
+	}
+	//Resume继续执行时状态没有变化,claimTransaction已经抢占成功
+	if fromStatus != status {
+		err := coordinator.updateTransactionStatus(xid, fromStatus, status)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	ctx := context.Background()
+	for i := range steps {
+		s := steps[i]
+		if !confirm {
+			s = steps[len(steps)-1-i]
+		}
+		if s.Status != StepTried {
+			continue
+		}
+		action, has := coordinator.getAction(s.Action)
+		if !has {
+			return fmt.Errorf("->saga.complete-->分布式事务%s的动作%s没有注册", xid, s.Action)
+		}
+		actionFunc := action.Confirm
+		if !confirm {
+			actionFunc = action.Cancel
+		}
+		if actionFunc != nil {
+			err = safeRunAction(ctx, actionFunc, xid, s.Payload)
+			if err != nil {
+				err = fmt.Errorf("->saga.complete-->分布式事务%s的步骤%d动作%s执行失败:%w", xid, s.StepNo, s.Action, err)
+				zorm.FuncLogError(ctx, err)
+				return err
+			}
+		}
+		err = coordinator.updateStepStatus(s.ID, stepStatus)
+		if err != nil {
+			return err
+		}
+		s.Status = stepStatus
+	}
+	return coordinator.updateTransactionStatus(xid, status, finalStatus)
+}
+
+// safeRunAction 执行动作,panic转换为error
+func safeRunAction(ctx context.Context, actionFunc func(ctx context.Context, xid string, payload string) error, xid string, payload string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("->saga.Action-->recover异常:%v", r)
+			zorm.FuncLogPanic(ctx, err)
+		}
+	}()
+	return actionFunc(ctx, xid, payload)
+}
@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package saga
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGlobalTransactionFinishedStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantCommit   bool
+		wantRollback bool
+	}{
+		{"committed", StatusCommitted, false, true},
+		{"confirming", StatusConfirming, false, true},
+		{"cancelling", StatusCancelling, true, false},
+		{"rolled back", StatusRolledBack, true, false},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gtx := &globalTransaction{xid: "zormsaga1", status: tt.status}
+			if err := gtx.CommitGTX(ctx, ctx); (err != nil) != tt.wantCommit {
+				t.Errorf("CommitGTX() error = %v, wantErr %v", err, tt.wantCommit)
+			}
+			if err := gtx.RollbackGTX(ctx, ctx); (err != nil) != tt.wantRollback {
+				t.Errorf("RollbackGTX() error = %v, wantErr %v", err, tt.wantRollback)
+			}
+			if err := gtx.persistConfirming(ctx); err == nil {
+				t.Errorf("persistConfirming() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRegisterAction(t *testing.T) {
+	noop := func(ctx context.Context, xid string, payload string) error { return nil }
+	tests := []struct {
+		name    string
+		action  string
+		value   Action
+		wantErr bool
+	}{
+		{"confirm and cancel", "pay", Action{Confirm: noop, Cancel: noop}, false},
+		{"cancel only", "stock", Action{Cancel: noop}, false},
+		{"duplicate", "pay", Action{Confirm: noop}, true},
+		{"empty name", "", Action{Confirm: noop}, true},
+		{"no function", "ship", Action{}, true},
+	}
+	coordinator, err := NewCoordinator(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := coordinator.RegisterAction(tt.action, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RegisterAction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSafeRunAction(t *testing.T) {
+	errAction := errors.New("action failed")
+	tests := []struct {
+		name    string
+		action  func(ctx context.Context, xid string, payload string) error
+		wantErr bool
+	}{
+		{"success", func(ctx context.Context, xid string, payload string) error { return nil }, false},
+		{"error", func(ctx context.Context, xid string, payload string) error { return errAction }, true},
+		{"panic", func(ctx context.Context, xid string, payload string) error { panic("boom") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := safeRunAction(context.Background(), tt.action, "zormsaga1", "{}")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("safeRunAction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
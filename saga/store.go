@@ -0,0 +1,192 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package saga
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oouxx/zorm/v2"
+)
+
+// sagaRecord 分布式事务状态表的记录
+type sagaRecord struct {
+	XID       string    `column:"xid"`
+	Status    int       `column:"status"`
+	UpdatedAt time.Time `column:"updated_at"`
+}
+
+// step 分布式事务的步骤,对应步骤状态表的记录
+type step struct {
+	ID      string `column:"id"`
+	XID     string `column:"xid"`
+	StepNo  int    `column:"step_no"`
+	Action  string `column:"action"`
+	Payload string `column:"payload"`
+	Status  int    `column:"status"`
+}
+
+// storeTransaction 在独立的本地事务中操作状态表.使用新的context,不加入业务的事务,也不开启分布式事务
+// 状态需要在业务事务回滚之后依然保留
+func (coordinator *Coordinator) storeTransaction(doTransaction func(ctx context.Context) error) error {
+	ctx := context.Background()
+	var err error
+	if coordinator.config.DBDao != nil {
+		ctx, err = coordinator.config.DBDao.BindContextDBConnection(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = zorm.Transaction(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, doTransaction(ctx)
+	})
+	return err
+}
+
+// insertTransaction 保存新的分布式事务
+func (coordinator *Coordinator) insertTransaction(xid string) error {
+	return coordinator.storeTransaction(func(ctx context.Context) error {
+		now := time.Now()
+		entityMap := zorm.NewEntityMap(coordinator.config.TableName)
+		entityMap.Set("xid", xid)
+		entityMap.Set("status", StatusActive)
+		entityMap.Set("created_at", now)
+		entityMap.Set("updated_at", now)
+		_, err := zorm.InsertEntityMap(ctx, entityMap)
+		return err
+	})
+}
+
+// insertStep 保存分布式事务的步骤,同时更新分布式事务的updated_at,避免Resume处理进行中的事务
+func (coordinator *Coordinator) insertStep(s *step) error {
+	return coordinator.storeTransaction(func(ctx context.Context) error {
+		now := time.Now()
+		entityMap := zorm.NewEntityMap(coordinator.config.StepTableName)
+		entityMap.Set("id", s.ID)
+		entityMap.Set("xid", s.XID)
+		entityMap.Set("step_no", s.StepNo)
+		entityMap.Set("action", s.Action)
+		entityMap.Set("payload", s.Payload)
+		entityMap.Set("status", s.Status)
+		entityMap.Set("updated_at", now)
+		_, err := zorm.InsertEntityMap(ctx, entityMap)
+		if err != nil {
+			return err
+		}
+		finder := zorm.NewUpdateFinder(coordinator.config.TableName).Append("updated_at=? WHERE xid=?", now, s.XID)
+		_, err = zorm.UpdateFinder(ctx, finder)
+		return err
+	})
+}
+
+// updateTransactionStatus 更新分布式事务的状态,只有当前状态是fromStatus时才更新成功,没有更新返回错误.
+// 避免Resume取消之后,进行中的分布式事务又执行确认动作,或者确认之后又被取消
+func (coordinator *Coordinator) updateTransactionStatus(xid string, fromStatus int, status int) error {
+	return coordinator.storeTransaction(func(ctx context.Context) error {
+		finder := zorm.NewUpdateFinder(coordinator.config.TableName).Append("status=?,updated_at=? WHERE xid=? AND status=?", status, time.Now(), xid, fromStatus)
+		affected, err := zorm.UpdateFinder(ctx, finder)
+		if err != nil {
+			return err
+		}
+		if affected < 1 {
+			return fmt.Errorf("->saga.updateTransactionStatus-->分布式事务%s的状态已经不是%d,可能已经被其他实例处理", xid, fromStatus)
+		}
+		return nil
+	})
+}
+
+// updateStepStatus 更新步骤的状态
+func (coordinator *Coordinator) updateStepStatus(id string, status int) error {
+	return coordinator.storeTransaction(func(ctx context.Context) error {
+		finder := zorm.NewUpdateFinder(coordinator.config.StepTableName).Append("status=?,updated_at=? WHERE id=?", status, time.Now(), id)
+		_, err := zorm.UpdateFinder(ctx, finder)
+		return err
+	})
+}
+
+// claimTransaction 抢占需要恢复的分布式事务,updated_at没有变化才能抢占成功,避免多个实例同时恢复
+func (coordinator *Coordinator) claimTransaction(record *sagaRecord) (bool, error) {
+	claimed := false
+	err := coordinator.storeTransaction(func(ctx context.Context) error {
+		finder := zorm.NewUpdateFinder(coordinator.config.TableName).Append("updated_at=? WHERE xid=? AND status=? AND updated_at=?", time.Now(), record.XID, record.Status, record.UpdatedAt)
+		affected, err := zorm.UpdateFinder(ctx, finder)
+		claimed = affected > 0
+		return err
+	})
+	return claimed, err
+}
+
+// Resume 继续执行没有完成的分布式事务,建议在应用启动时调用,也可以定时调用
+// StatusConfirming 继续执行确认动作;StatusActive和StatusCancelling 执行取消动作,进程崩溃时还没有提交的分布式事务按照回滚处理
+// 只处理超过Config.ResumeAfter没有更新的分布式事务,返回第一个错误,其他的分布式事务继续处理
+// Resume Continue the unfinished global transactions, call it when the application starts, or periodically
+// StatusConfirming continues confirming, StatusActive and StatusCancelling are cancelled, uncommitted transactions are rolled back after a crash
+func (coordinator *Coordinator) Resume(ctx context.Context) error {
+	if coordinator.config.DBDao != nil {
+		var err error
+		ctx, err = coordinator.config.DBDao.BindContextDBConnection(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	finder := zorm.NewSelectFinder(coordinator.config.TableName, "xid,status,updated_at")
+	finder.Append("WHERE status IN (?) AND updated_at<? ORDER BY updated_at", []int{StatusActive, StatusConfirming, StatusCancelling}, time.Now().Add(-coordinator.config.ResumeAfter))
+	records := make([]sagaRecord, 0)
+	err := zorm.Query(ctx, finder, &records, nil)
+	if err != nil {
+		return err
+	}
+	var errResume error
+	for i := range records {
+		record := &records[i]
+		//本进程进行中的分布式事务
+		if coordinator.getTransaction(record.XID) != nil {
+			continue
+		}
+		err = coordinator.resumeTransaction(ctx, record)
+		if err != nil {
+			zorm.FuncLogError(ctx, err)
+			if errResume == nil {
+				errResume = err
+			}
+		}
+	}
+	return errResume
+}
+
+// resumeTransaction 继续执行一个分布式事务
+func (coordinator *Coordinator) resumeTransaction(ctx context.Context, record *sagaRecord) error {
+	claimed, err := coordinator.claimTransaction(record)
+	if err != nil || !claimed {
+		return err
+	}
+	finder := zorm.NewSelectFinder(coordinator.config.StepTableName, "id,xid,step_no,action,payload,status")
+	finder.Append("WHERE xid=? ORDER BY step_no", record.XID)
+	records := make([]step, 0)
+	err = zorm.Query(ctx, finder, &records, nil)
+	if err != nil {
+		return err
+	}
+	steps := make([]*step, len(records))
+	for i := range records {
+		steps[i] = &records[i]
+	}
+	return coordinator.complete(record.XID, record.Status, steps, record.Status == StatusConfirming)
+}
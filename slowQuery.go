@@ -0,0 +1,264 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowQuery 慢查询和执行计划
+// SlowQuery The slow query and its execution plan
+type SlowQuery struct {
+	//SQL 执行的SQL语句,已经处理过方言
+	//SQL The SQL statement, dialect is already processed
+	SQL string
+	//Args SQL的参数,敏感字段已经脱敏
+	//Args The SQL arguments, sensitive columns are masked
+	Args []interface{}
+	//Duration 执行时间
+	//Duration The execution time
+	Duration time.Duration
+	//Dialect 数据库方言
+	//Dialect Database dialect
+	Dialect string
+	//Fingerprint SQL语句的指纹,相同结构的语句指纹相同,用于限流
+	//Fingerprint The fingerprint of the SQL statement, statements with the same structure have the same fingerprint, used for rate limiting
+	Fingerprint string
+	//Plan 执行计划,多行结果使用换行分隔,一行的多列使用tab分隔
+	//Plan The execution plan, rows are separated by newline, columns of a row are separated by tab
+	Plan string
+	//Err 执行EXPLAIN的错误,有错误时Plan为空
+	//Err The error of EXPLAIN, Plan is empty if there is an error
+	Err error
+}
+
+// SlowQueryAnalyzer 慢查询分析器,配置到DataSourceConfig.SlowQueryAnalyzer.
+// 执行时间超过SlowSQLMillis的SELECT语句,使用新的连接在事务之外异步执行EXPLAIN,把执行计划发送给Sink.
+// 支持mysql(EXPLAIN FORMAT=JSON),postgresql(EXPLAIN (FORMAT JSON))和sqlite(EXPLAIN QUERY PLAN)
+// SlowQueryAnalyzer The slow query analyzer, configured to DataSourceConfig.SlowQueryAnalyzer.
+// For SELECT statements slower than SlowSQLMillis, EXPLAIN is executed asynchronously on a new connection outside the transaction, and the plan is sent to Sink
+type SlowQueryAnalyzer struct {
+	//Sink 接收慢查询和执行计划,默认nil使用DefaultLogger或者FuncPrintSQL输出.在单独的goroutine里调用
+	//Sink Receives the slow query and the plan, default nil writes with DefaultLogger or FuncPrintSQL. Called in a separate goroutine
+	Sink func(ctx context.Context, slowQuery *SlowQuery)
+	//Interval 相同指纹的语句执行EXPLAIN的最小间隔,默认1分钟
+	//Interval The minimum interval of EXPLAIN for statements with the same fingerprint, default 1 minute
+	Interval time.Duration
+	//Timeout 执行EXPLAIN的超时时间,默认5秒
+	//Timeout The timeout of EXPLAIN, default 5 seconds
+	Timeout time.Duration
+
+	//lastExplain 指纹最后一次执行EXPLAIN的时间,key是指纹,值是*int64的UnixNano
+	lastExplain sync.Map
+}
+
+// explainPrefix 方言的EXPLAIN语法,不支持的方言返回空字符串
+func explainPrefix(dialect string) string {
+	switch dialect {
+	case "mysql":
+		return "EXPLAIN FORMAT=JSON "
+	case "postgresql":
+		return "EXPLAIN (FORMAT JSON) "
+	case "sqlite":
+		return "EXPLAIN QUERY PLAN "
+	}
+	return ""
+}
+
+// isSelectSQL 是否是SELECT语句,跳过开头的空白,注释和括号
+func isSelectSQL(sqlstr string) bool {
+	sqlstr = strings.TrimLeft(sqlstr, " \t\r\n(")
+	for strings.HasPrefix(sqlstr, "/*") {
+		end := strings.Index(sqlstr, "*/")
+		if end < 0 {
+			return false
+		}
+		sqlstr = strings.TrimLeft(sqlstr[end+2:], " \t\r\n(")
+	}
+	return len(sqlstr) > 6 && strings.EqualFold(sqlstr[:6], "SELECT") && !isSQLWordChar(sqlstr[6])
+}
+
+// allow 相同指纹的语句,Interval内只允许执行一次EXPLAIN
+func (analyzer *SlowQueryAnalyzer) allow(fingerprint string, now time.Time) bool {
+	interval := analyzer.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	nowNano := now.UnixNano()
+	value, loaded := analyzer.lastExplain.LoadOrStore(fingerprint, &nowNano)
+	if !loaded {
+		return true
+	}
+	last := value.(*int64)
+	lastNano := atomic.LoadInt64(last)
+	if nowNano-lastNano < int64(interval) {
+		return false
+	}
+	return atomic.CompareAndSwapInt64(last, lastNano, nowNano)
+}
+
+// analyzeSlowQuery 慢SQL是SELECT语句时,异步执行EXPLAIN
+func (dbConnection *dataBaseConnection) analyzeSlowQuery(ctx context.Context, sqlstr string, args []interface{}, duration time.Duration) {
+	analyzer := dbConnection.config.SlowQueryAnalyzer
+	if analyzer == nil {
+		return
+	}
+	dialect := dbConnection.config.Dialect
+	prefix := explainPrefix(dialect)
+	if prefix == "" || !isSelectSQL(sqlstr) {
+		return
+	}
+	fingerprint := fingerprintSQL(sqlstr)
+	if !analyzer.allow(fingerprint, time.Now()) {
+		return
+	}
+	//使用连接池的新连接,不在调用方的事务里执行
+	db := dbConnection.db
+	if dbConnection.dbDao != nil {
+		if _, dataSource := dbConnection.dbDao.loadDataSource(); dataSource != nil {
+			db = dataSource.DB
+		}
+	}
+	if db == nil {
+		return
+	}
+	slowQuery := &SlowQuery{
+		SQL:         sqlstr,
+		Args:        redactArgs(ctx, sqlstr, args),
+		Duration:    duration,
+		Dialect:     dialect,
+		Fingerprint: fingerprint,
+	}
+	//调用方的ctx可能已经结束,只保留ctx中的值.日志使用当前的快照,不在异步的goroutine中读取dbConnection
+	sinkCtx := detachedContext{context.WithValue(ctx, contextLogSnapshotValueKey, dbConnection.logSnapshot())}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				FuncLogPanic(sinkCtx, fmt.Errorf("->analyzeSlowQuery-->慢查询分析异常:%v", r))
+			}
+		}()
+		timeout := analyzer.Timeout
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		explainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		slowQuery.Plan, slowQuery.Err = explainSQL(explainCtx, db, prefix+sqlstr, args)
+		if slowQuery.Err != nil {
+			slowQuery.Err = fmt.Errorf("->analyzeSlowQuery-->执行EXPLAIN错误:%w", slowQuery.Err)
+		}
+		sink := analyzer.Sink
+		if sink == nil {
+			sink = defaultSlowQuerySink
+		}
+		sink(sinkCtx, slowQuery)
+	}()
+}
+
+// explainSQL 执行EXPLAIN,把所有行和列拼接为字符串
+func explainSQL(ctx context.Context, db *sql.DB, explainsql string, args []interface{}) (string, error) {
+	rows, err := db.QueryContext(ctx, explainsql, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var builder strings.Builder
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\n")
+		}
+		for i, value := range values {
+			if i > 0 {
+				builder.WriteString("\t")
+			}
+			builder.Write(value)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// defaultSlowQuerySink 默认的Sink,配置了DefaultLogger时输出LogLevelWarn的结构化日志,否则使用FuncPrintSQL输出执行计划,FuncLogError输出EXPLAIN的错误
+func defaultSlowQuerySink(ctx context.Context, slowQuery *SlowQuery) {
+	if logger := DefaultLogger; logger != nil {
+		fields := []LogField{
+			{LogFieldSQL, slowQuery.SQL},
+			{LogFieldArgs, slowQuery.Args},
+			{LogFieldDuration, slowQuery.Duration},
+			{LogFieldDialect, slowQuery.Dialect},
+			{"plan", slowQuery.Plan},
+		}
+		if slowQuery.Err != nil {
+			fields = append(fields, LogField{LogFieldError, slowQuery.Err})
+		}
+		logger.Log(ctx, LogLevelWarn, "slow query", fields...)
+		return
+	}
+	if slowQuery.Err != nil {
+		FuncLogError(ctx, fmt.Errorf("->analyzeSlowQuery-->慢查询%s,args:%v,duration:%v:%w", slowQuery.SQL, slowQuery.Args, slowQuery.Duration, slowQuery.Err))
+		return
+	}
+	//执行计划跟在SQL语句后面,使用FuncPrintSQL输出
+	FuncPrintSQL(ctx, slowQuery.SQL+"\nplan:\n"+slowQuery.Plan, slowQuery.Args, slowQuery.Duration.Milliseconds())
+}
+
+// detachedContext 只保留parent的值,不会被取消,也没有超时.用于语句执行之后的异步处理
+type detachedContext struct {
+	parent context.Context
+}
+
+// Deadline 实现context.Context接口,没有超时
+func (ctx detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done 实现context.Context接口,不会被取消
+func (ctx detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err 实现context.Context接口
+func (ctx detachedContext) Err() error {
+	return nil
+}
+
+// Value 实现context.Context接口,使用parent的值
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
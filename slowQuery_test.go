@@ -0,0 +1,76 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsSelectSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		sqlstr string
+		want   bool
+	}{
+		{"select", "SELECT * FROM t", true},
+		{"lower case", "select 1", true},
+		{"leading whitespace", " \n\tSELECT 1", true},
+		{"parenthesis", "(SELECT 1) UNION (SELECT 2)", true},
+		{"leading comment", "/* route='/order' */ SELECT 1", true},
+		{"multiple comments", "/*a*/ /*b*/(SELECT 1)", true},
+		{"unclosed comment", "/* SELECT 1", false},
+		{"select prefix identifier", "SELECTED", false},
+		{"select only", "SELECT", false},
+		{"update", "UPDATE t SET a=1", false},
+		{"with", "WITH a AS (SELECT 1) SELECT * FROM a", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSelectSQL(tt.sqlstr); got != tt.want {
+				t.Errorf("isSelectSQL(%q) = %v, want %v", tt.sqlstr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlowQueryAnalyzerAllow(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		fingerprint string
+		now         time.Time
+		want        bool
+	}{
+		{"first", "select ?", now, true},
+		{"within interval", "select ?", now.Add(30 * time.Second), false},
+		{"other fingerprint", "select * from t", now, true},
+		{"after interval", "select ?", now.Add(time.Minute), true},
+		{"within new interval", "select ?", now.Add(time.Minute + time.Second), false},
+	}
+	analyzer := &SlowQueryAnalyzer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := analyzer.allow(tt.fingerprint, tt.now); got != tt.want {
+				t.Errorf("allow(%q) = %v, want %v", tt.fingerprint, got, tt.want)
+			}
+		})
+	}
+}
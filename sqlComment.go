@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// sqlcommenter注释的key,参照 https://google.github.io/sqlcommenter/spec/
+// The keys of sqlcommenter comments, see https://google.github.io/sqlcommenter/spec/
+const (
+	// SQLCommentApplication 服务名称
+	SQLCommentApplication = "application"
+	// SQLCommentRoute 请求的路由,例如 /api/order/:id
+	SQLCommentRoute = "route"
+	// SQLCommentController 控制器
+	SQLCommentController = "controller"
+	// SQLCommentAction 控制器的方法
+	SQLCommentAction = "action"
+	// SQLCommentTraceparent W3C的traceparent,包含trace id
+	SQLCommentTraceparent = "traceparent"
+	// SQLCommentCaller 业务调用zorm的文件:行号,开启SQLCommenter后自动增加
+	SQLCommentCaller = "caller"
+)
+
+// contextSQLCommentValueKey 把sqlcommenter的注释放到context里使用的key,值是map[string]string
+const contextSQLCommentValueKey = wrapContextStringKey("contextSQLCommentValueKey")
+
+// BindContextSQLComment context中绑定sqlcommenter注释的key和value,可以多次调用绑定多个key,相同的key覆盖.
+// 需要配置DataSourceConfig.SQLCommenter=true才会增加到SQL语句,一般在http中间件里绑定服务名称,路由和traceparent
+// BindContextSQLComment Bind the key and value of the sqlcommenter comment to the context, call it multiple times to bind multiple keys, the same key is overwritten.
+// Added to SQL only when DataSourceConfig.SQLCommenter=true, usually bind the service name, route and traceparent in http middleware
+func BindContextSQLComment(parent context.Context, key string, value string) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextSQLComment-->context的parent不能为nil")
+	}
+	if key == "" {
+		return nil, errors.New("->BindContextSQLComment-->key不能为空")
+	}
+	oldTags, _ := parent.Value(contextSQLCommentValueKey).(map[string]string)
+	//复制一份,不影响parent
+	tags := make(map[string]string, len(oldTags)+1)
+	for k, v := range oldTags {
+		tags[k] = v
+	}
+	tags[key] = value
+	ctx := context.WithValue(parent, contextSQLCommentValueKey, tags)
+	return ctx, nil
+}
+
+// wrapSQLComment 开启SQLCommenter时,在SQL语句末尾增加sqlcommenter格式的注释.和wrapSQLHint一样在执行前处理
+func (dbConnection *dataBaseConnection) wrapSQLComment(ctx context.Context, sqlstr *string) {
+	if !dbConnection.config.SQLCommenter || !supportSQLComment(dbConnection.config.Dialect) {
+		return
+	}
+	tags, _ := ctx.Value(contextSQLCommentValueKey).(map[string]string)
+	comment := wrapSQLCommentTags(tags, businessCaller())
+	if comment == "" {
+		return
+	}
+	//注释放到分号之前
+	sqlTrim := strings.TrimRight(*sqlstr, " \t\r\n;")
+	var sqlBuilder strings.Builder
+	sqlBuilder.Grow(len(sqlTrim) + len(comment) + 1)
+	sqlBuilder.WriteString(sqlTrim)
+	sqlBuilder.WriteString(" ")
+	sqlBuilder.WriteString(comment)
+	*sqlstr = sqlBuilder.String()
+}
+
+// supportSQLComment 数据库是否支持语句末尾的注释,TDengine不支持
+func supportSQLComment(dialect string) bool {
+	return dialect != "tdengine"
+}
+
+// wrapSQLCommentTags 生成 /*key='value',key='value'*/ 格式的注释,key按照字典序排序
+func wrapSQLCommentTags(tags map[string]string, caller string) string {
+	if len(tags) < 1 && caller == "" {
+		return ""
+	}
+	keys := make([]string, 0, len(tags)+1)
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	if _, has := tags[SQLCommentCaller]; !has && caller != "" {
+		keys = append(keys, SQLCommentCaller)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	builder.WriteString("/*")
+	for i, key := range keys {
+		value, has := tags[key]
+		if !has {
+			value = caller
+		}
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(escapeSQLComment(key))
+		builder.WriteString("='")
+		builder.WriteString(escapeSQLComment(value))
+		builder.WriteString("'")
+	}
+	builder.WriteString("*/")
+	return builder.String()
+}
+
+// escapeSQLComment 按照sqlcommenter的规范URL编码,再转义单引号.
+// 编码之后不会出现 */ 结束注释,mysql的 /*! 可执行注释,以及 ? $1 :1 @p1 等会被驱动或者reBindSQL当作参数的字符,所有方言都是安全的
+func escapeSQLComment(value string) string {
+	value = strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+	return strings.Replace(value, "'", `\'`, -1)
+}
@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEscapeSQLComment(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "order-service", "order-service"},
+		{"space", "a b", "a%20b"},
+		{"route", "/api/order/:id", "%2Fapi%2Forder%2F%3Aid"},
+		{"end of comment", "x*/DROP TABLE t;/*", "x%2A%2FDROP%20TABLE%20t%3B%2F%2A"},
+		{"mysql executable comment", "/*!50000 x */", "%2F%2A%2150000%20x%20%2A%2F"},
+		{"quote", "it's", "it%27s"},
+		{"placeholders", "? $1 :1 @p1", "%3F%20%241%20%3A1%20%40p1"},
+		{"unicode", "订单", "%E8%AE%A2%E5%8D%95"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeSQLComment(tt.value); got != tt.want {
+				t.Errorf("escapeSQLComment(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapSQLCommentTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		tags   map[string]string
+		caller string
+		want   string
+	}{
+		{"empty", nil, "", ""},
+		{"caller only", nil, "order.go:10", "/*caller='order.go%3A10'*/"},
+		{"sorted keys", map[string]string{SQLCommentRoute: "/order", SQLCommentApplication: "shop"}, "", "/*application='shop',route='%2Forder'*/"},
+		{"bound caller first", map[string]string{SQLCommentCaller: "api"}, "order.go:10", "/*caller='api'*/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wrapSQLCommentTags(tt.tags, tt.caller); got != tt.want {
+				t.Errorf("wrapSQLCommentTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindContextSQLComment(t *testing.T) {
+	parent, err := BindContextSQLComment(context.Background(), SQLCommentApplication, "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := BindContextSQLComment(parent, SQLCommentRoute, "/order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"parent is not modified", parent, "/*application='shop'*/"},
+		{"child has both keys", ctx, "/*application='shop',route='%2Forder'*/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, _ := tt.ctx.Value(contextSQLCommentValueKey).(map[string]string)
+			if got := wrapSQLCommentTags(tags, ""); got != tt.want {
+				t.Errorf("wrapSQLCommentTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err = BindContextSQLComment(context.Background(), "", "x"); err == nil {
+		t.Errorf("BindContextSQLComment() with empty key error = nil, want error")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"regexp"
+	"strings"
+)
+
+// sqlFingerprintListRegexp 参数列表,例如 IN (?,?,?) 或者 VALUES (?,?)
+var sqlFingerprintListRegexp = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+
+// sqlFingerprintRowsRegexp 批量保存的多行 VALUES (?+),(?+)
+var sqlFingerprintRowsRegexp = regexp.MustCompile(`\(\?\+\)(?:\s*,\s*\(\?\+\))+`)
+
+// fingerprintSQL 计算SQL语句的指纹,相同结构的语句指纹相同.
+// 去掉注释,字符串和数字常量以及 ?, $1, :1, @p1 等占位符统一替换为 ?,参数列表替换为 (?+),合并空白并转为小写.
+// 所以reBindSQL之前和之后,不同的参数个数和sqlcommenter注释都不影响指纹
+func fingerprintSQL(sqlstr string) string {
+	var builder strings.Builder
+	builder.Grow(len(sqlstr))
+	//上一个输出的字符是否是空白
+	space := true
+	length := len(sqlstr)
+	for i := 0; i < length; i++ {
+		c := sqlstr[i]
+		switch {
+		//块注释
+		case c == '/' && i+1 < length && sqlstr[i+1] == '*':
+			end := strings.Index(sqlstr[i+2:], "*/")
+			if end < 0 {
+				i = length
+			} else {
+				i = i + 2 + end + 1
+			}
+			c = ' '
+		//行注释
+		case c == '-' && i+1 < length && sqlstr[i+1] == '-':
+			end := strings.IndexByte(sqlstr[i:], '\n')
+			if end < 0 {
+				i = length
+			} else {
+				i = i + end
+			}
+			c = ' '
+		//字符串常量,'' 是转义的单引号
+		case c == '\'':
+			for i++; i < length; i++ {
+				if sqlstr[i] == '\'' {
+					if i+1 < length && sqlstr[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			c = '?'
+		//$1, :1, :name, @p1 占位符. postgresql的 ::类型转换 不是占位符
+		case (c == '$' || c == ':' || c == '@') && i+1 < length && isSQLWordChar(sqlstr[i+1]) && !(c == ':' && i > 0 && sqlstr[i-1] == ':'):
+			for i+1 < length && isSQLWordChar(sqlstr[i+1]) {
+				i++
+			}
+			c = '?'
+		//数字常量,不是标识符的一部分
+		case c >= '0' && c <= '9' && (i == 0 || !isSQLWordChar(sqlstr[i-1])):
+			for i+1 < length && (isSQLWordChar(sqlstr[i+1]) || sqlstr[i+1] == '.') {
+				i++
+			}
+			c = '?'
+		case c >= 'A' && c <= 'Z':
+			c = c + ('a' - 'A')
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			if !space {
+				builder.WriteByte(' ')
+				space = true
+			}
+			continue
+		}
+		builder.WriteByte(c)
+		space = false
+	}
+	fingerprint := strings.TrimRight(builder.String(), " ;")
+	fingerprint = sqlFingerprintListRegexp.ReplaceAllString(fingerprint, "(?+)")
+	fingerprint = sqlFingerprintRowsRegexp.ReplaceAllString(fingerprint, "(?+)")
+	return fingerprint
+}
+
+// isSQLWordChar 是否是标识符的字符
+func isSQLWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,52 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"testing"
+)
+
+func TestFingerprintSQL(t *testing.T) {
+	tests := []struct {
+		name   string
+		sqlstr string
+		want   string
+	}{
+		{"placeholder", "SELECT * FROM t_user WHERE id=?", "select * from t_user where id=?"},
+		{"constants", "SELECT * FROM t WHERE name='it''s' AND age>18 AND score=9.5", "select * from t where name=? and age>? and score=?"},
+		{"identifier with digits", "SELECT col1 FROM t2", "select col1 from t2"},
+		{"postgresql placeholders", "SELECT * FROM t WHERE id=$1 AND name=$2", "select * from t where id=? and name=?"},
+		{"postgresql type cast", "SELECT * FROM t WHERE id=$1::int", "select * from t where id=?::int"},
+		{"oracle and sqlserver placeholders", "UPDATE t SET a=:1 WHERE b=@p2", "update t set a=? where b=?"},
+		{"in list", "SELECT * FROM t WHERE id IN (?, ?, ?)", "select * from t where id in (?+)"},
+		{"in list with one arg", "SELECT * FROM t WHERE id IN (?)", "select * from t where id in (?+)"},
+		{"batch insert", "INSERT INTO t (a,b) VALUES (?,?), (?,?),(?,?)", "insert into t (a,b) values (?+)"},
+		{"block comment", "SELECT /* hint */ 1 /*controller='order'*/", "select ?"},
+		{"line comment", "SELECT a -- comment\nFROM t", "select a from t"},
+		{"unclosed comment", "SELECT a /* comment", "select a"},
+		{"whitespace and semicolon", "  SELECT\n\ta\r\nFROM   t ;", "select a from t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fingerprintSQL(tt.sqlstr); got != tt.want {
+				t.Errorf("fingerprintSQL(%q) = %q, want %q", tt.sqlstr, got, tt.want)
+			}
+		})
+	}
+}
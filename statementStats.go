@@ -0,0 +1,355 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// StatementStats 相同指纹的SQL语句的聚合统计,类似pg_stat_statements
+// StatementStats The aggregate statistics of SQL statements with the same fingerprint, similar to pg_stat_statements
+type StatementStats struct {
+	//Fingerprint SQL语句的指纹,常量,参数和IN列表已经归一化
+	//Fingerprint The fingerprint of the SQL statement, literals, placeholders and IN lists are normalized
+	Fingerprint string
+	//Calls 执行次数
+	//Calls The number of executions
+	Calls int64
+	//Errors 执行出错的次数
+	//Errors The number of errors
+	Errors int64
+	//Rows 查询返回的行数和更新语句影响的行数之和
+	//Rows The total number of rows returned by queries and affected by execs
+	Rows int64
+	//TotalTime 总执行时间
+	//TotalTime The total execution time
+	TotalTime time.Duration
+	//MinTime 最小执行时间
+	//MinTime The minimum execution time
+	MinTime time.Duration
+	//MaxTime 最大执行时间
+	//MaxTime The maximum execution time
+	MaxTime time.Duration
+	//P50Time 执行时间的中位数,近似值,误差约20%
+	//P50Time The median execution time, approximate, the error is about 20%
+	P50Time time.Duration
+	//P99Time 执行时间的99分位数,近似值,误差约20%
+	//P99Time The 99th percentile execution time, approximate, the error is about 20%
+	P99Time time.Duration
+}
+
+// MaxStatementStats 每个DBDao最多统计的指纹数量,超过之后的语句合并到指纹为 StatementStatsOther 的统计.需要在init里设置
+// MaxStatementStats The maximum number of fingerprints per DBDao, statements beyond are merged into the fingerprint StatementStatsOther. Set it in init
+var MaxStatementStats = 5000
+
+// StatementStatsOther 超过MaxStatementStats之后,合并统计使用的指纹
+const StatementStatsOther = "<other>"
+
+// statementLatencyBuckets 执行时间直方图的桶数量.每个桶是上一个的2^(1/4)倍,从1微秒到大约1小时
+const statementLatencyBuckets = 128
+
+// statementStatsEntry 一个指纹的统计
+type statementStatsEntry struct {
+	mutex     sync.Mutex
+	calls     int64
+	errors    int64
+	rows      int64
+	totalTime time.Duration
+	minTime   time.Duration
+	maxTime   time.Duration
+	histogram [statementLatencyBuckets]int64
+}
+
+// statementStatsMap DBDao的语句统计,key是指纹,值是*statementStatsEntry
+type statementStatsMap struct {
+	//size 指纹的数量,放在第一个保证原子操作对齐
+	size    int64
+	entries sync.Map
+}
+
+// latencyBucket 执行时间所在的桶
+func latencyBucket(duration time.Duration) int {
+	micros := duration.Microseconds()
+	if micros < 1 {
+		return 0
+	}
+	bucket := int(math.Log2(float64(micros))*4) + 1
+	if bucket >= statementLatencyBuckets {
+		bucket = statementLatencyBuckets - 1
+	}
+	return bucket
+}
+
+// latencyBucketUpper 桶的上限
+func latencyBucketUpper(bucket int) time.Duration {
+	return time.Duration(math.Pow(2, float64(bucket)/4) * float64(time.Microsecond))
+}
+
+// getEntry 获取指纹的统计,不存在时创建.超过MaxStatementStats时使用StatementStatsOther
+func (statements *statementStatsMap) getEntry(fingerprint string) *statementStatsEntry {
+	if value, ok := statements.entries.Load(fingerprint); ok {
+		return value.(*statementStatsEntry)
+	}
+	if atomic.LoadInt64(&statements.size) >= int64(MaxStatementStats) {
+		fingerprint = StatementStatsOther
+	}
+	value, loaded := statements.entries.LoadOrStore(fingerprint, &statementStatsEntry{})
+	if !loaded {
+		atomic.AddInt64(&statements.size, 1)
+	}
+	return value.(*statementStatsEntry)
+}
+
+// record 记录一次执行
+func (entry *statementStatsEntry) record(duration time.Duration, rowsAffected int64, err error) {
+	bucket := latencyBucket(duration)
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.calls++
+	if err != nil {
+		entry.errors++
+	}
+	if rowsAffected > 0 {
+		entry.rows += rowsAffected
+	}
+	entry.totalTime += duration
+	if entry.calls == 1 || duration < entry.minTime {
+		entry.minTime = duration
+	}
+	if duration > entry.maxTime {
+		entry.maxTime = duration
+	}
+	entry.histogram[bucket]++
+}
+
+// snapshot 获取统计的快照
+func (entry *statementStatsEntry) snapshot(fingerprint string) StatementStats {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	stats := StatementStats{
+		Fingerprint: fingerprint,
+		Calls:       entry.calls,
+		Errors:      entry.errors,
+		Rows:        entry.rows,
+		TotalTime:   entry.totalTime,
+		MinTime:     entry.minTime,
+		MaxTime:     entry.maxTime,
+	}
+	stats.P50Time = entry.percentile(0.5)
+	stats.P99Time = entry.percentile(0.99)
+	return stats
+}
+
+// percentile 根据直方图计算分位数,使用桶的上限,不超过最大值
+func (entry *statementStatsEntry) percentile(quantile float64) time.Duration {
+	if entry.calls < 1 {
+		return 0
+	}
+	rank := int64(math.Ceil(quantile * float64(entry.calls)))
+	var count int64
+	for bucket, n := range entry.histogram {
+		count += n
+		if count >= rank {
+			upper := latencyBucketUpper(bucket)
+			if upper > entry.maxTime {
+				upper = entry.maxTime
+			}
+			if upper < entry.minTime {
+				upper = entry.minTime
+			}
+			return upper
+		}
+	}
+	return entry.maxTime
+}
+
+// statsStatementFingerprint 开启DataSourceConfig.StatementStats时,按照指纹记录语句的执行.rowsAffected小于0表示没有影响的行数
+func (dbConnection *dataBaseConnection) statsStatementFingerprint(sqlstr string, duration time.Duration, rowsAffected int64, err error) {
+	stats := dbConnection.getStats()
+	if stats == nil || !dbConnection.config.StatementStats {
+		return
+	}
+	stats.statements.getEntry(fingerprintSQL(sqlstr)).record(duration, rowsAffected, err)
+}
+
+// statsStatementRows 记录查询语句返回的行数,sqlstr是queryContext处理之后的语句
+func (dbConnection *dataBaseConnection) statsStatementRows(sqlstr string, rows int) {
+	stats := dbConnection.getStats()
+	if stats == nil || !dbConnection.config.StatementStats || rows < 1 {
+		return
+	}
+	//和执行的统计使用相同的指纹,超过MaxStatementStats时合并到StatementStatsOther
+	entry := stats.statements.getEntry(fingerprintSQL(sqlstr))
+	entry.mutex.Lock()
+	entry.rows += int64(rows)
+	entry.mutex.Unlock()
+}
+
+// StatementStats 获取语句的聚合统计,按照总执行时间倒序.需要配置DataSourceConfig.StatementStats=true
+// StatementStats Get the aggregate statistics of statements, ordered by total time descending. DataSourceConfig.StatementStats=true is required
+func (dbDao *DBDao) StatementStats() []StatementStats {
+	allStats := make([]StatementStats, 0)
+	if dbDao.stats == nil {
+		return allStats
+	}
+	dbDao.stats.statements.entries.Range(func(key, value interface{}) bool {
+		allStats = append(allStats, value.(*statementStatsEntry).snapshot(key.(string)))
+		return true
+	})
+	sort.Slice(allStats, func(i, j int) bool {
+		if allStats[i].TotalTime != allStats[j].TotalTime {
+			return allStats[i].TotalTime > allStats[j].TotalTime
+		}
+		return allStats[i].Fingerprint < allStats[j].Fingerprint
+	})
+	return allStats
+}
+
+// ResetStatementStats 清空语句的聚合统计
+// ResetStatementStats Reset the aggregate statistics of statements
+func (dbDao *DBDao) ResetStatementStats() {
+	if dbDao.stats == nil {
+		return
+	}
+	dbDao.stats.statements.entries.Range(func(key, value interface{}) bool {
+		dbDao.stats.statements.entries.Delete(key)
+		atomic.AddInt64(&dbDao.stats.statements.size, -1)
+		return true
+	})
+}
+
+// NewStatementStatsHandler 创建http.Handler,输出语句的聚合统计,key是DBDao的名称.默认输出文本表格,参数format=json或者Accept是application/json时输出JSON
+// dbDaos为nil时,每次请求输出zorm.RegisterDBDao注册的DBDao,以及名称为default的defaultDao
+// NewStatementStatsHandler Create an http.Handler that serves the aggregate statistics of statements, the key is the name of the DBDao. Text table by default, JSON if format=json or Accept is application/json
+// If dbDaos is nil, the DBDaos registered by zorm.RegisterDBDao and the defaultDao named default are served on each request
+func NewStatementStatsHandler(dbDaos map[string]*DBDao) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		daos := dbDaos
+		if daos == nil {
+			daos = statsDBDaos()
+		}
+		names := make([]string, 0, len(daos))
+		for name := range daos {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write(wrapStatementStatsJSON(names, daos))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(wrapStatementStatsText(names, daos))
+	})
+}
+
+// statementStatsJSON JSON格式的语句统计,时间的单位是毫秒
+type statementStatsJSON struct {
+	Fingerprint string  `json:"fingerprint"`
+	Calls       int64   `json:"calls"`
+	Errors      int64   `json:"errors"`
+	Rows        int64   `json:"rows"`
+	TotalMillis float64 `json:"total_ms"`
+	MeanMillis  float64 `json:"mean_ms"`
+	MinMillis   float64 `json:"min_ms"`
+	MaxMillis   float64 `json:"max_ms"`
+	P50Millis   float64 `json:"p50_ms"`
+	P99Millis   float64 `json:"p99_ms"`
+}
+
+// durationMillis 转换为毫秒
+func durationMillis(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
+
+// meanDuration 平均执行时间
+func (stats *StatementStats) meanDuration() time.Duration {
+	if stats.Calls < 1 {
+		return 0
+	}
+	return stats.TotalTime / time.Duration(stats.Calls)
+}
+
+// wrapStatementStatsJSON 生成JSON格式的语句统计
+func wrapStatementStatsJSON(names []string, dbDaos map[string]*DBDao) []byte {
+	result := make(map[string][]statementStatsJSON, len(names))
+	for _, name := range names {
+		allStats := dbDaos[name].StatementStats()
+		jsonStats := make([]statementStatsJSON, len(allStats))
+		for i := range allStats {
+			stats := &allStats[i]
+			jsonStats[i] = statementStatsJSON{
+				Fingerprint: stats.Fingerprint,
+				Calls:       stats.Calls,
+				Errors:      stats.Errors,
+				Rows:        stats.Rows,
+				TotalMillis: durationMillis(stats.TotalTime),
+				MeanMillis:  durationMillis(stats.meanDuration()),
+				MinMillis:   durationMillis(stats.MinTime),
+				MaxMillis:   durationMillis(stats.MaxTime),
+				P50Millis:   durationMillis(stats.P50Time),
+				P99Millis:   durationMillis(stats.P99Time),
+			}
+		}
+		result[name] = jsonStats
+	}
+	data, _ := json.Marshal(result)
+	return data
+}
+
+// wrapStatementStatsText 生成文本表格格式的语句统计,时间的单位是毫秒
+func wrapStatementStatsText(names []string, dbDaos map[string]*DBDao) []byte {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	writer.Write([]byte("db\tcalls\terrors\trows\ttotal_ms\tmean_ms\tmin_ms\tmax_ms\tp50_ms\tp99_ms\tfingerprint\n"))
+	formatMillis := func(duration time.Duration) string {
+		return strconv.FormatFloat(durationMillis(duration), 'f', 3, 64)
+	}
+	for _, name := range names {
+		allStats := dbDaos[name].StatementStats()
+		for i := range allStats {
+			stats := &allStats[i]
+			writer.Write([]byte(strings.Join([]string{
+				name,
+				strconv.FormatInt(stats.Calls, 10),
+				strconv.FormatInt(stats.Errors, 10),
+				strconv.FormatInt(stats.Rows, 10),
+				formatMillis(stats.TotalTime),
+				formatMillis(stats.meanDuration()),
+				formatMillis(stats.MinTime),
+				formatMillis(stats.MaxTime),
+				formatMillis(stats.P50Time),
+				formatMillis(stats.P99Time),
+				stats.Fingerprint,
+			}, "\t") + "\n"))
+		}
+	}
+	writer.Flush()
+	return buf.Bytes()
+}
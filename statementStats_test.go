@@ -0,0 +1,143 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		want     int
+	}{
+		{"zero", 0, 0},
+		{"below one microsecond", 500 * time.Nanosecond, 0},
+		{"one microsecond", time.Microsecond, 1},
+		{"two microseconds", 2 * time.Microsecond, 5},
+		{"one millisecond", time.Millisecond, 40},
+		{"one hour", time.Hour, 127},
+		{"capped", 10 * time.Hour, statementLatencyBuckets - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := latencyBucket(tt.duration)
+			if got != tt.want {
+				t.Errorf("latencyBucket(%v) = %d, want %d", tt.duration, got, tt.want)
+			}
+			//桶的上限不小于执行时间
+			if tt.duration < time.Hour && latencyBucketUpper(got) < tt.duration {
+				t.Errorf("latencyBucketUpper(%d) = %v, less than %v", got, latencyBucketUpper(got), tt.duration)
+			}
+		})
+	}
+}
+
+func TestStatementStatsEntryPercentile(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations map[time.Duration]int
+		quantile  float64
+		want      time.Duration
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single call capped to max", map[time.Duration]int{5 * time.Millisecond: 1}, 0.5, 5 * time.Millisecond},
+		{"median uses bucket upper", map[time.Duration]int{time.Millisecond: 99, 100 * time.Millisecond: 1}, 0.5, 1024 * time.Microsecond},
+		{"p99 in the same bucket", map[time.Duration]int{time.Millisecond: 99, 100 * time.Millisecond: 1}, 0.99, 1024 * time.Microsecond},
+		{"max capped to max time", map[time.Duration]int{time.Millisecond: 99, 100 * time.Millisecond: 1}, 1, 100 * time.Millisecond},
+		{"sub microsecond raised to min time", map[time.Duration]int{800 * time.Nanosecond: 2}, 0.5, 800 * time.Nanosecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &statementStatsEntry{}
+			for duration, n := range tt.durations {
+				for i := 0; i < n; i++ {
+					entry.record(duration, -1, nil)
+				}
+			}
+			if got := entry.percentile(tt.quantile); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.quantile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatementStatsEntrySnapshot(t *testing.T) {
+	entry := &statementStatsEntry{}
+	entry.record(3*time.Millisecond, 2, nil)
+	entry.record(time.Millisecond, -1, errors.New("failed"))
+	entry.record(2*time.Millisecond, 1, nil)
+	got := entry.snapshot("select ?")
+	want := StatementStats{
+		Fingerprint: "select ?",
+		Calls:       3,
+		Errors:      1,
+		Rows:        3,
+		TotalTime:   6 * time.Millisecond,
+		MinTime:     time.Millisecond,
+		MaxTime:     3 * time.Millisecond,
+		P50Time:     entry.percentile(0.5),
+		P99Time:     3 * time.Millisecond,
+	}
+	if got != want {
+		t.Errorf("snapshot() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatementStatsOverflow(t *testing.T) {
+	maxStatementStats := MaxStatementStats
+	MaxStatementStats = 2
+	defer func() { MaxStatementStats = maxStatementStats }()
+	dbConnection := &dataBaseConnection{config: &DataSourceConfig{StatementStats: true}, dbDao: &DBDao{stats: &dbDaoStats{}}}
+	dbConnection.statsStatementFingerprint("SELECT * FROM t1 WHERE id=1", time.Millisecond, -1, nil)
+	dbConnection.statsStatementFingerprint("SELECT * FROM t2", time.Millisecond, -1, nil)
+	dbConnection.statsStatementFingerprint("SELECT * FROM t3", time.Millisecond, -1, nil)
+	dbConnection.statsStatementRows("SELECT * FROM t3", 5)
+	dbConnection.statsStatementRows("SELECT * FROM t1 WHERE id=2", 2)
+	tests := []struct {
+		fingerprint string
+		wantCalls   int64
+		wantRows    int64
+	}{
+		{"select * from t1 where id=?", 1, 2},
+		{"select * from t2", 1, 0},
+		{StatementStatsOther, 1, 5},
+	}
+	allStats := dbConnection.dbDao.StatementStats()
+	if len(allStats) != len(tests) {
+		t.Fatalf("StatementStats() returned %d fingerprints, want %d", len(allStats), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.fingerprint, func(t *testing.T) {
+			for _, stats := range allStats {
+				if stats.Fingerprint != tt.fingerprint {
+					continue
+				}
+				if stats.Calls != tt.wantCalls || stats.Rows != tt.wantRows {
+					t.Errorf("StatementStats() = (%d, %d), want (%d, %d)", stats.Calls, stats.Rows, tt.wantCalls, tt.wantRows)
+				}
+				return
+			}
+			t.Errorf("StatementStats() has no fingerprint %q", tt.fingerprint)
+		})
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DBDaoStats 连接池的统计和zorm的计数器
+// DBDaoStats The pool statistics and zorm counters
+type DBDaoStats struct {
+	//DBStats 连接池的统计,Reconfigure替换连接池之后是新连接池的统计
+	//DBStats The pool statistics, statistics of the new pool after Reconfigure swaps the pool
+	DBStats sql.DBStats
+	//Queries 执行查询语句的次数
+	//Queries The number of queries
+	Queries int64
+	//Execs 执行更新语句的次数
+	//Execs The number of execs
+	Execs int64
+	//Errors 执行语句出错的次数
+	//Errors The number of statement errors
+	Errors int64
+	//TxCommitted 提交成功的事务数量
+	//TxCommitted The number of committed transactions
+	TxCommitted int64
+	//TxRolledBack 回滚的事务数量,包括提交失败的事务
+	//TxRolledBack The number of rolled back transactions, including failed commits
+	TxRolledBack int64
+	//SlowSQL 执行时间超过SlowSQLMillis的语句数量,SlowSQLMillis大于0时才统计
+	//SlowSQL The number of statements slower than SlowSQLMillis, only counted when SlowSQLMillis is greater than 0
+	SlowSQL int64
+}
+
+// dbDaoStats DBDao的计数器,使用指针分配保证int64的原子操作在32位平台上对齐
+type dbDaoStats struct {
+	queries      int64
+	execs        int64
+	errors       int64
+	txCommitted  int64
+	txRolledBack int64
+	slowSQL      int64
+	//statements 按照指纹的语句统计
+	statements statementStatsMap
+}
+
+// Stats 获取连接池的统计和zorm的计数器
+// Stats Get the pool statistics and zorm counters
+func (dbDao *DBDao) Stats() DBDaoStats {
+	_, dataSource := dbDao.loadDataSource()
+	stats := DBDaoStats{}
+	if dataSource != nil {
+		stats.DBStats = dataSource.Stats()
+	}
+	if dbDao.stats != nil {
+		stats.Queries = atomic.LoadInt64(&dbDao.stats.queries)
+		stats.Execs = atomic.LoadInt64(&dbDao.stats.execs)
+		stats.Errors = atomic.LoadInt64(&dbDao.stats.errors)
+		stats.TxCommitted = atomic.LoadInt64(&dbDao.stats.txCommitted)
+		stats.TxRolledBack = atomic.LoadInt64(&dbDao.stats.txRolledBack)
+		stats.SlowSQL = atomic.LoadInt64(&dbDao.stats.slowSQL)
+	}
+	return stats
+}
+
+// getStats 获取dbConnection所属DBDao的计数器
+func (dbConnection *dataBaseConnection) getStats() *dbDaoStats {
+	if dbConnection.dbDao == nil {
+		return nil
+	}
+	return dbConnection.dbDao.stats
+}
+
+// statsStatement 统计一次语句的执行,exec为true是更新语句
+func (dbConnection *dataBaseConnection) statsStatement(exec bool, err error) {
+	stats := dbConnection.getStats()
+	if stats == nil {
+		return
+	}
+	if exec {
+		atomic.AddInt64(&stats.execs, 1)
+	} else {
+		atomic.AddInt64(&stats.queries, 1)
+	}
+	if err != nil {
+		atomic.AddInt64(&stats.errors, 1)
+	}
+}
+
+// statsSlowSQL 统计一次慢SQL
+func (dbConnection *dataBaseConnection) statsSlowSQL() {
+	if stats := dbConnection.getStats(); stats != nil {
+		atomic.AddInt64(&stats.slowSQL, 1)
+	}
+}
+
+// statsTx 统计一次事务的结束,committed为true是提交成功
+func (dbConnection *dataBaseConnection) statsTx(committed bool) {
+	stats := dbConnection.getStats()
+	if stats == nil {
+		return
+	}
+	if committed {
+		atomic.AddInt64(&stats.txCommitted, 1)
+	} else {
+		atomic.AddInt64(&stats.txRolledBack, 1)
+	}
+}
+
+// statsMetric prometheus的指标
+type statsMetric struct {
+	name       string
+	metricType string
+	help       string
+	value      func(stats *DBDaoStats) float64
+}
+
+// statsMetrics 输出的指标,db标签是DBDao的名称
+var statsMetrics = []statsMetric{
+	{"zorm_db_max_open_connections", "gauge", "Maximum number of open connections to the database.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.MaxOpenConnections) }},
+	{"zorm_db_open_connections", "gauge", "The number of established connections both in use and idle.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.OpenConnections) }},
+	{"zorm_db_in_use_connections", "gauge", "The number of connections currently in use.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.InUse) }},
+	{"zorm_db_idle_connections", "gauge", "The number of idle connections.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.Idle) }},
+	{"zorm_db_wait_count_total", "counter", "The total number of connections waited for.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.WaitCount) }},
+	{"zorm_db_wait_duration_seconds_total", "counter", "The total time blocked waiting for a new connection.", func(stats *DBDaoStats) float64 { return stats.DBStats.WaitDuration.Seconds() }},
+	{"zorm_db_max_idle_closed_total", "counter", "The total number of connections closed due to SetMaxIdleConns.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.MaxIdleClosed) }},
+	{"zorm_db_max_lifetime_closed_total", "counter", "The total number of connections closed due to SetConnMaxLifetime.", func(stats *DBDaoStats) float64 { return float64(stats.DBStats.MaxLifetimeClosed) }},
+	{"zorm_queries_total", "counter", "The total number of queries.", func(stats *DBDaoStats) float64 { return float64(stats.Queries) }},
+	{"zorm_execs_total", "counter", "The total number of execs.", func(stats *DBDaoStats) float64 { return float64(stats.Execs) }},
+	{"zorm_errors_total", "counter", "The total number of statement errors.", func(stats *DBDaoStats) float64 { return float64(stats.Errors) }},
+	{"zorm_transactions_committed_total", "counter", "The total number of committed transactions.", func(stats *DBDaoStats) float64 { return float64(stats.TxCommitted) }},
+	{"zorm_transactions_rolled_back_total", "counter", "The total number of rolled back transactions.", func(stats *DBDaoStats) float64 { return float64(stats.TxRolledBack) }},
+	{"zorm_slow_sql_total", "counter", "The total number of statements slower than SlowSQLMillis.", func(stats *DBDaoStats) float64 { return float64(stats.SlowSQL) }},
+}
+
+// NewStatsHandler 创建http.Handler,使用prometheus的文本格式输出DBDao的统计,key是db标签的值
+// dbDaos为nil时,每次请求输出zorm.RegisterDBDao注册的DBDao,以及名称为default的defaultDao
+// NewStatsHandler Create an http.Handler that serves the DBDao statistics in Prometheus text exposition format, the key is the value of the db label
+// If dbDaos is nil, the DBDaos registered by zorm.RegisterDBDao and the defaultDao named default are served on each request
+func NewStatsHandler(dbDaos map[string]*DBDao) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		daos := dbDaos
+		if daos == nil {
+			daos = statsDBDaos()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(wrapStatsText(daos))
+	})
+}
+
+// statsDBDaos 获取注册的DBDao和defaultDao
+func statsDBDaos() map[string]*DBDao {
+	daos := make(map[string]*DBDao)
+	dbDaoRegistry.Range(func(key, value interface{}) bool {
+		daos[key.(string)] = value.(*DBDao)
+		return true
+	})
+	if _, has := daos["default"]; !has && defaultDao != nil {
+		daos["default"] = defaultDao
+	}
+	return daos
+}
+
+// wrapStatsText 生成prometheus的文本格式
+func wrapStatsText(dbDaos map[string]*DBDao) []byte {
+	names := make([]string, 0, len(dbDaos))
+	for name := range dbDaos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	allStats := make([]DBDaoStats, len(names))
+	for i, name := range names {
+		allStats[i] = dbDaos[name].Stats()
+	}
+	var buf bytes.Buffer
+	for _, metric := range statsMetrics {
+		buf.WriteString("# HELP " + metric.name + " " + metric.help + "\n")
+		buf.WriteString("# TYPE " + metric.name + " " + metric.metricType + "\n")
+		for i, name := range names {
+			buf.WriteString(metric.name + `{db="` + escapeLabelValue(name) + `"} `)
+			buf.WriteString(strconv.FormatFloat(metric.value(&allStats[i]), 'g', -1, 64))
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// labelValueReplacer prometheus标签值需要转义的字符
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue 转义prometheus的标签值
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
@@ -122,6 +122,8 @@ func structFieldInfo(typeOf *reflect.Type) error {
 				tagColumnValueLower := strings.ToLower(tagColumnValue)
 				dbColumnFieldMap[tagColumnValueLower] = field
 				dbColumnFieldNameSlice = append(dbColumnFieldNameSlice, tagColumnValueLower)
+				//记录 zorm:"sensitive" 的字段,Finder和EntityMap的SQL日志也脱敏
+				registerSensitiveColumn(&field)
 				//structFieldTagMap[fieldName] = tagColumnValue
 			}
 
@@ -0,0 +1,266 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogLevel 日志级别,数值和log/slog的级别一致
+// LogLevel The log level, the values are consistent with log/slog levels
+type LogLevel int
+
+const (
+	// LogLevelDebug 调试,SlowSQLMillis大于0时,没有超过阈值的SQL语句使用这个级别
+	LogLevelDebug LogLevel = -4
+	// LogLevelInfo 信息,默认级别.SlowSQLMillis等于0时,SQL语句使用这个级别
+	LogLevelInfo LogLevel = 0
+	// LogLevelWarn 警告,慢SQL使用这个级别
+	LogLevelWarn LogLevel = 4
+	// LogLevelError 错误
+	LogLevelError LogLevel = 8
+	// LogLevelOff 关闭日志
+	LogLevelOff LogLevel = 16
+)
+
+// String 日志级别的名称
+func (level LogLevel) String() string {
+	switch level {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	case LogLevelOff:
+		return "OFF"
+	}
+	return "LEVEL(" + strconv.Itoa(int(level)) + ")"
+}
+
+// 日志字段的名称
+// The keys of log fields
+const (
+	LogFieldSQL          = "sql"
+	LogFieldArgs         = "args"
+	LogFieldDuration     = "duration"
+	LogFieldRowsAffected = "rows_affected"
+	LogFieldDialect      = "dialect"
+	LogFieldCaller       = "caller"
+	LogFieldTxID         = "tx_id"
+	LogFieldError        = "error"
+)
+
+// LogField 结构化日志的字段
+// LogField The field of structured log
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger 结构化日志接口,配置zorm.DefaultLogger之后,zorm的SQL语句,错误和panic日志都使用Logger输出,字段见LogFieldXxx
+// 内置zorm.NewStdLogger,go1.21及以上版本还有zorm.NewSlogLogger
+// Logger The structured log interface, after zorm.DefaultLogger is configured, SQL, error and panic logs of zorm are written by Logger, see LogFieldXxx for fields
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, fields ...LogField)
+}
+
+// LoggerEnabler Logger可以选择实现的接口,返回日志级别是否输出.不输出时zorm不再组装日志字段,例如获取业务代码的位置
+// LoggerEnabler The optional interface of Logger, returns whether the level is enabled. zorm does not build the fields, such as the caller, when it is disabled
+type LoggerEnabler interface {
+	Enabled(ctx context.Context, level LogLevel) bool
+}
+
+// loggerEnabled Logger是否输出这个级别的日志,没有实现LoggerEnabler时输出
+func loggerEnabled(logger Logger, ctx context.Context, level LogLevel) bool {
+	enabler, ok := logger.(LoggerEnabler)
+	return !ok || enabler.Enabled(ctx, level)
+}
+
+// DefaultLogger zorm使用的结构化日志,默认nil使用FuncPrintSQL,FuncLogError和FuncLogPanic.需要在init里设置
+// 每个DBDao的日志级别使用DataSourceConfig.LogLevel配置
+// DefaultLogger The structured logger used by zorm, default nil uses FuncPrintSQL, FuncLogError and FuncLogPanic. Set it in init
+var DefaultLogger Logger = nil
+
+// logMessageSQL SQL语句日志的消息
+const logMessageSQL = "sql"
+
+// printSQLBeforeExec SlowSQLMillis等于0并且没有配置DefaultLogger时,执行前使用FuncPrintSQL输出SQL语句,不计算执行时间.
+// 语句阻塞或者进程崩溃时,日志里也能看到正在执行的SQL
+func (dbConnection *dataBaseConnection) printSQLBeforeExec(ctx context.Context, sqlstr string, args []interface{}) {
+	if dbConnection.config.SlowSQLMillis != 0 || DefaultLogger != nil {
+		return
+	}
+	FuncPrintSQL(ctx, sqlstr, redactArgs(ctx, sqlstr, args), 0)
+}
+
+// logSQL 输出SQL语句的日志.配置了DefaultLogger时输出结构化日志,否则兼容FuncPrintSQL
+// rowsAffected小于0表示没有影响的行数,敏感字段的参数使用SensitiveArgMask脱敏
+func (dbConnection *dataBaseConnection) logSQL(ctx context.Context, sqlstr string, args []interface{}, duration time.Duration, rowsAffected int64, slow bool, err error) {
+	slowSQLMillis := dbConnection.config.SlowSQLMillis
+	if slowSQLMillis < 0 {
+		return
+	}
+	logger := DefaultLogger
+	if logger == nil {
+		//等于0时已经在执行前由printSQLBeforeExec输出;大于0是只输出慢SQL
+		if slow {
+			FuncPrintSQL(ctx, sqlstr, redactArgs(ctx, sqlstr, args), duration.Milliseconds())
+		}
+		return
+	}
+	level := LogLevelDebug
+	if slow {
+		level = LogLevelWarn
+	} else if slowSQLMillis == 0 {
+		level = LogLevelInfo
+	}
+	if level < dbConnection.config.LogLevel || !loggerEnabled(logger, ctx, level) {
+		return
+	}
+	fields := []LogField{
+		{LogFieldSQL, sqlstr},
+		{LogFieldArgs, redactArgs(ctx, sqlstr, args)},
+		{LogFieldDuration, duration},
+		{LogFieldDialect, dbConnection.config.Dialect},
+		{LogFieldCaller, businessCaller()},
+	}
+	if rowsAffected >= 0 {
+		fields = append(fields, LogField{LogFieldRowsAffected, rowsAffected})
+	}
+	if dbConnection.txRecord != nil {
+		fields = append(fields, LogField{LogFieldTxID, dbConnection.txRecord.id})
+	}
+	if err != nil {
+		fields = append(fields, LogField{LogFieldError, err})
+	}
+	logger.Log(ctx, level, logMessageSQL, fields...)
+}
+
+// logSnapshot 日志字段的快照.异步输出的日志(事务超时告警,慢查询分析)使用快照,不读取其他goroutine正在修改的dbConnection
+type logSnapshot struct {
+	dialect  string
+	logLevel LogLevel
+	//txID 事务的ID,0表示没有事务
+	txID uint64
+}
+
+// contextLogSnapshotValueKey 把logSnapshot放到context里使用的key
+const contextLogSnapshotValueKey = wrapContextStringKey("contextLogSnapshotValueKey")
+
+// logSnapshot 在执行语句的goroutine中获取日志字段的快照
+func (dbConnection *dataBaseConnection) logSnapshot() *logSnapshot {
+	snapshot := &logSnapshot{dialect: dbConnection.config.Dialect, logLevel: dbConnection.config.LogLevel}
+	if dbConnection.txRecord != nil {
+		snapshot.txID = dbConnection.txRecord.id
+	}
+	return snapshot
+}
+
+// getLogSnapshot 获取ctx中的日志字段,优先使用快照,没有快照时读取ctx中的dbConnection
+func getLogSnapshot(ctx context.Context) *logSnapshot {
+	if snapshot, ok := ctx.Value(contextLogSnapshotValueKey).(*logSnapshot); ok {
+		return snapshot
+	}
+	dbConnection, _ := getDBConnectionFromContext(ctx)
+	if dbConnection == nil || dbConnection.config == nil {
+		return nil
+	}
+	return dbConnection.logSnapshot()
+}
+
+// logStructuredError 使用DefaultLogger输出错误日志,ctx中有dbConnection时增加方言和事务的字段,并使用DBDao的日志级别
+func logStructuredError(logger Logger, ctx context.Context, err error) {
+	//NewDBDao方法里的异常,ctx为nil
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	snapshot := getLogSnapshot(ctx)
+	if snapshot != nil && LogLevelError < snapshot.logLevel {
+		return
+	}
+	if !loggerEnabled(logger, ctx, LogLevelError) {
+		return
+	}
+	fields := []LogField{{LogFieldError, err}, {LogFieldCaller, businessCaller()}}
+	if snapshot != nil {
+		fields = append(fields, LogField{LogFieldDialect, snapshot.dialect})
+		if snapshot.txID != 0 {
+			fields = append(fields, LogField{LogFieldTxID, snapshot.txID})
+		}
+	}
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	logger.Log(ctx, LogLevelError, msg, fields...)
+}
+
+// stdLogger 使用标准库log的Logger,输出 level=INFO msg="sql" key=value 格式的文本
+type stdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger 创建使用标准库log的Logger,logger为nil时输出到os.Stderr.输出 level=INFO msg="sql" sql="..." 格式的文本
+// NewStdLogger Create a Logger using the standard log package, writes to os.Stderr if logger is nil. The output looks like level=INFO msg="sql" sql="..."
+func NewStdLogger(logger *log.Logger) Logger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &stdLogger{logger: logger}
+}
+
+// Log 实现Logger接口
+func (logger *stdLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	var builder strings.Builder
+	builder.WriteString("level=")
+	builder.WriteString(level.String())
+	builder.WriteString(" msg=")
+	builder.WriteString(strconv.Quote(msg))
+	for _, field := range fields {
+		builder.WriteString(" ")
+		builder.WriteString(field.Key)
+		builder.WriteString("=")
+		builder.WriteString(formatLogValue(field.Value))
+	}
+	logger.logger.Output(2, builder.String())
+}
+
+// formatLogValue 格式化字段的值,字符串和error加引号
+func formatLogValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case error:
+		return strconv.Quote(v.Error())
+	case time.Duration:
+		return v.String()
+	case int, int64, uint64, bool:
+		return fmt.Sprint(v)
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
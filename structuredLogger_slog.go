@@ -0,0 +1,63 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogLogger 使用log/slog的Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger 创建使用log/slog的Logger,logger为nil时使用slog.Default().使用slog.NewJSONHandler可以输出JSON格式的日志
+// NewSlogLogger Create a Logger using log/slog, slog.Default() is used if logger is nil. Use slog.NewJSONHandler to output JSON
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger: logger}
+}
+
+// Enabled 实现LoggerEnabler接口,使用slog.Logger的级别
+func (logger *slogLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	return logger.logger.Enabled(ctx, slog.Level(level))
+}
+
+// Log 实现Logger接口,LogLevel的数值和slog.Level一致
+func (logger *slogLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	slogLevel := slog.Level(level)
+	if !logger.logger.Enabled(ctx, slogLevel) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		if err, ok := field.Value.(error); ok {
+			attrs = append(attrs, slog.String(field.Key, err.Error()))
+			continue
+		}
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	logger.logger.LogAttrs(ctx, slogLevel, msg, attrs...)
+}
@@ -0,0 +1,183 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testLogEntry testLogger记录的一条日志
+type testLogEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+// testLogger 记录日志的Logger
+type testLogger struct {
+	entries []testLogEntry
+}
+
+func (logger *testLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	entry := testLogEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, field := range fields {
+		entry.fields[field.Key] = field.Value
+	}
+	logger.entries = append(logger.entries, entry)
+}
+
+// testEnablerLogger 只输出minLevel及以上级别的Logger
+type testEnablerLogger struct {
+	testLogger
+	minLevel LogLevel
+}
+
+func (logger *testEnablerLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	return level >= logger.minLevel
+}
+
+func TestLoggerEnabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger Logger
+		level  LogLevel
+		want   bool
+	}{
+		{"without enabler", &testLogger{}, LogLevelDebug, true},
+		{"enabler below level", &testEnablerLogger{minLevel: LogLevelWarn}, LogLevelInfo, false},
+		{"enabler at level", &testEnablerLogger{minLevel: LogLevelWarn}, LogLevelWarn, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loggerEnabled(tt.logger, context.Background(), tt.level); got != tt.want {
+				t.Errorf("loggerEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLogSnapshot(t *testing.T) {
+	dbConnection := &dataBaseConnection{config: &DataSourceConfig{Dialect: "mysql", LogLevel: LogLevelWarn}, txRecord: &txRecord{id: 7}}
+	connectionCtx := context.WithValue(context.Background(), contextDBConnectionValueKey, dbConnection)
+	snapshot := &logSnapshot{dialect: "postgresql", logLevel: LogLevelError, txID: 9}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want *logSnapshot
+	}{
+		{"empty context", context.Background(), nil},
+		{"connection in context", connectionCtx, &logSnapshot{dialect: "mysql", logLevel: LogLevelWarn, txID: 7}},
+		{"snapshot first", context.WithValue(connectionCtx, contextLogSnapshotValueKey, snapshot), snapshot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getLogSnapshot(tt.ctx)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("getLogSnapshot() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogStructuredError(t *testing.T) {
+	errTest := errors.New("failed")
+	tests := []struct {
+		name       string
+		snapshot   *logSnapshot
+		minLevel   LogLevel
+		wantLogged bool
+		wantFields map[string]interface{}
+	}{
+		{"without snapshot", nil, LogLevelDebug, true, map[string]interface{}{LogFieldError: errTest}},
+		{"with snapshot", &logSnapshot{dialect: "mysql", txID: 3}, LogLevelDebug, true, map[string]interface{}{LogFieldError: errTest, LogFieldDialect: "mysql", LogFieldTxID: uint64(3)}},
+		{"without transaction", &logSnapshot{dialect: "mysql"}, LogLevelDebug, true, map[string]interface{}{LogFieldError: errTest, LogFieldDialect: "mysql"}},
+		{"dao log level off", &logSnapshot{dialect: "mysql", logLevel: LogLevelOff}, LogLevelDebug, false, nil},
+		{"logger disabled", nil, LogLevelOff, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &testEnablerLogger{minLevel: tt.minLevel}
+			ctx := context.Background()
+			if tt.snapshot != nil {
+				ctx = context.WithValue(ctx, contextLogSnapshotValueKey, tt.snapshot)
+			}
+			logStructuredError(logger, ctx, errTest)
+			if (len(logger.entries) == 1) != tt.wantLogged {
+				t.Fatalf("logStructuredError() logged %d entries, want logged %v", len(logger.entries), tt.wantLogged)
+			}
+			if !tt.wantLogged {
+				return
+			}
+			entry := logger.entries[0]
+			if entry.level != LogLevelError || entry.msg != errTest.Error() {
+				t.Errorf("logStructuredError() = (%v, %q), want (%v, %q)", entry.level, entry.msg, LogLevelError, errTest.Error())
+			}
+			if _, ok := entry.fields[LogFieldCaller]; !ok {
+				t.Errorf("logStructuredError() has no %s field", LogFieldCaller)
+			}
+			//caller是业务代码的位置,不比较值
+			if len(entry.fields) != len(tt.wantFields)+1 {
+				t.Errorf("logStructuredError() fields = %v, want %v", entry.fields, tt.wantFields)
+			}
+			for key, value := range tt.wantFields {
+				if entry.fields[key] != value {
+					t.Errorf("logStructuredError() field %s = %v, want %v", key, entry.fields[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestLogSQLLevel(t *testing.T) {
+	tests := []struct {
+		name          string
+		slowSQLMillis int
+		logLevel      LogLevel
+		minLevel      LogLevel
+		slow          bool
+		wantLevel     LogLevel
+		wantLogged    bool
+	}{
+		{"every sql is info", 0, LogLevelDebug, LogLevelDebug, false, LogLevelInfo, true},
+		{"fast sql is debug", 100, LogLevelDebug, LogLevelDebug, false, LogLevelDebug, true},
+		{"slow sql is warn", 100, LogLevelDebug, LogLevelDebug, true, LogLevelWarn, true},
+		{"below dao log level", 100, LogLevelInfo, LogLevelDebug, false, 0, false},
+		{"logger disabled", 0, LogLevelDebug, LogLevelWarn, false, 0, false},
+		{"sql log off", -1, LogLevelDebug, LogLevelDebug, true, 0, false},
+	}
+	defaultLogger := DefaultLogger
+	defer func() { DefaultLogger = defaultLogger }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &testEnablerLogger{minLevel: tt.minLevel}
+			DefaultLogger = logger
+			dbConnection := &dataBaseConnection{config: &DataSourceConfig{Dialect: "mysql", SlowSQLMillis: tt.slowSQLMillis, LogLevel: tt.logLevel}}
+			dbConnection.logSQL(context.Background(), "SELECT 1", nil, time.Millisecond, -1, tt.slow, nil)
+			if (len(logger.entries) == 1) != tt.wantLogged {
+				t.Fatalf("logSQL() logged %d entries, want logged %v", len(logger.entries), tt.wantLogged)
+			}
+			if tt.wantLogged && logger.entries[0].level != tt.wantLevel {
+				t.Errorf("logSQL() level = %v, want %v", logger.entries[0].level, tt.wantLevel)
+			}
+		})
+	}
+}
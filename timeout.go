@@ -0,0 +1,191 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 超时的操作类型
+// The operation type of timeout
+const (
+	// TimeoutOperationQuery 单条语句超时
+	TimeoutOperationQuery = "query"
+	// TimeoutOperationTransaction 事务超时
+	TimeoutOperationTransaction = "transaction"
+)
+
+// TimeoutError 语句或者事务超过了配置的超时时间,事务超时会被回滚.可以使用IsTimeoutError或者errors.As判断
+// TimeoutError The statement or transaction exceeded the configured timeout, a timed out transaction is rolled back
+type TimeoutError struct {
+	//Operation 超时的操作类型,TimeoutOperationQuery或者TimeoutOperationTransaction
+	//Operation The operation type, TimeoutOperationQuery or TimeoutOperationTransaction
+	Operation string
+	//Timeout 配置的超时时间
+	//Timeout The configured timeout
+	Timeout time.Duration
+	//Err 原始的错误
+	//Err The original error
+	Err error
+}
+
+// Error 实现error接口
+func (timeoutError *TimeoutError) Error() string {
+	return fmt.Sprintf("->%s超过超时时间%s:%v", timeoutError.Operation, timeoutError.Timeout, timeoutError.Err)
+}
+
+// Unwrap 返回原始的错误
+func (timeoutError *TimeoutError) Unwrap() error {
+	return timeoutError.Err
+}
+
+// IsTimeoutError 判断错误链中是否有TimeoutError
+// IsTimeoutError Determine whether there is a TimeoutError in the error chain
+func IsTimeoutError(err error) bool {
+	var timeoutError *TimeoutError
+	return errors.As(err, &timeoutError)
+}
+
+// contextQueryTimeoutValueKey 语句超时时间放到context里使用的key
+const contextQueryTimeoutValueKey = wrapContextStringKey("contextQueryTimeoutValueKey")
+
+// contextTxTimeoutValueKey 事务超时时间放到context里使用的key
+const contextTxTimeoutValueKey = wrapContextStringKey("contextTxTimeoutValueKey")
+
+// BindContextQueryTimeout context绑定单条语句的超时时间,优先级高于DataSourceConfig.DefaultQueryTimeout,0表示不限制
+// BindContextQueryTimeout context binds the timeout of a single statement, which takes precedence over DataSourceConfig.DefaultQueryTimeout, 0 means no limit
+func BindContextQueryTimeout(parent context.Context, timeout time.Duration) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextQueryTimeout-->context的parent不能为nil")
+	}
+	if timeout < 0 {
+		return nil, errors.New("->BindContextQueryTimeout-->timeout不能小于0")
+	}
+	ctx := context.WithValue(parent, contextQueryTimeoutValueKey, timeout)
+	return ctx, nil
+}
+
+// BindContextTxTimeout context绑定事务的超时时间,优先级高于DataSourceConfig.DefaultTxTimeout,0表示不限制.必须放到zorm.Transaction方法前调用
+// 只作用于开启事务的zorm.Transaction,超时之后事务会被回滚,返回TimeoutError
+// BindContextTxTimeout context binds the transaction timeout, which takes precedence over DataSourceConfig.DefaultTxTimeout, 0 means no limit
+// Only affects the zorm.Transaction that opens the transaction, a timed out transaction is rolled back and TimeoutError is returned
+func BindContextTxTimeout(parent context.Context, timeout time.Duration) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextTxTimeout-->context的parent不能为nil")
+	}
+	if timeout < 0 {
+		return nil, errors.New("->BindContextTxTimeout-->timeout不能小于0")
+	}
+	ctx := context.WithValue(parent, contextTxTimeoutValueKey, timeout)
+	return ctx, nil
+}
+
+// getContextTimeout 从ctx中获取超时时间,ctx如果没有值使用defaultValue
+func getContextTimeout(ctx context.Context, key wrapContextStringKey, defaultValue time.Duration) time.Duration {
+	timeout, ok := ctx.Value(key).(time.Duration)
+	if !ok {
+		return defaultValue
+	}
+	return timeout
+}
+
+// withTimeout 根据超时时间派生ctx,timeout为0时返回原ctx.如果ctx已经有更早的截止时间,以ctx的为准
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// wrapTimeoutError 派生的ctx超时导致的错误,包装为TimeoutError.parent已经结束说明是调用方的ctx超时或者取消,不包装
+func wrapTimeoutError(parent context.Context, ctx context.Context, operation string, timeout time.Duration, err error) error {
+	if err == nil || timeout <= 0 || IsTimeoutError(err) {
+		return err
+	}
+	if ctx.Err() != context.DeadlineExceeded || parent.Err() != nil {
+		return err
+	}
+	return &TimeoutError{Operation: operation, Timeout: timeout, Err: err}
+}
+
+// withQueryTimeout 获取语句的超时时间,派生ctx
+func (dbConnection *dataBaseConnection) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc, time.Duration) {
+	timeout := getContextTimeout(ctx, contextQueryTimeoutValueKey, dbConnection.config.DefaultQueryTimeout)
+	queryCtx, cancel := withTimeout(ctx, timeout)
+	return queryCtx, cancel, timeout
+}
+
+// wrapStatementTimeout postgresql事务内使用 SET LOCAL statement_timeout 设置数据库的语句超时,超时时间变化时才执行
+// SET LOCAL只在事务内有效,事务结束后恢复数据库的配置
+func (dbConnection *dataBaseConnection) wrapStatementTimeout(ctx context.Context, timeout time.Duration) error {
+	if dbConnection.config.Dialect != "postgresql" || !dbConnection.inTransaction() {
+		return nil
+	}
+	if timeout == dbConnection.statementTimeout {
+		return nil
+	}
+	sqlstr := "SET LOCAL statement_timeout TO DEFAULT"
+	if timeout > 0 {
+		sqlstr = "SET LOCAL statement_timeout = " + strconv.FormatInt(timeoutMillis(timeout), 10)
+	}
+	_, err := dbConnection.executor().ExecContext(ctx, sqlstr)
+	if err != nil {
+		return fmt.Errorf("->wrapStatementTimeout-->%s执行失败:%w", sqlstr, err)
+	}
+	dbConnection.statementTimeout = timeout
+	return nil
+}
+
+// wrapMaxExecutionTimeHint mysql的SELECT语句加入 MAX_EXECUTION_TIME 的hint,由数据库终止超时的查询
+// 如果已经有optimizer hint,合并到同一个注释中,mysql只识别第一个hint注释
+func wrapMaxExecutionTimeHint(dialect string, sqlstr *string, timeout time.Duration) {
+	if dialect != "mysql" || timeout <= 0 {
+		return
+	}
+	sqlTrim := strings.TrimSpace(*sqlstr)
+	if len(sqlTrim) < 7 || !strings.EqualFold(sqlTrim[:6], "SELECT") || !isSQLSpace(sqlTrim[6]) {
+		return
+	}
+	hint := "MAX_EXECUTION_TIME(" + strconv.FormatInt(timeoutMillis(timeout), 10) + ")"
+	rest := strings.TrimLeft(sqlTrim[6:], " \t\r\n")
+	if strings.HasPrefix(rest, "/*+") {
+		*sqlstr = sqlTrim[:6] + " /*+ " + hint + " " + rest[3:]
+		return
+	}
+	*sqlstr = sqlTrim[:6] + " /*+ " + hint + " */ " + rest
+}
+
+// isSQLSpace 是否是SQL语句中的空白字符
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// timeoutMillis 超时时间转换为毫秒,不足1毫秒按照1毫秒处理,避免设置为0变成不限制
+func timeoutMillis(timeout time.Duration) int64 {
+	millis := timeout.Milliseconds()
+	if millis < 1 {
+		millis = 1
+	}
+	return millis
+}
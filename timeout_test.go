@@ -0,0 +1,52 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWrapMaxExecutionTimeHint(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		sqlstr  string
+		timeout time.Duration
+		want    string
+	}{
+		{"mysql select", "mysql", "SELECT * FROM t", 2 * time.Second, "SELECT /*+ MAX_EXECUTION_TIME(2000) */ * FROM t"},
+		{"lower case and spaces", "mysql", "  select\n* FROM t ", time.Second, "select /*+ MAX_EXECUTION_TIME(1000) */ * FROM t"},
+		{"merge existing hint", "mysql", "SELECT /*+ BKA(t) */ * FROM t", 10 * time.Millisecond, "SELECT /*+ MAX_EXECUTION_TIME(10)  BKA(t) */ * FROM t"},
+		{"sub millisecond", "mysql", "SELECT 1", time.Microsecond, "SELECT /*+ MAX_EXECUTION_TIME(1) */ 1"},
+		{"no timeout", "mysql", "SELECT 1", 0, "SELECT 1"},
+		{"update", "mysql", "UPDATE t SET a=1", time.Second, "UPDATE t SET a=1"},
+		{"select prefix identifier", "mysql", "SELECTED 1", time.Second, "SELECTED 1"},
+		{"postgresql", "postgresql", "SELECT 1", time.Second, "SELECT 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.sqlstr
+			wrapMaxExecutionTimeHint(tt.dialect, &got, tt.timeout)
+			if got != tt.want {
+				t.Errorf("wrapMaxExecutionTimeHint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Tracer 追踪接口,参照OpenTelemetry的Tracer,不依赖第三方包.使用OpenTelemetry时,适配trace.Tracer即可
+// Tracer The tracing interface modeled on OpenTelemetry's Tracer without third-party dependencies. Adapt trace.Tracer when using OpenTelemetry
+type Tracer interface {
+	// Start 开始一个span,返回的ctx包含span,zorm会使用返回的ctx继续执行,子span可以关联到父span
+	// Start Start a span, the returned ctx contains the span, zorm continues with the returned ctx so child spans are linked to the parent
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span 追踪的span,参照OpenTelemetry的Span
+// Span The tracing span, modeled on OpenTelemetry's Span
+type Span interface {
+	// SetAttribute 设置span的属性,value是string,int64或者bool
+	// SetAttribute Set the attribute of the span, value is string, int64 or bool
+	SetAttribute(key string, value interface{})
+	// RecordError 记录错误
+	// RecordError Record the error
+	RecordError(err error)
+	// End 结束span
+	// End End the span
+	End()
+}
+
+// span的属性名,和OpenTelemetry的数据库语义约定一致
+// The attribute keys of span, consistent with OpenTelemetry database semantic conventions
+const (
+	// TraceAttributeDBSystem 数据库类型,值是DataSourceConfig.Dialect
+	TraceAttributeDBSystem = "db.system"
+	// TraceAttributeDBStatement 执行的SQL语句,已经处理过方言
+	TraceAttributeDBStatement = "db.statement"
+	// TraceAttributeDBRowsAffected 更新语句影响的行数
+	TraceAttributeDBRowsAffected = "db.rows_affected"
+	// TraceAttributeXID 分布式事务的XID
+	TraceAttributeXID = "db.transaction.xid"
+)
+
+// DefaultTracer zorm使用的Tracer,默认nil不追踪.zorm.Transaction和每条SQL语句都会创建span,语句的span是事务span的子span
+// 需要在init里设置
+// DefaultTracer The Tracer used by zorm, default nil no tracing. zorm.Transaction and each SQL statement create a span, statement spans are children of the transaction span
+var DefaultTracer Tracer = nil
+
+// contextTraceSpanValueKey zorm创建的span放到context里使用的key
+const contextTraceSpanValueKey = wrapContextStringKey("contextTraceSpanValueKey")
+
+// startSpan 开始span,DefaultTracer为nil时返回原ctx和nil
+func startSpan(ctx context.Context, spanName string) (context.Context, Span) {
+	tracer := DefaultTracer
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := tracer.Start(ctx, spanName)
+	if span == nil {
+		return ctx, nil
+	}
+	ctx = context.WithValue(ctx, contextTraceSpanValueKey, span)
+	return ctx, span
+}
+
+// setSpanAttribute 设置ctx中zorm创建的span的属性
+func setSpanAttribute(ctx context.Context, key string, value interface{}) {
+	if span, ok := ctx.Value(contextTraceSpanValueKey).(Span); ok {
+		span.SetAttribute(key, value)
+	}
+}
+
+// endSpan 结束span,记录错误
+func endSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// startStatementSpan 开始SQL语句的span,设置数据库类型,SQL语句和分布式事务的XID
+func (dbConnection *dataBaseConnection) startStatementSpan(ctx context.Context, kind OperationKind, sqlstr string) (context.Context, Span) {
+	ctx, span := startSpan(ctx, "zorm."+string(kind))
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttribute(TraceAttributeDBSystem, dbConnection.config.Dialect)
+	span.SetAttribute(TraceAttributeDBStatement, sqlstr)
+	if xid, ok := ctx.Value("XID").(string); ok && xid != "" {
+		span.SetAttribute(TraceAttributeXID, xid)
+	}
+	return ctx, span
+}
+
+// endExecSpan 结束更新语句的span,记录影响的行数
+func endExecSpan(span Span, res sql.Result, err error) {
+	if span == nil {
+		return
+	}
+	if err == nil && res != nil {
+		if rowsAffected, errAffected := res.RowsAffected(); errAffected == nil {
+			span.SetAttribute(TraceAttributeDBRowsAffected, rowsAffected)
+		}
+	}
+	endSpan(span, err)
+}
@@ -0,0 +1,238 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// txHooks 事务的生命周期钩子函数,保存在dataBaseConnection上,只在最外层的本地事务提交或者回滚时执行
+// txHooks Transaction lifecycle hooks, stored on dataBaseConnection, only run when the outermost local transaction commits or rolls back
+type txHooks struct {
+	beforeCommit  []func(ctx context.Context) error
+	afterCommit   []func(ctx context.Context)
+	afterRollback []func(ctx context.Context)
+}
+
+// txHooksMark 记录创建保存点时钩子函数的数量,回滚到保存点时丢弃之后注册的钩子函数
+type txHooksMark struct {
+	beforeCommit  int
+	afterCommit   int
+	afterRollback int
+}
+
+var errTxHooksNoTransaction = errors.New("->txHooks-->ctx没有事务,请在zorm.Transaction的doTransaction内注册钩子函数")
+
+// BeforeCommit 注册事务提交前执行的函数,按照注册顺序执行,返回error或者panic,事务就会回滚.必须在zorm.Transaction的doTransaction内调用
+// BeforeCommit Register a function to run before the transaction commits, in registration order. If it returns error or panics, the transaction is rolled back
+func BeforeCommit(ctx context.Context, hook func(ctx context.Context) error) error {
+	dbConnection, err := getTxHooksDBConnection(ctx, hook == nil)
+	if err != nil {
+		return err
+	}
+	dbConnection.txHooks.beforeCommit = append(dbConnection.txHooks.beforeCommit, hook)
+	return nil
+}
+
+// AfterCommit 注册事务提交成功后执行的函数,例如发送MQ消息,清理缓存.按照注册顺序执行,panic会被捕获并记录日志.必须在zorm.Transaction的doTransaction内调用
+// AfterCommit Register a function to run after the transaction commits successfully, such as publishing MQ messages, evicting caches
+func AfterCommit(ctx context.Context, hook func(ctx context.Context)) error {
+	dbConnection, err := getTxHooksDBConnection(ctx, hook == nil)
+	if err != nil {
+		return err
+	}
+	dbConnection.txHooks.afterCommit = append(dbConnection.txHooks.afterCommit, hook)
+	return nil
+}
+
+// AfterRollback 注册事务回滚后执行的函数,提交失败也会执行.按照注册顺序执行,panic会被捕获并记录日志.必须在zorm.Transaction的doTransaction内调用
+// 如果在嵌套事务(保存点)内注册,回滚到保存点时就会执行
+// AfterRollback Register a function to run after the transaction rolls back, also run when commit fails
+func AfterRollback(ctx context.Context, hook func(ctx context.Context)) error {
+	dbConnection, err := getTxHooksDBConnection(ctx, hook == nil)
+	if err != nil {
+		return err
+	}
+	dbConnection.txHooks.afterRollback = append(dbConnection.txHooks.afterRollback, hook)
+	return nil
+}
+
+// getTxHooksDBConnection 获取注册钩子函数的dbConnection,必须有事务
+func getTxHooksDBConnection(ctx context.Context, hookIsNil bool) (*dataBaseConnection, error) {
+	if hookIsNil {
+		return nil, errors.New("->txHooks-->hook不能为nil")
+	}
+	dbConnection, err := getDBConnectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dbConnection == nil || !dbConnection.inTransaction() {
+		return nil, errTxHooksNoTransaction
+	}
+	return dbConnection, nil
+}
+
+// txHooksMark 记录当前钩子函数的数量
+func (dbConnection *dataBaseConnection) txHooksMark() txHooksMark {
+	return txHooksMark{
+		beforeCommit:  len(dbConnection.txHooks.beforeCommit),
+		afterCommit:   len(dbConnection.txHooks.afterCommit),
+		afterRollback: len(dbConnection.txHooks.afterRollback),
+	}
+}
+
+// rollbackTxHooksToMark 回滚到保存点,丢弃保存点之后注册的提交钩子,执行保存点之后注册的回滚钩子
+func (dbConnection *dataBaseConnection) rollbackTxHooksToMark(ctx context.Context, mark txHooksMark) {
+	hooks := &dbConnection.txHooks
+	if len(hooks.beforeCommit) > mark.beforeCommit {
+		hooks.beforeCommit = hooks.beforeCommit[:mark.beforeCommit]
+	}
+	if len(hooks.afterCommit) > mark.afterCommit {
+		hooks.afterCommit = hooks.afterCommit[:mark.afterCommit]
+	}
+	if len(hooks.afterRollback) > mark.afterRollback {
+		afterRollback := hooks.afterRollback[mark.afterRollback:]
+		hooks.afterRollback = hooks.afterRollback[:mark.afterRollback]
+		runTxHooks(ctx, "AfterRollback", afterRollback)
+	}
+}
+
+// runBeforeCommitHooks 执行提交前的钩子函数,遇到错误就停止,返回错误
+func (dbConnection *dataBaseConnection) runBeforeCommitHooks(ctx context.Context) (err error) {
+	//钩子函数内可能继续注册钩子函数,使用下标遍历
+	for i := 0; i < len(dbConnection.txHooks.beforeCommit); i++ {
+		err = runBeforeCommitHook(ctx, dbConnection.txHooks.beforeCommit[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBeforeCommitHook 执行一个提交前的钩子函数,panic转换为error
+func runBeforeCommitHook(ctx context.Context, hook func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var errOk bool
+			err, errOk = r.(error)
+			if errOk {
+				err = fmt.Errorf("->BeforeCommit-->recover异常:%w", err)
+			} else {
+				err = fmt.Errorf("->BeforeCommit-->recover异常:%v", r)
+			}
+			FuncLogPanic(ctx, err)
+		}
+	}()
+	err = hook(ctx)
+	if err != nil {
+		err = fmt.Errorf("->BeforeCommit-->钩子函数执行错误:%w", err)
+	}
+	return err
+}
+
+// runAfterCommitHooks 事务提交成功后执行钩子函数,并清空所有的钩子函数
+func (dbConnection *dataBaseConnection) runAfterCommitHooks(ctx context.Context) {
+	afterCommit := dbConnection.txHooks.afterCommit
+	dbConnection.txHooks = txHooks{}
+	runTxHooks(ctx, "AfterCommit", afterCommit)
+}
+
+// runAfterRollbackHooks 事务回滚后执行钩子函数,并清空所有的钩子函数
+func (dbConnection *dataBaseConnection) runAfterRollbackHooks(ctx context.Context) {
+	afterRollback := dbConnection.txHooks.afterRollback
+	dbConnection.txHooks = txHooks{}
+	runTxHooks(ctx, "AfterRollback", afterRollback)
+}
+
+// globalTxHooks 分布式事务分支的提交钩子函数.分支的提交只执行了第一阶段的准备,例如XA PREPARE,
+// 钩子函数和读写分离的写入标记延迟到分布式事务的开启方提交或者回滚之后执行
+type globalTxHooks struct {
+	mutex    sync.Mutex
+	branches []globalTxBranchHooks
+}
+
+// globalTxBranchHooks 一个分支的钩子函数和分支的ctx
+type globalTxBranchHooks struct {
+	ctx context.Context
+	//dbConnection 分支的连接,用于统计事务的结果
+	dbConnection *dataBaseConnection
+	//wrote 分支是否执行过更新语句,提交之后记录读写分离的写入时间
+	wrote         bool
+	afterCommit   []func(ctx context.Context)
+	afterRollback []func(ctx context.Context)
+}
+
+// contextGlobalTxHooksValueKey 分布式事务开启方把globalTxHooks放到context里使用的key
+const contextGlobalTxHooksValueKey = wrapContextStringKey("contextGlobalTxHooksValueKey")
+
+// deferGlobalTxHooks 分支准备成功之后,把钩子函数交给ctx中分布式事务的开启方,ctx中没有开启方返回false.wrote是分支是否执行过更新语句
+func (dbConnection *dataBaseConnection) deferGlobalTxHooks(ctx context.Context, wrote bool) bool {
+	globalHooks, ok := ctx.Value(contextGlobalTxHooksValueKey).(*globalTxHooks)
+	if !ok {
+		return false
+	}
+	hooks := dbConnection.txHooks
+	dbConnection.txHooks = txHooks{}
+	globalHooks.mutex.Lock()
+	globalHooks.branches = append(globalHooks.branches, globalTxBranchHooks{ctx: ctx, dbConnection: dbConnection, wrote: wrote, afterCommit: hooks.afterCommit, afterRollback: hooks.afterRollback})
+	globalHooks.mutex.Unlock()
+	return true
+}
+
+// run 分布式事务结束之后,按照分支准备的顺序统计分支的结果,执行分支的钩子函数
+func (globalHooks *globalTxHooks) run(commit bool) {
+	globalHooks.mutex.Lock()
+	branches := globalHooks.branches
+	globalHooks.branches = nil
+	globalHooks.mutex.Unlock()
+	for _, branch := range branches {
+		branch.dbConnection.statsTx(commit)
+		if commit {
+			if branch.wrote {
+				markReadYourWrites(branch.ctx)
+			}
+			runTxHooks(branch.ctx, "AfterCommit", branch.afterCommit)
+		} else {
+			runTxHooks(branch.ctx, "AfterRollback", branch.afterRollback)
+		}
+	}
+}
+
+// runTxHooks 按照注册顺序执行钩子函数,捕获panic,使用FuncLogPanic记录,不影响后续的钩子函数
+func runTxHooks(ctx context.Context, hookName string, hooks []func(ctx context.Context)) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					var err error
+					if errPanic, errOk := r.(error); errOk {
+						err = fmt.Errorf("->%s-->recover异常:%w", hookName, errPanic)
+					} else {
+						err = fmt.Errorf("->%s-->recover异常:%v", hookName, r)
+					}
+					FuncLogPanic(ctx, err)
+				}
+			}()
+			hook(ctx)
+		}()
+	}
+}
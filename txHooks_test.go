@@ -0,0 +1,85 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGlobalTxHooksRun(t *testing.T) {
+	tests := []struct {
+		name              string
+		commit            bool
+		wrote             bool
+		wantPrimary       bool
+		wantCommitted     int64
+		wantRolledBack    int64
+		wantAfterCommit   int
+		wantAfterRollback int
+	}{
+		{"commit with writes", true, true, true, 1, 0, 1, 0},
+		{"commit without writes", true, false, false, 1, 0, 1, 0},
+		{"rollback with writes", false, true, false, 0, 1, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := BindContextReadYourWrites(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			globalHooks := &globalTxHooks{}
+			ctx = context.WithValue(ctx, contextGlobalTxHooksValueKey, globalHooks)
+			afterCommit, afterRollback := 0, 0
+			dbConnection := &dataBaseConnection{dbDao: &DBDao{stats: &dbDaoStats{}}}
+			dbConnection.txHooks.afterCommit = []func(ctx context.Context){func(ctx context.Context) { afterCommit++ }}
+			dbConnection.txHooks.afterRollback = []func(ctx context.Context){func(ctx context.Context) { afterRollback++ }}
+			if !dbConnection.deferGlobalTxHooks(ctx, tt.wrote) {
+				t.Fatal("deferGlobalTxHooks() = false, want true")
+			}
+			if len(dbConnection.txHooks.afterCommit) != 0 {
+				t.Errorf("deferGlobalTxHooks() did not clear the hooks of the branch")
+			}
+			globalHooks.run(tt.commit)
+			//重复执行不会再次统计和执行钩子函数
+			globalHooks.run(tt.commit)
+			if got := readYourWritesPrimary(ctx); got != tt.wantPrimary {
+				t.Errorf("readYourWritesPrimary() = %v, want %v", got, tt.wantPrimary)
+			}
+			stats := dbConnection.dbDao.stats
+			if stats.txCommitted != tt.wantCommitted || stats.txRolledBack != tt.wantRolledBack {
+				t.Errorf("stats = (%d, %d), want (%d, %d)", stats.txCommitted, stats.txRolledBack, tt.wantCommitted, tt.wantRolledBack)
+			}
+			if afterCommit != tt.wantAfterCommit || afterRollback != tt.wantAfterRollback {
+				t.Errorf("hooks = (%d, %d), want (%d, %d)", afterCommit, afterRollback, tt.wantAfterCommit, tt.wantAfterRollback)
+			}
+		})
+	}
+}
+
+func TestDeferGlobalTxHooksWithoutRoot(t *testing.T) {
+	dbConnection := &dataBaseConnection{}
+	dbConnection.txHooks.afterCommit = []func(ctx context.Context){func(ctx context.Context) {}}
+	if dbConnection.deferGlobalTxHooks(context.Background(), true) {
+		t.Errorf("deferGlobalTxHooks() = true, want false")
+	}
+	if len(dbConnection.txHooks.afterCommit) != 1 {
+		t.Errorf("deferGlobalTxHooks() cleared the hooks without a global transaction root")
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TxLeakThreshold 事务的最长持续时间,超过之后调用FuncTxLeakWarning告警,之后每隔TxLeakThreshold再次告警,直到事务结束.默认0不告警
+// 需要在开启事务前设置,修改之后对新开启的事务生效
+// TxLeakThreshold The maximum duration of a transaction, FuncTxLeakWarning is called after that and every TxLeakThreshold until the transaction ends. Default 0 no warning
+var TxLeakThreshold time.Duration = 0
+
+// FuncTxLeakWarning 事务超过TxLeakThreshold没有结束时的告警函数,默认使用FuncLogError记录日志
+// FuncTxLeakWarning The warning function when a transaction is not finished after TxLeakThreshold, use FuncLogError by default
+var FuncTxLeakWarning func(ctx context.Context, txInfo TxInfo) = defaultTxLeakWarning
+
+// TxInfo 进行中的事务信息,用于排查长事务和没有结束的事务
+// TxInfo The information of an in-flight transaction, used to troubleshoot long-running and leaked transactions
+type TxInfo struct {
+	//ID 事务的序号
+	//ID The sequence of the transaction
+	ID uint64
+	//Dialect 数据库方言
+	//Dialect Database dialect
+	Dialect string
+	//StartTime 事务开启的时间
+	//StartTime The time the transaction began
+	StartTime time.Time
+	//Duration 事务已经持续的时间
+	//Duration How long the transaction has been open
+	Duration time.Duration
+	//Caller 开启事务的业务代码位置,file:line
+	//Caller The business code location that opened the transaction, file:line
+	Caller string
+	//StatementCount 事务内已经执行的语句数量
+	//StatementCount The number of statements executed in the transaction
+	StatementCount int64
+}
+
+// txRecord 注册表中的事务记录
+type txRecord struct {
+	id             uint64
+	dialect        string
+	startTime      time.Time
+	caller         string
+	statementCount int64
+	ctx            context.Context
+	//mutex 保护timer和done
+	mutex sync.Mutex
+	timer *time.Timer
+	done  bool
+}
+
+// txRegistry 进行中的事务注册表,key是*txRecord.zorm有Delete函数,使用sync.Map避免和内置的delete冲突
+var txRegistry sync.Map
+
+// txRegistrySeq 事务的序号
+var txRegistrySeq uint64
+
+// zormPkgPath zorm包的路径,获取开启事务的业务代码位置时跳过zorm内部的调用
+var zormPkgPath = reflect.TypeOf(TxInfo{}).PkgPath() + "."
+
+// ListTransactions 获取所有进行中的事务,按照开启时间排序,可以用于调试接口
+// ListTransactions Get all in-flight transactions, sorted by start time, can be used by a debug endpoint
+func ListTransactions() []TxInfo {
+	now := time.Now()
+	txInfos := make([]TxInfo, 0)
+	txRegistry.Range(func(key, value interface{}) bool {
+		txInfos = append(txInfos, key.(*txRecord).txInfo(now))
+		return true
+	})
+	sort.Slice(txInfos, func(i, j int) bool {
+		return txInfos[i].StartTime.Before(txInfos[j].StartTime)
+	})
+	return txInfos
+}
+
+// txInfo 转换为TxInfo
+func (record *txRecord) txInfo(now time.Time) TxInfo {
+	return TxInfo{
+		ID:             record.id,
+		Dialect:        record.dialect,
+		StartTime:      record.startTime,
+		Duration:       now.Sub(record.startTime),
+		Caller:         record.caller,
+		StatementCount: atomic.LoadInt64(&record.statementCount),
+	}
+}
+
+// trackTx 事务开启后注册到注册表,超过TxLeakThreshold时告警
+func (dbConnection *dataBaseConnection) trackTx(ctx context.Context) {
+	record := &txRecord{
+		id:        atomic.AddUint64(&txRegistrySeq, 1),
+		dialect:   dbConnection.config.Dialect,
+		startTime: time.Now(),
+		caller:    businessCaller(),
+	}
+	//告警在定时器的goroutine中执行,日志使用开启事务时的快照,不读取dbConnection
+	//The warning runs in the timer goroutine, logs use the snapshot taken when the transaction begins instead of reading dbConnection
+	record.ctx = context.WithValue(ctx, contextLogSnapshotValueKey, &logSnapshot{dialect: record.dialect, logLevel: dbConnection.config.LogLevel, txID: record.id})
+	txRegistry.Store(record, struct{}{})
+	threshold := TxLeakThreshold
+	if threshold > 0 {
+		record.mutex.Lock()
+		record.timer = time.AfterFunc(threshold, func() {
+			txLeakWarning(record, threshold)
+		})
+		record.mutex.Unlock()
+	}
+	dbConnection.txRecord = record
+}
+
+// untrackTx 事务结束后从注册表移除
+func (dbConnection *dataBaseConnection) untrackTx() {
+	record := dbConnection.txRecord
+	if record == nil {
+		return
+	}
+	dbConnection.txRecord = nil
+	txRegistry.Delete(record)
+	record.mutex.Lock()
+	record.done = true
+	if record.timer != nil {
+		record.timer.Stop()
+	}
+	record.mutex.Unlock()
+}
+
+// countStatement 事务内执行语句的数量加1
+func (dbConnection *dataBaseConnection) countStatement() {
+	if dbConnection.txRecord != nil {
+		atomic.AddInt64(&dbConnection.txRecord.statementCount, 1)
+	}
+}
+
+// txLeakWarning 事务超时告警,事务还没有结束,间隔threshold再次告警
+func txLeakWarning(record *txRecord, threshold time.Duration) {
+	record.mutex.Lock()
+	done := record.done
+	if !done {
+		record.timer.Reset(threshold)
+	}
+	record.mutex.Unlock()
+	if done {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			FuncLogPanic(record.ctx, fmt.Errorf("->FuncTxLeakWarning-->recover异常:%v", r))
+		}
+	}()
+	FuncTxLeakWarning(record.ctx, record.txInfo(time.Now()))
+}
+
+// defaultTxLeakWarning 默认的告警函数,使用FuncLogError记录日志
+func defaultTxLeakWarning(ctx context.Context, txInfo TxInfo) {
+	FuncLogError(ctx, fmt.Errorf("->TxLeakWarning-->事务%d已经持续%s没有结束,开启位置:%s,执行语句数量:%d", txInfo.ID, txInfo.Duration, txInfo.Caller, txInfo.StatementCount))
+}
+
+// businessCaller 获取调用zorm的业务代码位置,跳过zorm包内部的调用,和LogCallDepth一样用于定位到业务层代码
+func businessCaller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, zormPkgPath) {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			break
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TxPropagation 事务的传播行为,参照spring的事务传播,使用BindContextTxPropagation绑定到ctx,在zorm.Transaction方法前调用
+// TxPropagation Transaction propagation behavior, refer to spring, use BindContextTxPropagation to bind to ctx before zorm.Transaction
+type TxPropagation int
+
+const (
+	// PropagationRequired 默认值,如果ctx有事务就加入,没有就开启新事务
+	// PropagationRequired Default, join the transaction if ctx has one, otherwise start a new transaction
+	PropagationRequired TxPropagation = iota
+	// PropagationRequiresNew 总是开启新事务,如果ctx有事务,从同一个DBDao获取新的连接,挂起外层事务,新事务独立提交或回滚
+	// PropagationRequiresNew Always start a new transaction, if ctx has one, get a new connection from the same DBDao and suspend the outer transaction
+	PropagationRequiresNew
+	// PropagationSupports 如果ctx有事务就加入,没有就以非事务方式执行
+	// PropagationSupports Join the transaction if ctx has one, otherwise execute non-transactionally
+	PropagationSupports
+	// PropagationNotSupported 以非事务方式执行,如果ctx有事务,从同一个DBDao获取新的连接,挂起外层事务
+	// PropagationNotSupported Execute non-transactionally, if ctx has a transaction, get a new connection from the same DBDao and suspend it
+	PropagationNotSupported
+	// PropagationMandatory 必须有事务,如果ctx没有事务,返回错误
+	// PropagationMandatory A transaction is required, return error if ctx has no transaction
+	PropagationMandatory
+	// PropagationNever 以非事务方式执行,如果ctx有事务,返回错误
+	// PropagationNever Execute non-transactionally, return error if ctx has a transaction
+	PropagationNever
+	// PropagationNested 如果ctx有事务,创建保存点作为嵌套事务,出现错误只回滚到保存点.没有事务和PropagationRequired一致
+	// PropagationNested If ctx has a transaction, create a savepoint as nested transaction, otherwise same as PropagationRequired
+	PropagationNested
+)
+
+// contextTxPropagationValueKey 事务传播行为放到context里使用的key
+const contextTxPropagationValueKey = wrapContextStringKey("contextTxPropagationValueKey")
+
+// BindContextTxPropagation context绑定事务的传播行为,必须放到zorm.Transaction方法前调用,只作用于紧接着的Transaction,doTransaction内部默认是PropagationRequired
+// 非事务方式执行时,ctx中的连接没有事务,查询正常执行,更新操作依然需要在内部使用zorm.Transaction开启事务
+// BindContextTxPropagation context binds transaction propagation, must be called before zorm.Transaction, only affects the next Transaction
+// When executed non-transactionally, the connection in ctx has no transaction, update operations still need zorm.Transaction inside
+func BindContextTxPropagation(parent context.Context, propagation TxPropagation) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextTxPropagation-->context的parent不能为nil")
+	}
+	if propagation < PropagationRequired || propagation > PropagationNested {
+		return nil, fmt.Errorf("->BindContextTxPropagation-->不支持的事务传播行为:%d", propagation)
+	}
+	ctx := context.WithValue(parent, contextTxPropagationValueKey, propagation)
+	return ctx, nil
+}
+
+// BindContextNestedTransaction context启用嵌套事务,等同于BindContextTxPropagation(parent, PropagationNested),必须放到zorm.Transaction之前调用
+// 如果ctx已经有事务,Transaction会创建保存点(SAVEPOINT),doTransaction返回error或者panic时只回滚到保存点,不影响外层事务,执行成功就释放保存点
+// BindContextNestedTransaction context enables nested transaction, same as BindContextTxPropagation(parent, PropagationNested)
+// If ctx already has a transaction, Transaction creates a SAVEPOINT, an error only rolls back to the savepoint
+func BindContextNestedTransaction(parent context.Context) (context.Context, error) {
+	return BindContextTxPropagation(parent, PropagationNested)
+}
+
+// getContextTxPropagation 从ctx中获取事务传播行为,没有值返回PropagationRequired
+func getContextTxPropagation(ctx context.Context) TxPropagation {
+	propagation, ok := ctx.Value(contextTxPropagationValueKey).(TxPropagation)
+	if !ok {
+		return PropagationRequired
+	}
+	return propagation
+}
+
+// wrapTxPropagation 根据事务传播行为处理ctx和dbConnection,dbConnection不能为nil
+// 返回的bool为true,表示需要以非事务方式执行doTransaction
+// 需要挂起外层事务时,从同一个DBDao获取新的dbConnection,绑定到新的ctx,外层事务的dbConnection保持不变
+// wrapTxPropagation Process ctx and dbConnection according to the propagation, the returned bool is true means execute non-transactionally
+func wrapTxPropagation(ctx context.Context, dbConnection *dataBaseConnection, propagation TxPropagation) (context.Context, *dataBaseConnection, bool, error) {
+	hasTx := dbConnection.inTransaction()
+	suspend := false
+	noTx := false
+	switch propagation {
+	case PropagationRequired, PropagationNested:
+		return ctx, dbConnection, false, nil
+	case PropagationRequiresNew:
+		suspend = hasTx
+	case PropagationSupports:
+		noTx = !hasTx
+	case PropagationNotSupported:
+		suspend = hasTx
+		noTx = true
+	case PropagationMandatory:
+		if !hasTx {
+			return ctx, dbConnection, false, errors.New("->wrapTxPropagation-->PropagationMandatory要求ctx必须有事务")
+		}
+	case PropagationNever:
+		if hasTx {
+			return ctx, dbConnection, false, errors.New("->wrapTxPropagation-->PropagationNever要求ctx不能有事务")
+		}
+		noTx = true
+	default:
+		return ctx, dbConnection, false, fmt.Errorf("->wrapTxPropagation-->不支持的事务传播行为:%d", propagation)
+	}
+	if !suspend {
+		return ctx, dbConnection, noTx, nil
+	}
+	//挂起外层事务,从同一个DBDao获取新的连接
+	//Suspend the outer transaction, get a new connection from the same DBDao
+	newDBConnection, err := dbConnection.dbDao.newDBConnection()
+	if err != nil {
+		return ctx, dbConnection, noTx, fmt.Errorf("->wrapTxPropagation-->挂起事务获取新的dbConnection失败:%w", err)
+	}
+	ctx = context.WithValue(ctx, contextDBConnectionValueKey, newDBConnection)
+	return ctx, newDBConnection, noTx, nil
+}
+
+// withoutTransaction 以非事务方式执行doTransaction,捕获panic,赋值给err
+// withoutTransaction Execute doTransaction non-transactionally, recover panic and assign it to err
+func withoutTransaction(ctx context.Context, doTransaction func(ctx context.Context) (interface{}, error)) (info interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var errOk bool
+			err, errOk = r.(error)
+			if errOk {
+				err = fmt.Errorf("->Transaction-->withoutTransaction-->recover异常:%w", err)
+			} else {
+				err = fmt.Errorf("->Transaction-->withoutTransaction-->recover异常:%v", r)
+			}
+			FuncLogPanic(ctx, err)
+		}
+	}()
+	info, err = doTransaction(ctx)
+	if err != nil {
+		err = fmt.Errorf("->Transaction-->withoutTransaction-->doTransaction业务执行错误:%w", err)
+		FuncLogError(ctx, err)
+	}
+	return info, err
+}
@@ -0,0 +1,70 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestWrapTxPropagation(t *testing.T) {
+	tests := []struct {
+		name        string
+		propagation TxPropagation
+		hasTx       bool
+		wantNoTx    bool
+		wantErr     bool
+	}{
+		{"required without tx", PropagationRequired, false, false, false},
+		{"required with tx", PropagationRequired, true, false, false},
+		{"nested with tx", PropagationNested, true, false, false},
+		{"requires new without tx", PropagationRequiresNew, false, false, false},
+		{"supports without tx", PropagationSupports, false, true, false},
+		{"supports with tx", PropagationSupports, true, false, false},
+		{"not supported without tx", PropagationNotSupported, false, true, false},
+		{"mandatory without tx", PropagationMandatory, false, false, true},
+		{"mandatory with tx", PropagationMandatory, true, false, false},
+		{"never without tx", PropagationNever, false, true, false},
+		{"never with tx", PropagationNever, true, false, true},
+		{"unsupported propagation", TxPropagation(100), false, false, true},
+		//挂起外层事务需要从dbDao获取新的连接,dbDao为nil时返回错误
+		{"requires new with tx suspends", PropagationRequiresNew, true, false, true},
+		{"not supported with tx suspends", PropagationNotSupported, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConnection := &dataBaseConnection{}
+			if tt.hasTx {
+				dbConnection.tx = &sql.Tx{}
+			}
+			ctx := context.Background()
+			gotCtx, gotDBConnection, gotNoTx, err := wrapTxPropagation(ctx, dbConnection, tt.propagation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wrapTxPropagation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotNoTx != tt.wantNoTx {
+				t.Errorf("wrapTxPropagation() noTx = %v, want %v", gotNoTx, tt.wantNoTx)
+			}
+			if gotDBConnection != dbConnection || gotCtx != ctx {
+				t.Errorf("wrapTxPropagation() replaced the dbConnection or ctx without suspending")
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TxRetryPolicy 事务的重试策略,用于死锁和序列化失败等可以重试的错误,重新执行整个doTransaction,每次都是新的事务
+// 只有开启事务的zorm.Transaction才会重试,加入外层事务的Transaction不重试,由外层事务决定
+// TxRetryPolicy Transaction retry policy for retryable errors such as deadlock and serialization failure, the whole doTransaction is re-run with a fresh transaction
+// Only the zorm.Transaction that opened the transaction retries, the Transaction that joins the outer transaction does not retry
+type TxRetryPolicy struct {
+	//MaxAttempts 最大执行次数,包括第一次执行.小于等于1不重试
+	//MaxAttempts The maximum number of attempts, including the first one. Less than or equal to 1 does not retry
+	MaxAttempts int
+	//InitialBackoff 第一次重试前的等待时间,默认10毫秒
+	//InitialBackoff Wait time before the first retry, default 10 milliseconds
+	InitialBackoff time.Duration
+	//MaxBackoff 最大等待时间,默认1秒
+	//MaxBackoff Maximum wait time, default 1 second
+	MaxBackoff time.Duration
+	//Multiplier 等待时间的增长倍数,默认2
+	//Multiplier Growth multiple of wait time, default 2
+	Multiplier float64
+	//Jitter 等待时间的随机抖动比例,取值0-1,例如0.2是在等待时间上下浮动20%,避免并发事务同时重试再次冲突
+	//Jitter Random jitter ratio of wait time, 0-1, for example 0.2 means plus or minus 20%, to avoid concurrent transactions retrying at the same time
+	Jitter float64
+	//FuncRetryable 判断错误是否可以重试,为nil时使用数据库方言内置的判断,例如mysql 1213,postgresql 40001
+	//FuncRetryable Determine whether the error is retryable, use the built-in classifier of the dialect when nil, such as mysql 1213, postgresql 40001
+	FuncRetryable func(err error) bool
+}
+
+// backoff 第attempt次执行失败后的等待时间,attempt从1开始
+// backoff Wait time after the attempt failed, attempt starts from 1
+func (policy *TxRetryPolicy) backoff(attempt int) time.Duration {
+	initialBackoff := policy.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = 10 * time.Millisecond
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Second
+	}
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(initialBackoff)
+	for i := 1; i < attempt && delay < float64(maxBackoff); i++ {
+		delay = delay * multiplier
+	}
+	if delay > float64(maxBackoff) {
+		delay = float64(maxBackoff)
+	}
+	if policy.Jitter > 0 && policy.Jitter <= 1 {
+		delay = delay * (1 - policy.Jitter + 2*policy.Jitter*rand.Float64())
+	}
+	return time.Duration(delay)
+}
+
+// retryable 判断错误是否可以重试
+func (policy *TxRetryPolicy) retryable(dialect string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if policy.FuncRetryable != nil {
+		return policy.FuncRetryable(err)
+	}
+	funcRetryable := getDialectRetryableError(dialect)
+	if funcRetryable == nil {
+		return false
+	}
+	return funcRetryable(err)
+}
+
+// contextTxRetryPolicyValueKey 事务重试策略放到context里使用的key
+const contextTxRetryPolicyValueKey = wrapContextStringKey("contextTxRetryPolicyValueKey")
+
+// BindContextTxRetryPolicy context绑定事务的重试策略,优先级高于DataSourceConfig.TxRetryPolicy,policy为nil表示不重试.必须放到zorm.Transaction方法前调用
+// BindContextTxRetryPolicy context binds the transaction retry policy, which takes precedence over DataSourceConfig.TxRetryPolicy, nil means no retry
+func BindContextTxRetryPolicy(parent context.Context, policy *TxRetryPolicy) (context.Context, error) {
+	if parent == nil {
+		return nil, errors.New("->BindContextTxRetryPolicy-->context的parent不能为nil")
+	}
+	ctx := context.WithValue(parent, contextTxRetryPolicyValueKey, policy)
+	return ctx, nil
+}
+
+// getTxRetryPolicy 获取事务的重试策略,ctx中绑定的优先,没有就使用数据库配置的
+func getTxRetryPolicy(ctx context.Context, config *DataSourceConfig) *TxRetryPolicy {
+	contextValue := ctx.Value(contextTxRetryPolicyValueKey)
+	if contextValue != nil {
+		policy, _ := contextValue.(*TxRetryPolicy)
+		return policy
+	}
+	if config == nil {
+		return nil
+	}
+	return config.TxRetryPolicy
+}
+
+// waitTxRetryBackoff 等待重试,如果ctx已经取消,返回ctx的错误
+func waitTxRetryBackoff(ctx context.Context, backoff time.Duration) error {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// getDialectRetryableError 获取数据库方言内置的可重试错误判断函数,不支持的数据库返回nil
+func getDialectRetryableError(dialect string) func(err error) bool {
+	switch dialect {
+	case "mysql", "gbase":
+		return IsMySQLRetryableError
+	case "postgresql", "kingbase":
+		return IsPostgreSQLRetryableError
+	case "mssql":
+		return IsMSSQLRetryableError
+	case "oracle", "dm", "shentong":
+		return IsOracleRetryableError
+	case "sqlite":
+		return IsSQLiteRetryableError
+	}
+	return nil
+}
+
+// IsMySQLRetryableError MySQL可以重试的错误,1213死锁,1205锁等待超时
+// IsMySQLRetryableError MySQL retryable error, 1213 deadlock, 1205 lock wait timeout
+func IsMySQLRetryableError(err error) bool {
+	return matchDBErrorCode(err, mysqlErrorCodeSource, "1213", "1205") || matchDBErrorMessage(err, "Error 1213", "Error 1205", "Deadlock found")
+}
+
+// IsPostgreSQLRetryableError PostgreSQL可以重试的错误,40001序列化失败,40P01死锁
+// IsPostgreSQLRetryableError PostgreSQL retryable error, 40001 serialization failure, 40P01 deadlock
+func IsPostgreSQLRetryableError(err error) bool {
+	return matchDBErrorCode(err, postgreSQLErrorCodeSource, "40001", "40P01") || matchDBErrorMessage(err, "SQLSTATE 40001", "SQLSTATE 40P01", "could not serialize access", "deadlock detected")
+}
+
+// IsMSSQLRetryableError SQL Server可以重试的错误,1205死锁牺牲品
+// IsMSSQLRetryableError SQL Server retryable error, 1205 deadlock victim
+func IsMSSQLRetryableError(err error) bool {
+	return matchDBErrorCode(err, mssqlErrorCodeSource, "1205") || matchDBErrorMessage(err, "deadlocked on lock", "deadlock victim")
+}
+
+// IsOracleRetryableError Oracle可以重试的错误,ORA-00060死锁,ORA-08177无法串行访问
+// IsOracleRetryableError Oracle retryable error, ORA-00060 deadlock, ORA-08177 can't serialize access
+func IsOracleRetryableError(err error) bool {
+	return matchDBErrorCode(err, oracleErrorCodeSource, "60", "8177") || matchDBErrorMessage(err, "ORA-00060", "ORA-08177")
+}
+
+// IsSQLiteRetryableError SQLite可以重试的错误,数据库被锁定
+// IsSQLiteRetryableError SQLite retryable error, database is locked
+func IsSQLiteRetryableError(err error) bool {
+	return matchDBErrorMessage(err, "database is locked", "SQLITE_BUSY")
+}
+
+// dbErrorCodeSource 数据库驱动的错误码来源,每种数据库只读取自己驱动的错误码,避免其他驱动的错误码被误判
+type dbErrorCodeSource struct {
+	//sqlState 读取SQLState() string方法,例如pgx的PgError
+	sqlState bool
+	//codeMethod 读取Code() int方法,例如godror的OraErr
+	codeMethod bool
+	//intFields 整数类型的属性,例如mysql.MySQLError.Number
+	intFields []string
+	//stringFields 字符串类型的属性,例如pq.Error.Code
+	stringFields []string
+}
+
+var (
+	// mysqlErrorCodeSource go-sql-driver/mysql的MySQLError.Number
+	mysqlErrorCodeSource = dbErrorCodeSource{intFields: []string{"Number"}}
+	// postgreSQLErrorCodeSource pgx的SQLState(),lib/pq的Error.Code
+	postgreSQLErrorCodeSource = dbErrorCodeSource{sqlState: true, stringFields: []string{"Code"}}
+	// mssqlErrorCodeSource go-mssqldb的Error.Number
+	mssqlErrorCodeSource = dbErrorCodeSource{intFields: []string{"Number"}}
+	// oracleErrorCodeSource godror的Code(),go-ora的OracleError.ErrCode
+	oracleErrorCodeSource = dbErrorCodeSource{codeMethod: true, intFields: []string{"ErrCode"}}
+)
+
+// matchDBErrorCode 判断错误链中驱动的错误码是否匹配,不依赖具体的驱动.支持Unwrap() error和Unwrap() []error的错误链
+// source限定读取错误码的方法和属性,只匹配对应数据库驱动的错误码
+// matchDBErrorCode Determine whether the error code of the driver in the error tree matches, without depending on the driver
+func matchDBErrorCode(err error, source dbErrorCodeSource, codes ...string) bool {
+	return walkErrorTree(err, func(e error) bool {
+		for _, errCode := range source.errorCodes(e) {
+			for _, code := range codes {
+				if errCode == code {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// errorCodes 读取一个错误的错误码
+func (source dbErrorCodeSource) errorCodes(e error) []string {
+	errCodes := make([]string, 0, 2)
+	if source.sqlState {
+		if sqlStateErr, ok := e.(interface{ SQLState() string }); ok {
+			errCodes = append(errCodes, sqlStateErr.SQLState())
+		}
+	}
+	if source.codeMethod {
+		if codeErr, ok := e.(interface{ Code() int }); ok {
+			errCodes = append(errCodes, strconv.Itoa(codeErr.Code()))
+		}
+	}
+	valueOf := reflect.Indirect(reflect.ValueOf(e))
+	if valueOf.Kind() != reflect.Struct {
+		return errCodes
+	}
+	for _, fieldName := range source.intFields {
+		field := valueOf.FieldByName(fieldName)
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			errCodes = append(errCodes, strconv.FormatInt(field.Int(), 10))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			errCodes = append(errCodes, strconv.FormatUint(field.Uint(), 10))
+		}
+	}
+	for _, fieldName := range source.stringFields {
+		field := valueOf.FieldByName(fieldName)
+		if field.Kind() == reflect.String {
+			errCodes = append(errCodes, field.String())
+		}
+	}
+	return errCodes
+}
+
+// walkErrorTree 深度优先遍历错误树,包括Unwrap() error和Unwrap() []error,match返回true时停止
+func walkErrorTree(err error, match func(e error) bool) bool {
+	if err == nil {
+		return false
+	}
+	if match(err) {
+		return true
+	}
+	switch wrapper := err.(type) {
+	case interface{ Unwrap() error }:
+		return walkErrorTree(wrapper.Unwrap(), match)
+	case interface{ Unwrap() []error }:
+		for _, e := range wrapper.Unwrap() {
+			if walkErrorTree(e, match) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchDBErrorMessage 判断错误信息是否包含指定的字符串,用于驱动没有暴露错误码的情况
+func matchDBErrorMessage(err error, messages ...string) bool {
+	if err == nil {
+		return false
+	}
+	errMessage := err.Error()
+	for _, message := range messages {
+		if strings.Contains(errMessage, message) {
+			return true
+		}
+	}
+	return false
+}
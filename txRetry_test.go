@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package zorm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTxRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  TxRetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"default first attempt", TxRetryPolicy{}, 1, 10 * time.Millisecond},
+		{"default second attempt", TxRetryPolicy{}, 2, 20 * time.Millisecond},
+		{"default third attempt", TxRetryPolicy{}, 3, 40 * time.Millisecond},
+		{"default capped", TxRetryPolicy{}, 20, time.Second},
+		{"custom multiplier", TxRetryPolicy{InitialBackoff: time.Millisecond, Multiplier: 3}, 3, 9 * time.Millisecond},
+		{"custom max backoff", TxRetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 150 * time.Millisecond}, 2, 150 * time.Millisecond},
+		{"multiplier below one uses default", TxRetryPolicy{InitialBackoff: time.Millisecond, Multiplier: 0.5}, 2, 2 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.backoff(tt.attempt); got != tt.want {
+				t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxRetryPolicyBackoffJitter(t *testing.T) {
+	policy := &TxRetryPolicy{InitialBackoff: 100 * time.Millisecond, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		got := policy.backoff(1)
+		if got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Fatalf("backoff(1) = %v, want between 80ms and 120ms", got)
+		}
+	}
+}
+
+// testMySQLError 模拟mysql.MySQLError
+type testMySQLError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *testMySQLError) Error() string { return e.Message }
+
+// testPQError 模拟pq.Error,Code是字符串类型
+type testPQError struct {
+	Code string
+}
+
+func (e *testPQError) Error() string { return "pq error" }
+
+// testPgxError 模拟pgx的PgError
+type testPgxError struct {
+	code string
+}
+
+func (e *testPgxError) Error() string    { return "pgx error" }
+func (e *testPgxError) SQLState() string { return e.code }
+
+// testOraError 模拟godror的OraErr
+type testOraError struct {
+	code int
+}
+
+func (e *testOraError) Error() string { return "ora error" }
+func (e *testOraError) Code() int     { return e.code }
+
+// testGoOraError 模拟go-ora的OracleError
+type testGoOraError struct {
+	ErrCode int
+}
+
+func (e *testGoOraError) Error() string { return "go-ora error" }
+
+// testMultiError 实现Unwrap() []error的错误
+type testMultiError struct {
+	errs []error
+}
+
+func (e *testMultiError) Error() string   { return "multi error" }
+func (e *testMultiError) Unwrap() []error { return e.errs }
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		name      string
+		retryable func(err error) bool
+		err       error
+		want      bool
+	}{
+		{"nil", IsMySQLRetryableError, nil, false},
+		{"mysql deadlock number", IsMySQLRetryableError, &testMySQLError{Number: 1213}, true},
+		{"mysql lock wait timeout", IsMySQLRetryableError, &testMySQLError{Number: 1205}, true},
+		{"mysql duplicate key", IsMySQLRetryableError, &testMySQLError{Number: 1062, Message: "Duplicate entry"}, false},
+		{"mysql wrapped", IsMySQLRetryableError, fmt.Errorf("wrap:%w", &testMySQLError{Number: 1213}), true},
+		{"mysql message", IsMySQLRetryableError, errors.New("Error 1213: Deadlock found when trying to get lock"), true},
+		{"mysql in multi error", IsMySQLRetryableError, &testMultiError{errs: []error{errors.New("other"), &testMySQLError{Number: 1213}}}, true},
+		{"mysql in wrapped multi error", IsMySQLRetryableError, fmt.Errorf("wrap:%w", &testMultiError{errs: []error{&testMySQLError{Number: 1205}}}), true},
+		{"mysql ignores sqlstate", IsMySQLRetryableError, &testPgxError{code: "1213"}, false},
+		{"postgresql pq code", IsPostgreSQLRetryableError, &testPQError{Code: "40001"}, true},
+		{"postgresql pgx sqlstate", IsPostgreSQLRetryableError, &testPgxError{code: "40P01"}, true},
+		{"postgresql other sqlstate", IsPostgreSQLRetryableError, &testPgxError{code: "23505"}, false},
+		{"postgresql message", IsPostgreSQLRetryableError, errors.New("ERROR: deadlock detected (SQLSTATE 40P01)"), true},
+		{"mssql deadlock number", IsMSSQLRetryableError, &testMySQLError{Number: 1205}, true},
+		{"oracle godror code", IsOracleRetryableError, &testOraError{code: 60}, true},
+		{"oracle go-ora code", IsOracleRetryableError, &testGoOraError{ErrCode: 8177}, true},
+		{"oracle ignores other driver numbers", IsOracleRetryableError, &testMySQLError{Number: 60}, false},
+		{"oracle ignores string codes", IsOracleRetryableError, &testPQError{Code: "60"}, false},
+		{"oracle message", IsOracleRetryableError, errors.New("ORA-00060: deadlock detected while waiting for resource"), true},
+		{"sqlite busy", IsSQLiteRetryableError, errors.New("database is locked"), true},
+		{"sqlite other", IsSQLiteRetryableError, errors.New("no such table"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxRetryPolicyRetryable(t *testing.T) {
+	deadlock := &testMySQLError{Number: 1213}
+	tests := []struct {
+		name    string
+		policy  TxRetryPolicy
+		dialect string
+		err     error
+		want    bool
+	}{
+		{"dialect classifier", TxRetryPolicy{}, "mysql", deadlock, true},
+		{"unsupported dialect", TxRetryPolicy{}, "clickhouse", deadlock, false},
+		{"nil error", TxRetryPolicy{}, "mysql", nil, false},
+		{"custom classifier", TxRetryPolicy{FuncRetryable: func(err error) bool { return false }}, "mysql", deadlock, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.retryable(tt.dialect, tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxGtridLength MySQL的gtrid最长64字节
+const maxGtridLength = 64
+
+// postgresqlGIDSeparator PostgreSQL的gid是 XID.bqual
+const postgresqlGIDSeparator = "."
+
+// 分支的执行阶段
+const (
+	phaseStart = iota
+	phasePrepare
+	phaseCommit
+	phaseRollbackActive
+	phaseRollbackPrepared
+)
+
+// supportDialect 是否支持的数据库类型
+func supportDialect(dialect string) bool {
+	return dialect == "mysql" || dialect == "postgresql"
+}
+
+// checkXID XID只能包含字母,数字,下划线和中划线,语句中使用字符串常量,避免SQL注入
+func checkXID(xid string) bool {
+	if xid == "" {
+		return false
+	}
+	for _, c := range xid {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == '-' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// wrapBranchSQL 根据数据库方言生成分支各个阶段的语句,一个阶段可能有多条语句
+// wrapBranchSQL Generate the statements of the branch phase according to the dialect, a phase may have multiple statements
+func wrapBranchSQL(dialect string, phase int, xid string, bqual string) ([]string, error) {
+	switch dialect {
+	case "mysql":
+		mysqlXID := "'" + xid + "','" + bqual + "'"
+		switch phase {
+		case phaseStart:
+			return []string{"XA START " + mysqlXID}, nil
+		case phasePrepare:
+			return []string{"XA END " + mysqlXID, "XA PREPARE " + mysqlXID}, nil
+		case phaseCommit:
+			return []string{"XA COMMIT " + mysqlXID}, nil
+		case phaseRollbackActive:
+			return []string{"XA END " + mysqlXID, "XA ROLLBACK " + mysqlXID}, nil
+		case phaseRollbackPrepared:
+			return []string{"XA ROLLBACK " + mysqlXID}, nil
+		}
+	case "postgresql":
+		gid := "'" + xid + postgresqlGIDSeparator + bqual + "'"
+		switch phase {
+		case phaseStart:
+			return []string{"BEGIN"}, nil
+		case phasePrepare:
+			return []string{"PREPARE TRANSACTION " + gid}, nil
+		case phaseCommit:
+			return []string{"COMMIT PREPARED " + gid}, nil
+		case phaseRollbackActive:
+			return []string{"ROLLBACK"}, nil
+		case phaseRollbackPrepared:
+			return []string{"ROLLBACK PREPARED " + gid}, nil
+		}
+	default:
+		return nil, errors.New("->xa.wrapBranchSQL-->不支持的数据库类型:" + dialect)
+	}
+	return nil, fmt.Errorf("->xa.wrapBranchSQL-->不支持的分支阶段:%d", phase)
+}
+
+// execBranchSQL 在分支的独占连接上执行阶段的语句
+func execBranchSQL(ctx context.Context, b *branch, phase int, xid string) error {
+	sqls, err := wrapBranchSQL(b.resource.dialect, phase, xid, b.bqual)
+	if err != nil {
+		return err
+	}
+	for _, sqlstr := range sqls {
+		_, err = b.zormBranch.Conn.ExecContext(ctx, sqlstr)
+		if err != nil {
+			return fmt.Errorf("->xa.execBranchSQL-->%s执行失败:%w", sqlstr, err)
+		}
+	}
+	return nil
+}
+
+// parsePreparedXID 解析数据库中准备好的分支,返回XID和bqual.MySQL的data是gtrid和bqual拼接的,PostgreSQL的gid是 XID.bqual
+func parsePreparedXID(dialect string, data string, gtridLength int) (string, string, bool) {
+	switch dialect {
+	case "mysql":
+		if gtridLength <= 0 || gtridLength > len(data) {
+			return "", "", false
+		}
+		return data[:gtridLength], data[gtridLength:], true
+	case "postgresql":
+		index := strings.LastIndex(data, postgresqlGIDSeparator)
+		if index <= 0 {
+			return "", "", false
+		}
+		return data[:index], data[index+1:], true
+	}
+	return "", "", false
+}
@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePreparedXID(t *testing.T) {
+	tests := []struct {
+		name        string
+		dialect     string
+		data        string
+		gtridLength int
+		wantXID     string
+		wantBqual   string
+		wantOK      bool
+	}{
+		{"mysql", "mysql", "zormxa-node1-abcb1", 15, "zormxa-node1-ab", "cb1", true},
+		{"mysql empty bqual", "mysql", "zormxa-node1-ab", 15, "zormxa-node1-ab", "", true},
+		{"mysql gtrid length too long", "mysql", "abc", 4, "", "", false},
+		{"mysql gtrid length zero", "mysql", "abc", 0, "", "", false},
+		{"postgresql", "postgresql", "zormxa-node1-ab.b1", 0, "zormxa-node1-ab", "b1", true},
+		{"postgresql uses last separator", "postgresql", "a.b.c", 0, "a.b", "c", true},
+		{"postgresql without separator", "postgresql", "zormxa", 0, "", "", false},
+		{"postgresql separator first", "postgresql", ".b1", 0, "", "", false},
+		{"unsupported dialect", "oracle", "a.b", 1, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xid, bqual, ok := parsePreparedXID(tt.dialect, tt.data, tt.gtridLength)
+			if xid != tt.wantXID || bqual != tt.wantBqual || ok != tt.wantOK {
+				t.Errorf("parsePreparedXID() = (%q, %q, %v), want (%q, %q, %v)", xid, bqual, ok, tt.wantXID, tt.wantBqual, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckXID(t *testing.T) {
+	tests := []struct {
+		name string
+		xid  string
+		want bool
+	}{
+		{"letters digits underscore and hyphen", "zormxa-node_1-123", true},
+		{"empty", "", false},
+		{"quote", "a'b", false},
+		{"space", "a b", false},
+		{"dot", "a.b", false},
+		{"non ascii", "事务", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkXID(tt.xid); got != tt.want {
+				t.Errorf("checkXID(%q) = %v, want %v", tt.xid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapBranchSQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		phase   int
+		want    []string
+		wantErr bool
+	}{
+		{"mysql start", "mysql", phaseStart, []string{"XA START 'x1','b1'"}, false},
+		{"mysql prepare", "mysql", phasePrepare, []string{"XA END 'x1','b1'", "XA PREPARE 'x1','b1'"}, false},
+		{"mysql commit", "mysql", phaseCommit, []string{"XA COMMIT 'x1','b1'"}, false},
+		{"mysql rollback active", "mysql", phaseRollbackActive, []string{"XA END 'x1','b1'", "XA ROLLBACK 'x1','b1'"}, false},
+		{"mysql rollback prepared", "mysql", phaseRollbackPrepared, []string{"XA ROLLBACK 'x1','b1'"}, false},
+		{"postgresql start", "postgresql", phaseStart, []string{"BEGIN"}, false},
+		{"postgresql prepare", "postgresql", phasePrepare, []string{"PREPARE TRANSACTION 'x1.b1'"}, false},
+		{"postgresql commit", "postgresql", phaseCommit, []string{"COMMIT PREPARED 'x1.b1'"}, false},
+		{"postgresql rollback active", "postgresql", phaseRollbackActive, []string{"ROLLBACK"}, false},
+		{"postgresql rollback prepared", "postgresql", phaseRollbackPrepared, []string{"ROLLBACK PREPARED 'x1.b1'"}, false},
+		{"unsupported phase", "mysql", 100, nil, true},
+		{"unsupported dialect", "oracle", phaseStart, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wrapBranchSQL(tt.dialect, tt.phase, "x1", "b1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wrapBranchSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapBranchSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xa
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oouxx/zorm/v2"
+)
+
+// 恢复日志记录的状态
+const (
+	//logStateCommit 已经决定提交,所有分支都准备成功
+	logStateCommit = "commit"
+	//logStateDone 所有分支提交完成
+	logStateDone = "done"
+)
+
+// logRecord 恢复日志的一行,JSON格式
+type logRecord struct {
+	XID   string    `json:"xid"`
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// recoveryLog 恢复日志,每行一条JSON记录,只记录提交的决定,没有记录的XID都按照回滚处理
+type recoveryLog struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// openRecoveryLog 以追加方式打开恢复日志
+func openRecoveryLog(path string) (*recoveryLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("->xa.openRecoveryLog-->打开恢复日志失败:%w", err)
+	}
+	return &recoveryLog{path: path, file: file}, nil
+}
+
+// append 追加一条记录,并同步到磁盘
+func (rlog *recoveryLog) append(xid string, state string) error {
+	line, err := json.Marshal(logRecord{XID: xid, State: state, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	rlog.mutex.Lock()
+	defer rlog.mutex.Unlock()
+	if rlog.file == nil {
+		return errors.New("->xa.recoveryLog-->恢复日志已经关闭")
+	}
+	_, err = rlog.file.Write(line)
+	if err != nil {
+		return fmt.Errorf("->xa.recoveryLog-->写入恢复日志失败:%w", err)
+	}
+	err = rlog.file.Sync()
+	if err != nil {
+		return fmt.Errorf("->xa.recoveryLog-->同步恢复日志失败:%w", err)
+	}
+	return nil
+}
+
+// committedXIDs 读取恢复日志,返回已经决定提交,但是没有完成的XID
+func (rlog *recoveryLog) committedXIDs() (map[string]bool, error) {
+	rlog.mutex.Lock()
+	defer rlog.mutex.Unlock()
+	return rlog.readCommittedXIDs()
+}
+
+// readCommittedXIDs 读取恢复日志,调用方需要持有锁
+func (rlog *recoveryLog) readCommittedXIDs() (map[string]bool, error) {
+	file, err := os.Open(rlog.path)
+	if err != nil {
+		return nil, fmt.Errorf("->xa.recoveryLog-->读取恢复日志失败:%w", err)
+	}
+	defer file.Close()
+	xids := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record := logRecord{}
+		//崩溃时最后一行可能没有写完整,忽略无法解析的行.没有完整写入的提交决定,分支一定还没有提交
+		if json.Unmarshal([]byte(line), &record) != nil {
+			continue
+		}
+		switch record.State {
+		case logStateCommit:
+			xids[record.XID] = true
+		case logStateDone:
+			delete(xids, record.XID)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("->xa.recoveryLog-->读取恢复日志失败:%w", err)
+	}
+	return xids, nil
+}
+
+// compact 压缩恢复日志,只保留keep返回true的没有完成的提交记录,先写入临时文件再替换,避免崩溃时丢失记录
+// 持有锁重新读取日志,压缩期间不会丢失新写入的记录
+func (rlog *recoveryLog) compact(keep func(xid string) bool) error {
+	rlog.mutex.Lock()
+	defer rlog.mutex.Unlock()
+	if rlog.file == nil {
+		return errors.New("->xa.recoveryLog-->恢复日志已经关闭")
+	}
+	committed, err := rlog.readCommittedXIDs()
+	if err != nil {
+		return err
+	}
+	var content strings.Builder
+	for xid := range committed {
+		if !keep(xid) {
+			continue
+		}
+		line, err := json.Marshal(logRecord{XID: xid, State: logStateCommit, Time: time.Now()})
+		if err != nil {
+			return err
+		}
+		content.Write(line)
+		content.WriteString("\n")
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(rlog.path), filepath.Base(rlog.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("->xa.recoveryLog-->创建临时文件失败:%w", err)
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.WriteString(content.String())
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	errClose := tmpFile.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, rlog.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("->xa.recoveryLog-->压缩恢复日志失败:%w", err)
+	}
+	//文件已经被替换,重新打开
+	rlog.file.Close()
+	rlog.file, err = os.OpenFile(rlog.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("->xa.recoveryLog-->重新打开恢复日志失败:%w", err)
+	}
+	return nil
+}
+
+// close 关闭恢复日志
+func (rlog *recoveryLog) close() error {
+	rlog.mutex.Lock()
+	defer rlog.mutex.Unlock()
+	if rlog.file == nil {
+		return nil
+	}
+	err := rlog.file.Close()
+	rlog.file = nil
+	return err
+}
+
+// preparedBranch 数据库中已经准备的分支
+type preparedBranch struct {
+	xid   string
+	bqual string
+}
+
+// Recover 恢复崩溃遗留的分支.查询所有注册数据库中已经准备的分支,恢复日志中决定提交的XID继续提交,其他的全部回滚(presumed abort)
+// 建议在应用启动时调用,也可以定时调用,进行中的分布式事务和其他NodeID创建的分支不会被处理
+// Recover Recover the in-doubt branches left by a crash. Prepared branches whose XID is logged as committed are committed, the others are rolled back (presumed abort)
+// Call it when the application starts, or periodically, global transactions in progress are not touched
+func (coordinator *Coordinator) Recover(ctx context.Context) error {
+	//查询分支之前已经决定提交的XID,这些XID没有查询到准备的分支,说明已经提交完成,可以从恢复日志中删除
+	committedBefore, err := coordinator.recoveryLog.committedXIDs()
+	if err != nil {
+		return err
+	}
+	coordinator.mutex.Lock()
+	resources := make([]*resource, len(coordinator.resources))
+	copy(resources, coordinator.resources)
+	coordinator.mutex.Unlock()
+
+	//提交失败的XID,保留在恢复日志中,下次继续提交
+	pending := make(map[string]bool)
+	//有数据库无法查询分支时,保留所有提交记录
+	keepAll := false
+	var errRecover error
+	for _, res := range resources {
+		branches, err := coordinator.listPreparedBranches(ctx, res)
+		if err != nil {
+			errRecover = err
+			keepAll = true
+			continue
+		}
+		if len(branches) < 1 {
+			continue
+		}
+		//查询分支之后再读取恢复日志.listPreparedBranches跳过了进行中的分布式事务,分布式事务先写入提交的决定,再结束,
+		//所以查询时已经结束的分布式事务,提交的决定一定已经写入恢复日志,不会把决定提交的分支回滚
+		committed, err := coordinator.recoveryLog.committedXIDs()
+		if err != nil {
+			errRecover = err
+			keepAll = true
+			continue
+		}
+		for _, pb := range branches {
+			phase := phaseRollbackPrepared
+			if committed[pb.xid] {
+				phase = phaseCommit
+			}
+			err = coordinator.execRecoverSQL(ctx, res, phase, pb)
+			if err != nil {
+				errRecover = err
+				if phase == phaseCommit {
+					pending[pb.xid] = true
+				}
+			}
+		}
+	}
+	//只删除查询分支之前已经决定提交,并且没有遗留分支的XID.进行中和之后决定提交的分布式事务,保留提交记录
+	err = coordinator.recoveryLog.compact(func(xid string) bool {
+		return keepAll || pending[xid] || !committedBefore[xid] || coordinator.isActive(xid)
+	})
+	if err != nil && errRecover == nil {
+		errRecover = err
+	}
+	return errRecover
+}
+
+// listPreparedBranches 查询数据库中本实例的Coordinator创建的,已经准备的分支,不包括进行中的分布式事务.其他NodeID的分支由对应的实例恢复
+func (coordinator *Coordinator) listPreparedBranches(ctx context.Context, res *resource) ([]preparedBranch, error) {
+	ctx, err := res.dbDao.BindContextDBConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var finder *zorm.Finder
+	switch res.dialect {
+	case "mysql":
+		finder = zorm.NewFinder().Append("XA RECOVER")
+	case "postgresql":
+		finder = zorm.NewFinder().Append("SELECT gid AS data FROM pg_prepared_xacts WHERE database=current_database()")
+	default:
+		return nil, errors.New("->xa.Recover-->不支持的数据库类型:" + res.dialect)
+	}
+	rows, err := zorm.QueryMap(ctx, finder, nil)
+	if err != nil {
+		return nil, fmt.Errorf("->xa.Recover-->查询%s已经准备的分支失败:%w", res.name, err)
+	}
+	branches := make([]preparedBranch, 0, len(rows))
+	for _, row := range rows {
+		data := columnString(row["data"])
+		gtridLength, _ := strconv.Atoi(columnString(row["gtrid_length"]))
+		xid, bqual, ok := parsePreparedXID(res.dialect, data, gtridLength)
+		if !ok || !strings.HasPrefix(xid, coordinator.xidPrefix) || !checkXID(xid) || !checkXID(bqual) {
+			continue
+		}
+		if coordinator.isActive(xid) {
+			continue
+		}
+		branches = append(branches, preparedBranch{xid: xid, bqual: bqual})
+	}
+	return branches, nil
+}
+
+// execRecoverSQL 提交或者回滚已经准备的分支.不能在事务内执行,禁用zorm的事务
+func (coordinator *Coordinator) execRecoverSQL(ctx context.Context, res *resource, phase int, pb preparedBranch) error {
+	sqls, err := wrapBranchSQL(res.dialect, phase, pb.xid, pb.bqual)
+	if err != nil {
+		return err
+	}
+	ctx, err = res.dbDao.BindContextDBConnection(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, err = zorm.BindContextDisableTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sqlstr := range sqls {
+		finder := zorm.NewFinder().Append(sqlstr)
+		//XID已经检查过,语句中需要使用字符串常量
+		finder.InjectionCheck = false
+		_, err = zorm.UpdateFinder(ctx, finder)
+		if err != nil {
+			return fmt.Errorf("->xa.Recover-->%s执行%s失败:%w", res.name, sqlstr, err)
+		}
+	}
+	return nil
+}
+
+// columnString QueryMap返回的值转换为字符串,驱动可能返回[]byte,string或者数字
+func columnString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xa
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecoveryLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		records [][2]string
+		keep    map[string]bool
+		want    map[string]bool
+		compact map[string]bool
+	}{
+		{
+			name:    "empty log",
+			want:    map[string]bool{},
+			compact: map[string]bool{},
+		},
+		{
+			name:    "commit without done is pending",
+			records: [][2]string{{"x1", logStateCommit}, {"x2", logStateCommit}, {"x2", logStateDone}},
+			keep:    map[string]bool{"x1": true},
+			want:    map[string]bool{"x1": true},
+			compact: map[string]bool{"x1": true},
+		},
+		{
+			name:    "compact drops records not kept",
+			records: [][2]string{{"x1", logStateCommit}, {"x2", logStateCommit}},
+			keep:    map[string]bool{"x2": true},
+			want:    map[string]bool{"x1": true, "x2": true},
+			compact: map[string]bool{"x2": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "zormxa")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			rlog, err := openRecoveryLog(filepath.Join(dir, "xa.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rlog.close()
+			for _, record := range tt.records {
+				if err = rlog.append(record[0], record[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := rlog.committedXIDs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("committedXIDs() = %v, want %v", got, tt.want)
+			}
+			err = rlog.compact(func(xid string) bool { return tt.keep[xid] })
+			if err != nil {
+				t.Fatal(err)
+			}
+			//压缩之后还可以继续追加
+			if err = rlog.append("x9", logStateCommit); err != nil {
+				t.Fatal(err)
+			}
+			tt.compact["x9"] = true
+			got, err = rlog.committedXIDs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.compact) {
+				t.Errorf("committedXIDs() after compact = %v, want %v", got, tt.compact)
+			}
+		})
+	}
+}
+
+func TestRecoveryLogIgnoresTruncatedLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zormxa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "xa.log")
+	content := `{"xid":"x1","state":"commit","time":"2026-01-01T00:00:00Z"}` + "\n" + `{"xid":"x2","sta`
+	if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rlog, err := openRecoveryLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rlog.close()
+	got, err := rlog.committedXIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"x1": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("committedXIDs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,403 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package xa 内置的XA两阶段提交分布式事务,实现zorm.IGlobalTransaction,不需要seata/hptx的TC服务,支持MySQL和PostgreSQL
+// 同一个进程内的多个数据库使用同一个Coordinator,每个数据库的zorm.Transaction是一个分支,提交时先准备所有分支,记录恢复日志,再提交所有分支
+// 进程崩溃后,调用Coordinator.Recover根据恢复日志提交或者回滚数据库中悬挂的分支
+//
+//	coordinator, err := xa.NewCoordinator(&xa.Config{RecoveryLogPath: "/data/zorm_xa.log", NodeID: "order01"})
+//	coordinator.RegisterResource("order", orderDBDao, "mysql")
+//	coordinator.RegisterResource("stock", stockDBDao, "postgresql")
+//	//所有参与的数据库都要配置 DataSourceConfig.FuncGlobalTransaction = coordinator.FuncGlobalTransaction
+//	//启动时恢复上次崩溃遗留的分支
+//	err = coordinator.Recover(context.Background())
+//
+//	ctx, _ = zorm.BindContextEnableGlobalTransaction(ctx)
+//	ctx, _ = orderDBDao.BindContextDBConnection(ctx)
+//	_, err = zorm.Transaction(ctx, func(ctx context.Context) (interface{}, error) {
+//		//订单库的分支
+//		_, err := zorm.Insert(ctx, &order)
+//		if err != nil {
+//			return nil, err
+//		}
+//		//库存库的分支,使用同一个XID
+//		stockCtx, _ := stockDBDao.BindContextDBConnection(ctx)
+//		return zorm.Transaction(stockCtx, func(ctx context.Context) (interface{}, error) {
+//			return zorm.UpdateFinder(ctx, finder)
+//		})
+//	})
+//
+// MySQL需要5.7.7及以上版本,PostgreSQL需要设置max_prepared_transactions大于0
+//
+// Package xa Built-in XA two-phase commit global transaction, implements zorm.IGlobalTransaction without the TC server of seata/hptx, supports MySQL and PostgreSQL
+// Databases in the same process share a Coordinator, the zorm.Transaction of each database is a branch. On commit all branches are prepared,
+// the decision is written to the recovery log, and then all branches are committed. After a crash, Coordinator.Recover commits or rolls back the in-doubt branches
+package xa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oouxx/zorm/v2"
+)
+
+// DefaultXIDPrefix XID的默认前缀,用于在数据库中识别Coordinator创建的分支
+// DefaultXIDPrefix The default prefix of XID, used to identify the branches created by the Coordinator in the database
+const DefaultXIDPrefix = "zormxa"
+
+// Config Coordinator的配置
+// Config Coordinator configuration
+type Config struct {
+	//RecoveryLogPath 恢复日志的文件路径,记录已经决定提交的XID,必须配置
+	//RecoveryLogPath The file path of the recovery log, records the XIDs decided to commit, required
+	RecoveryLogPath string
+	//XIDPrefix XID的前缀,默认zormxa.多个应用使用同一个数据库时,需要使用不同的前缀,避免恢复时处理其他应用的分支
+	//XIDPrefix The prefix of XID, default zormxa. Applications sharing a database need different prefixes
+	XIDPrefix string
+	//NodeID 实例的唯一标识,只能包含字母,数字和下划线,必须配置.XID使用 XIDPrefix-NodeID- 开头,Recover只处理本实例创建的分支,避免回滚其他副本进行中的分支.
+	//同一个实例重启后需要使用相同的NodeID和RecoveryLogPath,例如StatefulSet的Pod名称
+	//NodeID The unique id of the instance, only letters, digits and underscore, required. XIDs start with XIDPrefix-NodeID-, Recover only handles the branches created by this instance
+	//and does not roll back the branches in progress of other replicas. The same NodeID and RecoveryLogPath must be used after restart, for example the Pod name of a StatefulSet
+	NodeID string
+}
+
+// resource 注册到Coordinator的数据库
+type resource struct {
+	name    string
+	dbDao   *zorm.DBDao
+	dialect string
+}
+
+// Coordinator XA分布式事务的协调者,一个进程创建一个
+// Coordinator The coordinator of XA global transactions, create one per process
+type Coordinator struct {
+	config       Config
+	xidPrefix    string
+	recoveryLog  *recoveryLog
+	mutex        sync.Mutex
+	resources    []*resource
+	transactions map[string]*globalTransaction
+}
+
+// NewCoordinator 创建XA分布式事务的协调者,打开恢复日志
+// NewCoordinator Create the coordinator of XA global transactions and open the recovery log
+func NewCoordinator(config *Config) (*Coordinator, error) {
+	if config == nil {
+		return nil, errors.New("->xa.NewCoordinator-->config不能为nil")
+	}
+	if config.RecoveryLogPath == "" {
+		return nil, errors.New("->xa.NewCoordinator-->config.RecoveryLogPath不能为空")
+	}
+	coordinatorConfig := *config
+	if coordinatorConfig.XIDPrefix == "" {
+		coordinatorConfig.XIDPrefix = DefaultXIDPrefix
+	}
+	if !checkXID(coordinatorConfig.XIDPrefix) {
+		return nil, errors.New("->xa.NewCoordinator-->XIDPrefix只能包含字母,数字,下划线和中划线:" + coordinatorConfig.XIDPrefix)
+	}
+	if coordinatorConfig.NodeID == "" {
+		return nil, errors.New("->xa.NewCoordinator-->config.NodeID不能为空,多个副本需要使用不同的NodeID")
+	}
+	//NodeID不能包含中划线,避免一个实例的前缀是另一个实例前缀的开头
+	if !checkXID(coordinatorConfig.NodeID) || strings.Contains(coordinatorConfig.NodeID, "-") {
+		return nil, errors.New("->xa.NewCoordinator-->NodeID只能包含字母,数字和下划线:" + coordinatorConfig.NodeID)
+	}
+	recoveryLog, err := openRecoveryLog(coordinatorConfig.RecoveryLogPath)
+	if err != nil {
+		return nil, err
+	}
+	coordinator := &Coordinator{
+		config:       coordinatorConfig,
+		xidPrefix:    coordinatorConfig.XIDPrefix + "-" + coordinatorConfig.NodeID + "-",
+		recoveryLog:  recoveryLog,
+		transactions: make(map[string]*globalTransaction),
+	}
+	return coordinator, nil
+}
+
+// Close 关闭恢复日志
+// Close Close the recovery log
+func (coordinator *Coordinator) Close() error {
+	return coordinator.recoveryLog.close()
+}
+
+// RegisterResource 注册参与XA事务的数据库,dialect支持mysql和postgresql.只有注册的数据库才能开启分支,Recover也只处理注册的数据库
+// RegisterResource Register the database participating in XA transactions, dialect supports mysql and postgresql
+func (coordinator *Coordinator) RegisterResource(name string, dbDao *zorm.DBDao, dialect string) error {
+	if name == "" {
+		return errors.New("->xa.RegisterResource-->name不能为空")
+	}
+	if dbDao == nil {
+		return errors.New("->xa.RegisterResource-->dbDao不能为nil")
+	}
+	if !supportDialect(dialect) {
+		return errors.New("->xa.RegisterResource-->不支持的数据库类型:" + dialect)
+	}
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+	for _, res := range coordinator.resources {
+		if res.name == name {
+			return errors.New("->xa.RegisterResource-->重复注册的数据库:" + name)
+		}
+		if res.dbDao == dbDao {
+			return errors.New("->xa.RegisterResource-->dbDao已经注册为:" + res.name)
+		}
+	}
+	coordinator.resources = append(coordinator.resources, &resource{name: name, dbDao: dbDao, dialect: dialect})
+	return nil
+}
+
+// getResource 获取dbDao注册的数据库
+func (coordinator *Coordinator) getResource(dbDao *zorm.DBDao) *resource {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+	for _, res := range coordinator.resources {
+		if res.dbDao == dbDao {
+			return res
+		}
+	}
+	return nil
+}
+
+// FuncGlobalTransaction 配置到zorm.DataSourceConfig.FuncGlobalTransaction.ctx中有XID时,返回进行中的分布式事务,没有就创建新的分布式事务
+// XA的分支必须在同一个进程内,不支持跨进程传递XID
+// FuncGlobalTransaction Configure to zorm.DataSourceConfig.FuncGlobalTransaction. Return the global transaction in progress when ctx has XID, otherwise create a new one
+func (coordinator *Coordinator) FuncGlobalTransaction(ctx context.Context) (zorm.IGlobalTransaction, context.Context, error) {
+	xid, _ := ctx.Value("TX_XID").(string)
+	if xid == "" {
+		return &globalTransaction{coordinator: coordinator}, ctx, nil
+	}
+	coordinator.mutex.Lock()
+	gtx, has := coordinator.transactions[xid]
+	coordinator.mutex.Unlock()
+	if !has {
+		return nil, ctx, errors.New("->xa.FuncGlobalTransaction-->XID不存在或者已经结束,XA事务不支持跨进程传递XID:" + xid)
+	}
+	return gtx, ctx, nil
+}
+
+// 分布式事务的状态
+const (
+	statusActive = iota
+	statusCommitted
+	statusRolledBack
+)
+
+// branch XA事务的分支
+type branch struct {
+	zormBranch *zorm.GlobalTransactionBranch
+	resource   *resource
+	bqual      string
+	//prepared 第一阶段准备成功
+	prepared bool
+	//ended 分支已经提交或者回滚,连接已经关闭
+	ended bool
+}
+
+// globalTransaction XA分布式事务,实现zorm.IGlobalTransaction和zorm.IGlobalTransactionBranch
+type globalTransaction struct {
+	coordinator *Coordinator
+	mutex       sync.Mutex
+	xid         string
+	status      int
+	branches    []*branch
+}
+
+// BeginGTX 开启分布式事务,生成XID
+func (gtx *globalTransaction) BeginGTX(ctx context.Context, globalRootContext context.Context) error {
+	xid := gtx.coordinator.xidPrefix + zorm.FuncGenerateStringID(ctx)
+	if !checkXID(xid) || len(xid) > maxGtridLength {
+		return errors.New("->xa.BeginGTX-->zorm.FuncGenerateStringID生成的XID不合法:" + xid)
+	}
+	gtx.xid = xid
+	gtx.coordinator.mutex.Lock()
+	gtx.coordinator.transactions[xid] = gtx
+	gtx.coordinator.mutex.Unlock()
+	return nil
+}
+
+// CommitGTX 提交分布式事务.所有分支已经准备成功,先把提交的决定写入恢复日志,再逐个提交分支
+// 写入恢复日志之后提交分支失败,返回*zorm.GlobalCommitIncompleteError,由Recover继续提交
+func (gtx *globalTransaction) CommitGTX(ctx context.Context, globalRootContext context.Context) error {
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	defer gtx.coordinator.removeTransaction(gtx.xid)
+	if gtx.status == statusCommitted {
+		return nil
+	}
+	if gtx.status == statusRolledBack {
+		gtx.rollbackBranches(ctx)
+		return errors.New("->xa.CommitGTX-->分布式事务已经回滚,无法提交:" + gtx.xid)
+	}
+	for _, b := range gtx.branches {
+		if !b.prepared {
+			gtx.rollbackBranches(ctx)
+			return fmt.Errorf("->xa.CommitGTX-->分支%s没有准备成功,分布式事务回滚:%s", b.bqual, gtx.xid)
+		}
+	}
+	//记录提交的决定,之后只能提交
+	err := gtx.coordinator.recoveryLog.append(gtx.xid, logStateCommit)
+	if err != nil {
+		gtx.rollbackBranches(ctx)
+		return fmt.Errorf("->xa.CommitGTX-->写入恢复日志失败,分布式事务回滚:%w", err)
+	}
+	gtx.status = statusCommitted
+	var errCommit error
+	for _, b := range gtx.branches {
+		if b.ended {
+			continue
+		}
+		err = execBranchSQL(ctx, b, phaseCommit, gtx.xid)
+		b.ended = true
+		b.zormBranch.Conn.Close()
+		if err != nil && errCommit == nil {
+			errCommit = fmt.Errorf("->xa.CommitGTX-->分支%s提交失败,需要调用Recover继续提交:%w", b.resource.name, err)
+		}
+	}
+	if errCommit != nil {
+		return &zorm.GlobalCommitIncompleteError{Err: errCommit}
+	}
+	err = gtx.coordinator.recoveryLog.append(gtx.xid, logStateDone)
+	if err != nil {
+		//分支已经全部提交,只是没有记录完成,Recover会清理
+		return &zorm.GlobalCommitIncompleteError{Err: err}
+	}
+	return nil
+}
+
+// RollbackGTX 回滚分布式事务的所有分支,可以重复调用
+func (gtx *globalTransaction) RollbackGTX(ctx context.Context, globalRootContext context.Context) error {
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	if gtx.status == statusCommitted {
+		return errors.New("->xa.RollbackGTX-->分布式事务已经提交,无法回滚:" + gtx.xid)
+	}
+	gtx.status = statusRolledBack
+	gtx.coordinator.removeTransaction(gtx.xid)
+	return gtx.rollbackBranches(ctx)
+}
+
+// GetGTXID 获取分布式事务的XID
+func (gtx *globalTransaction) GetGTXID(ctx context.Context, globalRootContext context.Context) (string, error) {
+	if gtx.xid == "" {
+		return "", errors.New("->xa.GetGTXID-->分布式事务没有开启")
+	}
+	return gtx.xid, nil
+}
+
+// BeginBranch 在zorm提供的独占连接上开启分支
+func (gtx *globalTransaction) BeginBranch(ctx context.Context, globalRootContext context.Context, zormBranch *zorm.GlobalTransactionBranch) error {
+	res := gtx.coordinator.getResource(zormBranch.DBDao)
+	if res == nil {
+		return errors.New("->xa.BeginBranch-->数据库没有使用RegisterResource注册")
+	}
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	if gtx.status != statusActive {
+		return errors.New("->xa.BeginBranch-->分布式事务已经结束:" + gtx.xid)
+	}
+	b := &branch{zormBranch: zormBranch, resource: res, bqual: strconv.Itoa(len(gtx.branches) + 1)}
+	err := execBranchSQL(ctx, b, phaseStart, gtx.xid)
+	if err != nil {
+		return err
+	}
+	gtx.branches = append(gtx.branches, b)
+	return nil
+}
+
+// EndBranch 结束分支,commit为true准备分支,保留连接用于第二阶段;为false回滚分支并关闭连接
+func (gtx *globalTransaction) EndBranch(ctx context.Context, globalRootContext context.Context, zormBranch *zorm.GlobalTransactionBranch, commit bool) error {
+	gtx.mutex.Lock()
+	defer gtx.mutex.Unlock()
+	var b *branch
+	for _, gtxBranch := range gtx.branches {
+		if gtxBranch.zormBranch == zormBranch {
+			b = gtxBranch
+			break
+		}
+	}
+	if b == nil {
+		return errors.New("->xa.EndBranch-->分支不存在:" + gtx.xid)
+	}
+	if b.ended {
+		if commit {
+			return errors.New("->xa.EndBranch-->分布式事务已经回滚,分支无法提交:" + gtx.xid)
+		}
+		return nil
+	}
+	if !commit {
+		return gtx.rollbackBranch(ctx, b)
+	}
+	err := execBranchSQL(ctx, b, phasePrepare, gtx.xid)
+	if err != nil {
+		//准备失败,数据库可能已经回滚了分支,再回滚一次,确保释放连接
+		gtx.rollbackBranch(ctx, b)
+		return err
+	}
+	b.prepared = true
+	return nil
+}
+
+// rollbackBranches 回滚所有没有结束的分支,返回第一个错误
+func (gtx *globalTransaction) rollbackBranches(ctx context.Context) error {
+	var errRollback error
+	for _, b := range gtx.branches {
+		err := gtx.rollbackBranch(ctx, b)
+		if err != nil && errRollback == nil {
+			errRollback = err
+		}
+	}
+	return errRollback
+}
+
+// rollbackBranch 回滚分支并关闭连接.已经准备的分支回滚失败时,由Recover回滚
+func (gtx *globalTransaction) rollbackBranch(ctx context.Context, b *branch) error {
+	if b.ended {
+		return nil
+	}
+	phase := phaseRollbackActive
+	if b.prepared {
+		phase = phaseRollbackPrepared
+	}
+	err := execBranchSQL(ctx, b, phase, gtx.xid)
+	b.ended = true
+	b.zormBranch.Conn.Close()
+	if err != nil {
+		return fmt.Errorf("->xa.rollbackBranch-->分支%s回滚失败:%w", b.resource.name, err)
+	}
+	return nil
+}
+
+// removeTransaction 分布式事务结束,从进行中的事务中移除
+func (coordinator *Coordinator) removeTransaction(xid string) {
+	coordinator.mutex.Lock()
+	delete(coordinator.transactions, xid)
+	coordinator.mutex.Unlock()
+}
+
+// isActive XID是否是进行中的分布式事务,Recover不处理进行中的分支
+func (coordinator *Coordinator) isActive(xid string) bool {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+	_, has := coordinator.transactions[xid]
+	return has
+}
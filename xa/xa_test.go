@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xa
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewCoordinator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zormxa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "xa.log")
+	tests := []struct {
+		name          string
+		config        *Config
+		wantXIDPrefix string
+		wantErr       bool
+	}{
+		{"nil config", nil, "", true},
+		{"missing recovery log", &Config{NodeID: "node1"}, "", true},
+		{"missing node id", &Config{RecoveryLogPath: logPath}, "", true},
+		{"node id with hyphen", &Config{RecoveryLogPath: logPath, NodeID: "node-1"}, "", true},
+		{"node id with quote", &Config{RecoveryLogPath: logPath, NodeID: "node'1"}, "", true},
+		{"invalid prefix", &Config{RecoveryLogPath: logPath, NodeID: "node1", XIDPrefix: "a.b"}, "", true},
+		{"default prefix", &Config{RecoveryLogPath: logPath, NodeID: "node_1"}, "zormxa-node_1-", false},
+		{"custom prefix", &Config{RecoveryLogPath: logPath, NodeID: "node1", XIDPrefix: "order"}, "order-node1-", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coordinator, err := NewCoordinator(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCoordinator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer coordinator.Close()
+			if coordinator.xidPrefix != tt.wantXIDPrefix {
+				t.Errorf("NewCoordinator() xidPrefix = %q, want %q", coordinator.xidPrefix, tt.wantXIDPrefix)
+			}
+		})
+	}
+}